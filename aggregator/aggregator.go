@@ -0,0 +1,57 @@
+// Package aggregator runs chef across a fleet of chef waiters.
+// It keeps an inventory of chef waiter endpoints, rolls out runs in batches and
+// reports the state of each node through its own API.
+package aggregator
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/morfien101/chef-waiter/client"
+)
+
+// Run starts the aggregator with the supplied command line arguments and blocks until it fails.
+// It returns the exit code for the process.
+func Run(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("chefwaiter aggregator", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	inventoryPath := fs.String("inventory", "", "JSON file with a list of nodes or a directory with a JSON file per node.")
+	listen := fs.String("listen", "0.0.0.0:8902", "Address to serve the aggregator API on.")
+	poll := fs.Duration("poll", 5*time.Second, "How often to check a node while its run is going.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout for each request to a node.")
+	insecure := fs.Bool("insecure", false, "Skip TLS certificate verification when talking to nodes.")
+	history := fs.Int("history", DefaultRolloutHistory, "Number of finished rollouts to keep.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *history < 0 {
+		fmt.Fprintln(stderr, "--history can not be negative")
+		return 2
+	}
+	if *inventoryPath == "" {
+		fmt.Fprintln(stderr, "--inventory is required")
+		fs.PrintDefaults()
+		return 2
+	}
+	inventory, err := NewInventory(*inventoryPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to open the inventory. Error: %s\n", err)
+		return 1
+	}
+
+	httpClient := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure}, Proxy: http.ProxyFromEnvironment},
+	}
+	manager := NewManager(inventory, *poll, *history, client.WithHTTPClient(httpClient))
+	fmt.Fprintf(stderr, "Aggregator listening on %s\n", *listen)
+	if err := http.ListenAndServe(*listen, NewServer(manager)); err != nil {
+		fmt.Fprintf(stderr, "Aggregator stopped. Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
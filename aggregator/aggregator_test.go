@@ -0,0 +1,328 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/morfien101/chef-waiter/client"
+)
+
+// stubNode is a chef waiter that finishes every run straight away.
+// If queued is set it is a run that requests join unless they ask for a new one.
+type stubNode struct {
+	mu          sync.Mutex
+	exitCode    int
+	runs        int
+	lastGUID    string
+	queued      string
+	lastRequest client.RunRequest
+}
+
+func (sn *stubNode) handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/runs", func(w http.ResponseWriter, r *http.Request) {
+		sn.mu.Lock()
+		defer sn.mu.Unlock()
+		sn.lastRequest = client.RunRequest{}
+		json.NewDecoder(r.Body).Decode(&sn.lastRequest)
+		w.WriteHeader(http.StatusAccepted)
+		if sn.queued != "" && sn.lastRequest.Coalesce != "always-new" {
+			json.NewEncoder(w).Encode(&client.CreatedJob{Job: client.Job{GUID: sn.queued, Status: "registered"}})
+			return
+		}
+		sn.runs++
+		sn.lastGUID = fmt.Sprintf("guid-%d", sn.runs)
+		json.NewEncoder(w).Encode(&client.CreatedJob{Job: client.Job{GUID: sn.lastGUID, Status: "registered"}, Created: true})
+	}).Methods(http.MethodPost)
+	router.HandleFunc("/v2/runs/{guid}", func(w http.ResponseWriter, r *http.Request) {
+		sn.mu.Lock()
+		defer sn.mu.Unlock()
+		job := &client.Job{GUID: mux.Vars(r)["guid"], Status: "complete", ExitCode: sn.exitCode}
+		if sn.exitCode != 0 {
+			job.Status = "failed"
+		}
+		json.NewEncoder(w).Encode(job)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/v2/status", func(w http.ResponseWriter, r *http.Request) {
+		sn.mu.Lock()
+		defer sn.mu.Unlock()
+		json.NewEncoder(w).Encode(&client.Status{Version: "1.0.0", LastRunGUID: sn.lastGUID})
+	}).Methods(http.MethodGet)
+	return router
+}
+
+func (sn *stubNode) runCount() int {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	return sn.runs
+}
+
+// newFleet starts a stub node for each exit code and writes them to an inventory file.
+func newFleet(t *testing.T, exitCodes ...int) (Inventory, map[string]*stubNode, func()) {
+	dir, err := ioutil.TempDir("", "aggregator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubs := make(map[string]*stubNode)
+	servers := []*httptest.Server{}
+	nodes := []Node{}
+	for i, exitCode := range exitCodes {
+		name := fmt.Sprintf("node-%d", i)
+		stubs[name] = &stubNode{exitCode: exitCode}
+		server := httptest.NewServer(stubs[name].handler())
+		servers = append(servers, server)
+		nodes = append(nodes, Node{Name: name, Address: server.URL})
+	}
+	b, _ := json.Marshal(nodes)
+	path := filepath.Join(dir, "inventory.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &FileInventory{Path: path}, stubs, func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func waitForRollout(t *testing.T, m *Manager, id string) Rollout {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rollout, ok := m.Rollout(id)
+		if !ok {
+			t.Fatalf("Rollout %s is missing", id)
+		}
+		if rollout.Status != statusRunning {
+			return rollout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Rollout %s did not finish", id)
+	return Rollout{}
+}
+
+func TestInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "web-2.json"), []byte(`{"address": "http://web-2:8901"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "web-1.json"), []byte(`{"name": "web-1", "address": "http://web-1:8901"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`ignored`), 0644)
+
+	inventory, err := NewInventory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := inventory.Nodes()
+	if err != nil {
+		t.Fatalf("Failed to read the directory inventory. Error: %s", err)
+	}
+	if len(nodes) != 2 || nodes[0].Name != "web-1" || nodes[1].Name != "web-2" {
+		t.Errorf("Expected web-1 and web-2 from the directory. Got: %+v", nodes)
+	}
+
+	path := filepath.Join(dir, "fleet")
+	ioutil.WriteFile(path, []byte(`[{"name": "a", "address": "http://a"}, {"name": "a", "address": "http://b"}]`), 0644)
+	inventory, err = NewInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inventory.Nodes(); err == nil {
+		t.Error("Duplicate node names should be rejected")
+	}
+}
+
+func TestRolloutStopsAtThreshold(t *testing.T) {
+	inventory, stubs, cleanup := newFleet(t, 0, 0, 1, 0, 0)
+	defer cleanup()
+	m := NewManager(inventory, time.Millisecond, DefaultRolloutHistory, client.WithRetries(0, 0))
+
+	started, err := m.Start(RolloutOptions{Concurrency: 1, BatchSize: 2, FailureThreshold: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := waitForRollout(t, m, started.ID)
+	if rollout.Status != statusStopped || rollout.Failures != 1 {
+		t.Errorf("Rollout should have stopped after 1 failure. Got status %s with %d failures", rollout.Status, rollout.Failures)
+	}
+	want := []string{statusComplete, statusComplete, statusFailed, statusSkipped, statusSkipped}
+	for i, result := range rollout.Nodes {
+		if result.Status != want[i] {
+			t.Errorf("%s should be %s. Got: %s", result.Name, want[i], result.Status)
+		}
+	}
+	if failed := rollout.Nodes[2]; failed.ExitCode != 1 || !strings.HasSuffix(failed.LogURL, "/v2/runs/guid-1/logs") {
+		t.Errorf("Failed node should carry the exit code and log link. Got: %+v", failed)
+	}
+	if stubs["node-4"].runCount() != 0 {
+		t.Error("Nodes after the failure threshold should not be run")
+	}
+	if rollout.Summary[statusSkipped] != 2 {
+		t.Errorf("Summary should count 2 skipped nodes. Got: %v", rollout.Summary)
+	}
+}
+
+func TestRolloutSelectedNodes(t *testing.T) {
+	inventory, stubs, cleanup := newFleet(t, 0, 1, 0)
+	defer cleanup()
+	m := NewManager(inventory, time.Millisecond, DefaultRolloutHistory, client.WithRetries(0, 0))
+
+	if _, err := m.Start(RolloutOptions{Nodes: []string{"missing"}}); err == nil {
+		t.Error("A node that is not in the inventory should be rejected")
+	}
+	started, err := m.Start(RolloutOptions{Nodes: []string{"node-0", "node-2"}, CustomRun: "recipe[x]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := waitForRollout(t, m, started.ID)
+	if rollout.Status != statusComplete || len(rollout.Nodes) != 2 {
+		t.Errorf("Rollout should complete on 2 nodes. Got: %+v", rollout)
+	}
+	if stubs["node-1"].runCount() != 0 {
+		t.Error("node-1 was not selected and should not be run")
+	}
+}
+
+func TestRolloutDoesNotJoinQueuedRuns(t *testing.T) {
+	inventory, stubs, cleanup := newFleet(t, 0)
+	defer cleanup()
+	stubs["node-0"].queued = "queued-before-rollout"
+	m := NewManager(inventory, time.Millisecond, DefaultRolloutHistory, client.WithRetries(0, 0))
+
+	started, err := m.Start(RolloutOptions{Reason: "release 1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := waitForRollout(t, m, started.ID)
+	if guid := rollout.Nodes[0].GUID; guid != "guid-1" {
+		t.Errorf("The node should get a new run rather than the queued one. Got: %s", guid)
+	}
+	request := stubs["node-0"].lastRequest
+	if request.Labels["rollout"] != started.ID || request.Reason != "release 1.2" {
+		t.Errorf("The new run should have the rollout label and reason. Got: %+v", request.RunMetadata)
+	}
+}
+
+func TestRolloutHistory(t *testing.T) {
+	inventory, _, cleanup := newFleet(t, 0)
+	defer cleanup()
+	m := NewManager(inventory, time.Millisecond, 2, client.WithRetries(0, 0))
+
+	ids := []string{}
+	for i := 0; i < 4; i++ {
+		started, err := m.Start(RolloutOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForRollout(t, m, started.ID)
+		ids = append(ids, started.ID)
+	}
+	// The newest rollout is not pruned until the next one starts.
+	rollouts := m.Rollouts()
+	if len(rollouts) != 3 || rollouts[0].ID != ids[1] || rollouts[2].ID != ids[3] {
+		t.Errorf("Only the last 2 finished rollouts and the newest should be kept. Got: %+v", rollouts)
+	}
+	if _, ok := m.Rollout(ids[0]); ok {
+		t.Error("The oldest rollout should have been removed")
+	}
+
+	// Running rollouts are never removed.
+	m.mu.Lock()
+	m.rollouts[ids[1]].Status = statusRunning
+	m.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		started, err := m.Start(RolloutOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForRollout(t, m, started.ID)
+	}
+	if _, ok := m.Rollout(ids[1]); !ok {
+		t.Error("A running rollout should not be removed")
+	}
+	if _, ok := m.Rollout(ids[2]); ok {
+		t.Error("The oldest finished rollout should have been removed")
+	}
+}
+
+func TestServer(t *testing.T) {
+	inventory, _, cleanup := newFleet(t, 0, 2)
+	defer cleanup()
+	m := NewManager(inventory, time.Millisecond, DefaultRolloutHistory, client.WithRetries(0, 0))
+	server := httptest.NewServer(NewServer(m))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/rollouts", "application/json", strings.NewReader(`{"failure_threshold": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := Rollout{}
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || started.ID == "" {
+		t.Fatalf("POST /rollouts should return 202 and a rollout. Got: %d %+v", resp.StatusCode, started)
+	}
+	waitForRollout(t, m, started.ID)
+
+	resp, err = http.Get(server.URL + "/rollouts/" + started.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := Rollout{}
+	json.NewDecoder(resp.Body).Decode(&rollout)
+	resp.Body.Close()
+	if rollout.Status != statusComplete || rollout.Summary[statusFailed] != 1 || rollout.Nodes[1].ExitCode != 2 {
+		t.Errorf("Rollout should complete with one failed node. Got: %+v", rollout)
+	}
+
+	resp, err = http.Get(server.URL + "/nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := []NodeStatus{}
+	json.NewDecoder(resp.Body).Decode(&nodes)
+	resp.Body.Close()
+	if len(nodes) != 2 || !nodes[0].Reachable || nodes[1].LastRun == nil || nodes[1].LastRun.ExitCode != 2 {
+		t.Errorf("GET /nodes should show the last run of each node. Got: %+v", nodes)
+	}
+
+	tests := map[string]int{
+		"/rollouts/nope": http.StatusNotFound,
+		"/nope":          http.StatusNotFound,
+	}
+	for path, code := range tests {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope := errorEnvelope{}
+		json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		if resp.StatusCode != code || envelope.Error.Code != "not_found" {
+			t.Errorf("GET %s should be a %d error envelope. Got: %d %+v", path, code, resp.StatusCode, envelope)
+		}
+	}
+
+	resp, err = http.Post(server.URL+"/rollouts", "application/json", strings.NewReader(`{"force": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("force without custom_run should be a bad request. Got: %d", resp.StatusCode)
+	}
+}
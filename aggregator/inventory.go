@@ -0,0 +1,105 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Node is a chef waiter in the inventory.
+type Node struct {
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Inventory is anything that can list the chef waiters that the aggregator manages.
+type Inventory interface {
+	Nodes() ([]Node, error)
+}
+
+// FileInventory reads the nodes from a JSON file holding a list of nodes.
+// The file is read each time the nodes are requested so that it can be
+// changed without restarting the aggregator.
+type FileInventory struct {
+	Path string
+}
+
+// Nodes returns the nodes in the file sorted by name.
+func (fi *FileInventory) Nodes() ([]Node, error) {
+	b, err := ioutil.ReadFile(fi.Path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	if err := json.Unmarshal(b, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to read inventory %s. Error: %s", fi.Path, err)
+	}
+	return checkNodes(nodes)
+}
+
+// DirInventory is a local stand-in for service discovery. Each node registers by
+// writing a JSON file with a single node into the directory and deregisters by
+// removing it.
+type DirInventory struct {
+	Path string
+}
+
+// Nodes returns the nodes from each .json file in the directory sorted by name.
+func (di *DirInventory) Nodes() ([]Node, error) {
+	files, err := ioutil.ReadDir(di.Path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(di.Path, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		node := Node{}
+		if err := json.Unmarshal(b, &node); err != nil {
+			return nil, fmt.Errorf("failed to read node file %s. Error: %s", file.Name(), err)
+		}
+		if node.Name == "" {
+			node.Name = strings.TrimSuffix(file.Name(), ".json")
+		}
+		nodes = append(nodes, node)
+	}
+	return checkNodes(nodes)
+}
+
+// NewInventory will return a DirInventory if path is a directory or a FileInventory if it is a file.
+func NewInventory(path string) (Inventory, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &DirInventory{Path: path}, nil
+	}
+	return &FileInventory{Path: path}, nil
+}
+
+// checkNodes makes sure that every node has a unique name and an address.
+func checkNodes(nodes []Node) ([]Node, error) {
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		if node.Name == "" || node.Address == "" {
+			return nil, fmt.Errorf("inventory nodes need a name and an address. Got: %+v", node)
+		}
+		if seen[node.Name] {
+			return nil, fmt.Errorf("node %s is in the inventory more than once", node.Name)
+		}
+		seen[node.Name] = true
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
@@ -0,0 +1,419 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/morfien101/chef-waiter/client"
+)
+
+// Status values for rollouts and the nodes in them.
+const (
+	statusPending  = "pending"
+	statusRunning  = "running"
+	statusComplete = "complete"
+	statusFailed   = "failed"
+	statusSkipped  = "skipped"
+	// statusStopped is used for a rollout that hit its failure threshold.
+	statusStopped = "stopped"
+)
+
+// Defaults for the rollout options.
+const (
+	defaultConcurrency      = 5
+	defaultFailureThreshold = 1
+	defaultNodeTimeout      = time.Hour
+)
+
+// DefaultRolloutHistory is how many finished rollouts a Manager keeps by default.
+const DefaultRolloutHistory = 50
+
+// RolloutOptions describe how a rollout should run.
+// Nodes are split into batches of BatchSize. A batch must finish before the next one starts
+// and up to Concurrency nodes in a batch run at the same time. Once FailureThreshold nodes
+// have failed no more runs are started and the rollout is stopped.
+type RolloutOptions struct {
+	CustomRun        string   `json:"custom_run,omitempty"`
+	Force            bool     `json:"force,omitempty"`
+	Reason           string   `json:"reason,omitempty"`
+	Concurrency      int      `json:"concurrency"`
+	BatchSize        int      `json:"batch_size"`
+	FailureThreshold int      `json:"failure_threshold"`
+	NodeTimeout      int64    `json:"node_timeout_seconds"`
+	Nodes            []string `json:"nodes,omitempty"`
+}
+
+// setDefaults fills in the options that were not set and checks the rest.
+func (o *RolloutOptions) setDefaults(nodeCount int) error {
+	if o.Concurrency < 0 || o.BatchSize < 0 || o.FailureThreshold < 0 || o.NodeTimeout < 0 {
+		return errors.New("rollout options can not be negative")
+	}
+	if o.Force && o.CustomRun == "" {
+		return errors.New("force can only be used with custom_run")
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = nodeCount
+	}
+	if o.FailureThreshold == 0 {
+		o.FailureThreshold = defaultFailureThreshold
+	}
+	if o.NodeTimeout == 0 {
+		o.NodeTimeout = int64(defaultNodeTimeout / time.Second)
+	}
+	return nil
+}
+
+// NodeResult is the outcome of a rollout on a single node.
+type NodeResult struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Batch    int    `json:"batch"`
+	Status   string `json:"status"`
+	GUID     string `json:"guid,omitempty"`
+	ExitCode int    `json:"exitcode"`
+	Error    string `json:"error,omitempty"`
+	LogURL   string `json:"log_url,omitempty"`
+}
+
+// Rollout is a chef run across many nodes.
+type Rollout struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	Options   RolloutOptions `json:"options"`
+	StartTime int64          `json:"start_time"`
+	EndTime   int64          `json:"end_time"`
+	Failures  int            `json:"failures"`
+	Summary   map[string]int `json:"summary"`
+	Nodes     []NodeResult   `json:"nodes"`
+}
+
+// Manager starts rollouts and keeps track of them.
+type Manager struct {
+	inventory    Inventory
+	pollInterval time.Duration
+	history      int
+	clientOpts   []client.Option
+
+	mu       sync.RWMutex
+	rollouts map[string]*Rollout
+	order    []string
+}
+
+// NewManager will return a Manager for the nodes in the inventory.
+// pollInterval is how often a node is checked while its run is going.
+// history is how many finished rollouts are kept, the oldest are removed first.
+func NewManager(inventory Inventory, pollInterval time.Duration, history int, clientOpts ...client.Option) *Manager {
+	return &Manager{
+		inventory:    inventory,
+		pollInterval: pollInterval,
+		history:      history,
+		clientOpts:   clientOpts,
+		rollouts:     make(map[string]*Rollout),
+	}
+}
+
+// client returns a client for the node.
+func (m *Manager) client(node Node) (*client.Client, error) {
+	return client.New(node.Address, m.clientOpts...)
+}
+
+// Start will validate the options and start a rollout in the background.
+// The returned Rollout is a copy of its state when it started.
+func (m *Manager) Start(opts RolloutOptions) (Rollout, error) {
+	nodes, err := m.selectNodes(opts.Nodes)
+	if err != nil {
+		return Rollout{}, err
+	}
+	if err := opts.setDefaults(len(nodes)); err != nil {
+		return Rollout{}, err
+	}
+
+	r := &Rollout{
+		ID:        uuid.Must(uuid.NewV4()).String(),
+		Status:    statusRunning,
+		Options:   opts,
+		StartTime: time.Now().Unix(),
+		Nodes:     make([]NodeResult, len(nodes)),
+	}
+	for i, node := range nodes {
+		r.Nodes[i] = NodeResult{Name: node.Name, Address: node.Address, Batch: i / opts.BatchSize, Status: statusPending}
+	}
+
+	m.mu.Lock()
+	m.rollouts[r.ID] = r
+	m.order = append(m.order, r.ID)
+	m.prune()
+	snapshot := m.snapshot(r)
+	m.mu.Unlock()
+
+	go m.execute(r, nodes)
+	return snapshot, nil
+}
+
+// prune removes the oldest finished rollouts so that no more than history are kept.
+// Rollouts that are still running are always kept. The caller must hold the lock.
+func (m *Manager) prune() {
+	finished := 0
+	for _, id := range m.order {
+		if m.rollouts[id].Status != statusRunning {
+			finished++
+		}
+	}
+	order := make([]string, 0, len(m.order))
+	for _, id := range m.order {
+		if finished > m.history && m.rollouts[id].Status != statusRunning {
+			delete(m.rollouts, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	m.order = order
+}
+
+// selectNodes returns the nodes from the inventory. If names is not empty only those nodes are returned.
+func (m *Manager) selectNodes(names []string) ([]Node, error) {
+	nodes, err := m.inventory.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != 0 {
+		byName := make(map[string]Node)
+		for _, node := range nodes {
+			byName[node.Name] = node
+		}
+		nodes = []Node{}
+		for _, name := range names {
+			node, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("node %s is not in the inventory", name)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("there are no nodes to roll out to")
+	}
+	return nodes, nil
+}
+
+// Rollout returns a copy of the rollout with the id.
+func (m *Manager) Rollout(id string) (Rollout, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.rollouts[id]
+	if !ok {
+		return Rollout{}, false
+	}
+	return m.snapshot(r), true
+}
+
+// Rollouts returns a copy of every rollout, oldest first.
+func (m *Manager) Rollouts() []Rollout {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rollouts := make([]Rollout, 0, len(m.order))
+	for _, id := range m.order {
+		rollouts = append(rollouts, m.snapshot(m.rollouts[id]))
+	}
+	return rollouts
+}
+
+// snapshot copies the rollout so that it can be read without the lock. The caller must hold the lock.
+func (m *Manager) snapshot(r *Rollout) Rollout {
+	copied := *r
+	copied.Nodes = append([]NodeResult(nil), r.Nodes...)
+	copied.Options.Nodes = append([]string(nil), r.Options.Nodes...)
+	copied.Summary = make(map[string]int)
+	for _, node := range r.Nodes {
+		copied.Summary[node.Status]++
+	}
+	return copied
+}
+
+// update runs f against the node result while holding the lock.
+func (m *Manager) update(r *Rollout, index int, f func(*NodeResult)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&r.Nodes[index])
+	if r.Nodes[index].Status == statusFailed {
+		r.Failures++
+	}
+}
+
+// stopped returns true if the rollout has reached its failure threshold.
+func (m *Manager) stopped(r *Rollout) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return r.Failures >= r.Options.FailureThreshold
+}
+
+// execute runs the rollout batch by batch.
+func (m *Manager) execute(r *Rollout, nodes []Node) {
+	batchSize := r.Options.BatchSize
+	for start := 0; start < len(nodes); start += batchSize {
+		end := start + batchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		slots := make(chan struct{}, r.Options.Concurrency)
+		wg := &sync.WaitGroup{}
+		for i := start; i < end; i++ {
+			slots <- struct{}{}
+			// Check after getting a slot as a run that just finished may have failed.
+			if m.stopped(r) {
+				<-slots
+				m.update(r, i, func(result *NodeResult) { result.Status = statusSkipped })
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				m.runNode(r, i, nodes[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.EndTime = time.Now().Unix()
+	r.Status = statusComplete
+	if r.Failures >= r.Options.FailureThreshold {
+		r.Status = statusStopped
+	}
+}
+
+// runNode triggers a run on the node and waits for it to finish.
+func (m *Manager) runNode(r *Rollout, index int, node Node) {
+	fail := func(err error) {
+		m.update(r, index, func(result *NodeResult) {
+			result.Status = statusFailed
+			result.Error = err.Error()
+		})
+	}
+	c, err := m.client(node)
+	if err != nil {
+		fail(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.Options.NodeTimeout)*time.Second)
+	defer cancel()
+
+	created, err := c.Trigger(ctx, client.RunRequest{
+		CustomRun: r.Options.CustomRun,
+		Force:     r.Options.Force,
+		// A run that was queued before the rollout may not pick up the change, and joining it
+		// would lose the label and reason below.
+		Coalesce: "always-new",
+		// The label lets the runs on the nodes be found from the rollout.
+		RunMetadata: client.RunMetadata{Requester: "aggregator", Reason: r.Options.Reason, Labels: map[string]string{"rollout": r.ID}},
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	job := &created.Job
+	m.update(r, index, func(result *NodeResult) {
+		result.Status = statusRunning
+		result.GUID = job.GUID
+		result.LogURL = logURL(node, job.GUID)
+	})
+
+	job, err = c.Wait(ctx, job.GUID, m.pollInterval)
+	if err != nil {
+		fail(err)
+		return
+	}
+	m.update(r, index, func(result *NodeResult) {
+		result.ExitCode = job.ExitCode
+		result.Status = statusComplete
+		if job.Status != statusComplete {
+			result.Status = statusFailed
+			result.Error = fmt.Sprintf("chef run finished with status %s", job.Status)
+		}
+	})
+}
+
+// logURL is where the chef logs for the run can be read from the node.
+func logURL(node Node, guid string) string {
+	return fmt.Sprintf("%s/v2/runs/%s/logs", node.Address, guid)
+}
+
+// NodeStatus is the live state of a node in the inventory.
+type NodeStatus struct {
+	Node
+	Reachable     bool        `json:"reachable"`
+	Error         string      `json:"error,omitempty"`
+	Version       string      `json:"version,omitempty"`
+	ChefVersion   string      `json:"chef_version,omitempty"`
+	Locked        bool        `json:"locked"`
+	InMaintenance bool        `json:"in_maintenance"`
+	LastRun       *client.Job `json:"last_run,omitempty"`
+	LogURL        string      `json:"log_url,omitempty"`
+}
+
+// nodeStatusConcurrency limits how many nodes are checked at the same time.
+const nodeStatusConcurrency = 10
+
+// NodeStatuses checks every node in the inventory and returns their state sorted by name.
+func (m *Manager) NodeStatuses(ctx context.Context) ([]NodeStatus, error) {
+	nodes, err := m.inventory.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]NodeStatus, len(nodes))
+	slots := make(chan struct{}, nodeStatusConcurrency)
+	wg := &sync.WaitGroup{}
+	for i, node := range nodes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, node Node) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			statuses[i] = m.nodeStatus(ctx, node)
+		}(i, node)
+	}
+	wg.Wait()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
+
+func (m *Manager) nodeStatus(ctx context.Context, node Node) NodeStatus {
+	ns := NodeStatus{Node: node}
+	c, err := m.client(node)
+	if err != nil {
+		ns.Error = err.Error()
+		return ns
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		ns.Error = err.Error()
+		return ns
+	}
+	ns.Reachable = true
+	ns.Version = status.Version
+	ns.ChefVersion = status.ChefVersion
+	ns.Locked = status.Locked
+	ns.InMaintenance = status.InMaintenance
+	if status.LastRunGUID != "" {
+		job, err := c.Run(ctx, status.LastRunGUID)
+		if err != nil {
+			ns.Error = err.Error()
+			return ns
+		}
+		ns.LastRun = job
+		ns.LogURL = logURL(node, job.GUID)
+	}
+	return ns
+}
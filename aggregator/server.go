@@ -0,0 +1,118 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// maxJSONBodySize is the largest JSON request body that the API will read.
+const maxJSONBodySize = 16384
+
+// apiError matches the error envelope used by the chef waiter so that the same clients can read it.
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+// Server is the HTTP API of the aggregator.
+type Server struct {
+	manager *Manager
+	router  *mux.Router
+}
+
+// NewServer will return a Server for the manager.
+func NewServer(manager *Manager) *Server {
+	s := &Server{manager: manager, router: mux.NewRouter()}
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "No route matches the request", map[string]string{"path": r.URL.Path})
+	})
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method is not allowed on this route", map[string]string{"method": r.Method, "path": r.URL.Path})
+	})
+	s.router.HandleFunc("/nodes", s.getNodes).Methods(http.MethodGet)
+	s.router.HandleFunc("/rollouts", s.createRollout).Methods(http.MethodPost)
+	s.router.HandleFunc("/rollouts", s.getRollouts).Methods(http.MethodGet)
+	s.router.HandleFunc("/rollouts/{id}", s.getRollout).Methods(http.MethodGet)
+	s.router.HandleFunc("/healthcheck", s.healthCheck).Methods(http.MethodGet)
+	return s
+}
+
+// ServeHTTP allows the Server to be used as a http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) getNodes(w http.ResponseWriter, r *http.Request) {
+	statuses, err := s.manager.NodeStatuses(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to read the inventory", map[string]string{"reason": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) createRollout(w http.ResponseWriter, r *http.Request) {
+	opts := RolloutOptions{}
+	defer r.Body.Close()
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Invalid request body", map[string]string{"reason": err.Error()})
+		return
+	}
+	rollout, err := s.manager.Start(opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Failed to start the rollout", map[string]string{"reason": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, rollout)
+}
+
+func (s *Server) getRollouts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Rollouts())
+}
+
+func (s *Server) getRollout(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	rollout, ok := s.manager.Rollout(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Rollout not found", map[string]string{"id": id})
+		return
+	}
+	writeJSON(w, http.StatusOK, rollout)
+}
+
+func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.manager.inventory.Nodes(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Failed to read the inventory", map[string]string{"reason": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"state": "OK"})
+}
+
+// writeJSON will write the status code and then the JSON encoded value to the client.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to encode response", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBytes)
+}
+
+// writeError will write a JSON error envelope to the client with the supplied status code.
+func writeError(w http.ResponseWriter, status int, code, message string, details map[string]string) {
+	jsonBytes, _ := json.MarshalIndent(&errorEnvelope{Error: apiError{Code: code, Message: message, Details: details}}, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBytes)
+}
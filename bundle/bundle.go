@@ -0,0 +1,163 @@
+// Package bundle receives cookbook bundles that are uploaded to be run in local mode.
+// A bundle is a gzipped tar of a chef repo or a policy archive made by chef export --archive.
+// The upload is written to a temporary file while its checksum is worked out and is only
+// unpacked, in to the directory of the job that runs it, once it has been accepted.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxExpansion is how many times larger than the upload a bundle can be once unpacked.
+// It stops small archives from filling the disk.
+const maxExpansion = 20
+
+var (
+	// ErrTooLarge is returned when the upload is larger than the size limit.
+	ErrTooLarge = errors.New("bundle is larger than the size limit")
+	// ErrChecksum is returned when the upload does not match the checksum given for it.
+	ErrChecksum = errors.New("bundle does not match the checksum")
+	// ErrInvalid is returned when the upload can not be unpacked safely.
+	ErrInvalid = errors.New("bundle is not a valid gzipped tar archive")
+)
+
+// Upload is a bundle that has been received and checked. The caller must Remove it once
+// it has been unpacked or is no longer needed.
+type Upload struct {
+	Path   string
+	SHA256 string
+	Size   int64
+}
+
+// Receive writes the body to a temporary file and checks it against the hex encoded sha256
+// checksum. Bodies larger than maxSize bytes are rejected with ErrTooLarge.
+func Receive(body io.Reader, checksum string, maxSize int64) (*Upload, error) {
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != sha256.Size*2 {
+		return nil, fmt.Errorf("%w: %q is not a sha256 checksum", ErrChecksum, checksum)
+	}
+	f, err := ioutil.TempFile("", "chefwaiter-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	upload := &Upload{Path: f.Name()}
+
+	hash := sha256.New()
+	upload.Size, err = io.Copy(io.MultiWriter(f, hash), io.LimitReader(body, maxSize+1))
+	if err == nil && upload.Size > maxSize {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		upload.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(upload.SHA256, checksum) {
+			err = fmt.Errorf("%w: got %s", ErrChecksum, upload.SHA256)
+		}
+	}
+	if err != nil {
+		upload.Remove()
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Remove deletes the uploaded archive.
+func (u *Upload) Remove() error {
+	return os.Remove(u.Path)
+}
+
+// Extract unpacks the upload in to dir. Archives with links, entries that would be written
+// outside of dir or that unpack to much more than the upload are rejected with ErrInvalid.
+// Nothing is left in dir if the upload can not be unpacked.
+func (u *Upload) Extract(dir string) error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := extract(f, dir, u.Size*maxExpansion); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func extract(r io.Reader, dir string, limit int64) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+	var written int64
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalid, err)
+		}
+		target, err := entryPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			written += header.Size
+			if written > limit {
+				return fmt.Errorf("%w: unpacks to more than %d bytes", ErrInvalid, limit)
+			}
+			if err := writeFile(target, header.FileInfo().Mode().Perm(), archive, header.Size); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("%w: %s is a link", ErrInvalid, header.Name)
+		}
+	}
+}
+
+// entryPath returns where an entry in the archive is written. It must be inside dir.
+func entryPath(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %s is an absolute path", ErrInvalid, name)
+	}
+	target := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside of the bundle", ErrInvalid, name)
+	}
+	return target, nil
+}
+
+func writeFile(path string, mode os.FileMode, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return f.Close()
+}
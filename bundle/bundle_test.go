@@ -0,0 +1,133 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	body     string
+	typeflag byte
+}
+
+func archive(t *testing.T, entries ...entry) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: e.typeflag}
+		if e.typeflag == tar.TypeSymlink {
+			header.Linkname = "/etc/passwd"
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReceive(t *testing.T) {
+	body := archive(t, entry{name: "cookbooks/base/recipes/default.rb", body: "log 'hi'", typeflag: tar.TypeReg})
+
+	upload, err := Receive(bytes.NewReader(body), checksum(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("A matching upload should be accepted. Error: %s", err)
+	}
+	defer upload.Remove()
+	if upload.SHA256 != checksum(body) || upload.Size != int64(len(body)) {
+		t.Errorf("The upload details are wrong. Got: %+v", upload)
+	}
+
+	if _, err := Receive(bytes.NewReader(body), checksum([]byte("other")), int64(len(body))); !errors.Is(err, ErrChecksum) {
+		t.Errorf("A checksum mismatch should return ErrChecksum. Got: %v", err)
+	}
+	if _, err := Receive(bytes.NewReader(body), "abc", int64(len(body))); !errors.Is(err, ErrChecksum) {
+		t.Errorf("A malformed checksum should return ErrChecksum. Got: %v", err)
+	}
+	if _, err := Receive(bytes.NewReader(body), checksum(body), int64(len(body))-1); err != ErrTooLarge {
+		t.Errorf("An upload over the limit should return ErrTooLarge. Got: %v", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		entries []entry
+		valid   bool
+	}{
+		{name: "repo", valid: true, entries: []entry{
+			{name: "cookbooks/", typeflag: tar.TypeDir},
+			{name: "cookbooks/base/recipes/default.rb", body: "log 'hi'", typeflag: tar.TypeReg},
+			{name: ".chef/config.rb", body: "policy_document_native_api false", typeflag: tar.TypeReg},
+		}},
+		{name: "parent", entries: []entry{{name: "../escape.rb", body: "x", typeflag: tar.TypeReg}}},
+		{name: "absolute", entries: []entry{{name: "/tmp/escape.rb", body: "x", typeflag: tar.TypeReg}}},
+		{name: "symlink", entries: []entry{{name: "passwd", typeflag: tar.TypeSymlink}}},
+	}
+	for _, test := range tests {
+		body := archive(t, test.entries...)
+		upload, err := Receive(bytes.NewReader(body), checksum(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("%s: Failed to receive the bundle. Error: %s", test.name, err)
+		}
+		target := filepath.Join(dir, test.name)
+		err = upload.Extract(target)
+		upload.Remove()
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: The bundle should unpack. Error: %s", test.name, err)
+			}
+			if content, err := ioutil.ReadFile(filepath.Join(target, "cookbooks", "base", "recipes", "default.rb")); err != nil || string(content) != "log 'hi'" {
+				t.Errorf("%s: The recipe was not unpacked. Got: %q, %v", test.name, content, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: The bundle should be rejected with ErrInvalid. Got: %v", test.name, err)
+		}
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			t.Errorf("%s: Nothing should be left after a rejected bundle. Error: %v", test.name, err)
+		}
+	}
+
+	upload, err := Receive(bytes.NewReader([]byte("not a tarball")), checksum([]byte("not a tarball")), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upload.Remove()
+	if err := upload.Extract(filepath.Join(dir, "garbage")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("A body that is not a gzipped tar should be rejected with ErrInvalid. Got: %v", err)
+	}
+}
@@ -20,6 +20,9 @@ type WorkerReadWriter interface {
 type WorkerReader interface {
 	IsLogAvailable(string) error
 	GetLogPath(string) string
+	IsHookLogAvailable(string) error
+	GetHookLogPath(string) string
+	GetBundlePath(string) string
 }
 
 // WorkerWriter is used to describe the functuons that are used to write data to the Worker.
@@ -52,6 +55,12 @@ func (w *Worker) IsLogAvailable(guid string) error {
 	return nil
 }
 
+// IsHookLogAvailable will return an error if the hook log for the guid is not on the disk.
+func (w *Worker) IsHookLogAvailable(guid string) error {
+	_, err := os.Stat(w.GetHookLogPath(guid))
+	return err
+}
+
 // clearOldChefLogs will remove any logs that are deemed to be old
 func (w *Worker) clearOldChefLogs(guidsToKeep map[string]int64) {
 	allLogs, err := w.logsOnDisk()
@@ -69,6 +78,29 @@ func (w *Worker) clearOldChefLogs(guidsToKeep map[string]int64) {
 		}
 		w.logger.Infof("Deleted file: %s\n", oldFile)
 	}
+	w.clearOldBundles(guidsToKeep)
+}
+
+// clearOldBundles removes the unpacked cookbook bundles of jobs that are no longer kept.
+func (w *Worker) clearOldBundles(guidsToKeep map[string]int64) {
+	if w.config.BundleLocation() == "" {
+		return
+	}
+	bundles, err := filepath.Glob(filepath.Join(w.config.BundleLocation(), "*"))
+	if err != nil {
+		w.logger.Error(err)
+		return
+	}
+	for _, bundle := range bundles {
+		if _, keep := guidsToKeep[filepath.Base(bundle)]; keep {
+			continue
+		}
+		if err := os.RemoveAll(bundle); err != nil {
+			w.logger.Infof("Failed to delete bundle %s. Error: %s", bundle, err)
+			continue
+		}
+		w.logger.Infof("Deleted bundle: %s\n", bundle)
+	}
 }
 
 func (w *Worker) logsOnDisk() ([]string, error) {
@@ -82,7 +114,7 @@ func (w *Worker) filesToDelete(guidsToKeep map[string]int64, allLogs []string) [
 		del := true
 		// Get check if the log is in the list of files.
 		for guid := range guidsToKeep {
-			if w.GetLogPath(guid) == currentFile {
+			if w.GetLogPath(guid) == currentFile || w.GetHookLogPath(guid) == currentFile {
 				del = false
 				break
 			}
@@ -8,3 +8,13 @@ import (
 func (w *Worker) GetLogPath(guid string) (logPath string) {
 	return fmt.Sprintf("%s/%s.log", w.config.LogLocation(), guid)
 }
+
+// GetHookLogPath will return a string that points to the pre and post run hook output for a guid on the disk.
+func (w *Worker) GetHookLogPath(guid string) string {
+	return fmt.Sprintf("%s/%s.hooks.log", w.config.LogLocation(), guid)
+}
+
+// GetBundlePath will return the directory that an uploaded cookbook bundle for a guid is unpacked in.
+func (w *Worker) GetBundlePath(guid string) string {
+	return fmt.Sprintf("%s/%s", w.config.BundleLocation(), guid)
+}
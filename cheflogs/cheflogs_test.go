@@ -2,7 +2,9 @@ package cheflogs
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -87,3 +89,42 @@ func TestDeleteOldLogs(t *testing.T) {
 		}
 	}
 }
+
+func TestHookLogsAreKept(t *testing.T) {
+	configContainer := &config.ValuesContainer{InternalLogLocation: "/var/log/chefwaiter"}
+	chefLogger := New(configContainer, logs.NewFakeLogger(true))
+	keep := map[string]int64{"keep": 1}
+	onDisk := []string{
+		chefLogger.GetLogPath("keep"),
+		chefLogger.GetHookLogPath("keep"),
+		chefLogger.GetLogPath("old"),
+		chefLogger.GetHookLogPath("old"),
+	}
+	deleted := chefLogger.filesToDelete(keep, onDisk)
+	if len(deleted) != 2 || deleted[0] != onDisk[2] || deleted[1] != onDisk[3] {
+		t.Errorf("Only the old chef and hook logs should be deleted. Got: %v", deleted)
+	}
+}
+
+func TestOldBundlesAreDeleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configContainer := &config.ValuesContainer{InternalBundleLocation: dir}
+	chefLogger := New(configContainer, logs.NewFakeLogger(true))
+	for _, guid := range []string{"keep", "old"} {
+		if err := os.MkdirAll(filepath.Join(chefLogger.GetBundlePath(guid), "cookbooks"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chefLogger.clearOldBundles(map[string]int64{"keep": 1})
+	if _, err := os.Stat(chefLogger.GetBundlePath("keep")); err != nil {
+		t.Errorf("The bundle of a kept job should not be deleted. Error: %s", err)
+	}
+	if _, err := os.Stat(chefLogger.GetBundlePath("old")); !os.IsNotExist(err) {
+		t.Errorf("The bundle of an old job should be deleted. Error: %v", err)
+	}
+}
@@ -10,6 +10,16 @@ func (w *Worker) GetLogPath(guid string) (logPath string) {
 	return fmt.Sprintf("%s\\%s.log", w.cleanLogLocation(), guid)
 }
 
+// GetHookLogPath will return a string that points to the pre and post run hook output for a guid on the disk.
+func (w *Worker) GetHookLogPath(guid string) string {
+	return fmt.Sprintf("%s\\%s.hooks.log", w.cleanLogLocation(), guid)
+}
+
+// GetBundlePath will return the directory that an uploaded cookbook bundle for a guid is unpacked in.
+func (w *Worker) GetBundlePath(guid string) string {
+	return fmt.Sprintf("%s\\%s", strings.Replace(w.config.BundleLocation(), "/", `\`, -1), guid)
+}
+
 func (w *Worker) cleanLogLocation() string {
 	loglocation := w.config.LogLocation()
 	return strings.Replace(loglocation, "/", `\`, -1)
@@ -1,9 +1,14 @@
 package cheflogs
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+)
 
 type ChefLogsTest struct {
 	FakeLogPath string
+	// FakeBundleLocation is where bundles are unpacked.
+	FakeBundleLocation string
 }
 
 func (c *ChefLogsTest) IsLogAvailable(path string) error {
@@ -18,6 +23,18 @@ func (c *ChefLogsTest) GetLogPath(path string) string {
 	return c.FakeLogPath
 }
 
+func (c *ChefLogsTest) IsHookLogAvailable(path string) error {
+	return c.IsLogAvailable(path)
+}
+
+func (c *ChefLogsTest) GetHookLogPath(path string) string {
+	return c.FakeLogPath
+}
+
+func (c *ChefLogsTest) GetBundlePath(guid string) string {
+	return filepath.Join(c.FakeBundleLocation, guid)
+}
+
 func dummyChefLogContent() string {
 	return `
 This is a test chef waiter log.
@@ -0,0 +1,85 @@
+package chefrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/morfien101/chef-waiter/cmd"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+// ChefClient is the executor that runs chef-client. Args are added before the arguments
+// that the chef waiter adds for the log file and custom run lists. Env is added to the
+// environment of chef-client. When Sudo is true the variables are passed on the sudo
+// command line so sudo must be allowed to set them. An empty WorkingDir uses the working
+// directory of the chef waiter. Jobs that run an uploaded bundle are run in local mode
+// from the bundle directory.
+type ChefClient struct {
+	Binary     string
+	Sudo       bool
+	Args       []string
+	Env        map[string]string
+	WorkingDir string
+}
+
+// Execute runs chef-client for the job and returns its exit code.
+func (c ChefClient) Execute(e Execution) (int, error) {
+	if e.BundlePath != "" {
+		c.WorkingDir = e.BundlePath
+	}
+	return c.run(e, c.arguments(e)), nil
+}
+
+// arguments returns the arguments that send the logs to the log file, set the run list or
+// policy of custom runs and turn on local mode for bundles.
+func (c ChefClient) arguments(e Execution) []string {
+	arguments := []string{"-L", e.LogPath}
+	if e.BundlePath != "" {
+		arguments = append(arguments, "--local-mode")
+	}
+	if e.CustomRun != "" {
+		arguments = append(arguments, "-o", e.CustomRun)
+	}
+	if e.PolicyGroup != "" && e.PolicyName != "" {
+		arguments = append(arguments, "--policy-group", e.PolicyGroup, "--policy-name", e.PolicyName)
+	}
+	return arguments
+}
+
+// run starts the binary with the arguments and waits for it to finish. It is stopped if
+// the context of the job is done.
+func (c ChefClient) run(e Execution, arguments []string) int {
+	command, opts := c.command(arguments)
+	opts.Context = e.Context
+	logs.DebugMessage(fmt.Sprintf("runChef(%s): %s %s", e.GUID, command[0], strings.Join(command[1:], " ")))
+	stdout, stderr, exitCode := cmd.RunCommandWithOptions(opts, command[0], command[1:]...)
+	logs.DebugMessage(fmt.Sprintf("STDOUT %s: %s", e.GUID, stdout))
+	logs.DebugMessage(fmt.Sprintf("STDERR %s: %s", e.GUID, stderr))
+	return exitCode
+}
+
+// environment returns Env as KEY=VALUE pairs in a stable order.
+func (c ChefClient) environment() []string {
+	env := make([]string, 0, len(c.Env))
+	for key, value := range c.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// command returns the command to run the binary with the arguments and the options to run it with.
+func (c ChefClient) command(arguments []string) ([]string, cmd.Options) {
+	command := make([]string, 0, len(c.Args)+len(arguments)+len(c.Env)+2)
+	opts := cmd.Options{Dir: c.WorkingDir}
+	if c.Sudo && sudoCommand != "" {
+		command = append(command, sudoCommand)
+		command = append(command, c.environment()...)
+	} else {
+		opts.Env = c.environment()
+	}
+	command = append(command, c.Binary)
+	command = append(command, c.Args...)
+	return append(command, arguments...), opts
+}
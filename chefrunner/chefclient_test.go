@@ -0,0 +1,36 @@
+package chefrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChefClientCommand(t *testing.T) {
+	client := ChefClient{
+		Binary:     "/opt/cinc/bin/cinc-client",
+		Args:       []string{"--config", "/etc/chef/client-prod.rb"},
+		Env:        map[string]string{"https_proxy": "http://proxy:3128", "http_proxy": "http://proxy:3128"},
+		WorkingDir: "/opt/cinc",
+	}
+	command, opts := client.command([]string{"-L", "run.log"})
+	want := "/opt/cinc/bin/cinc-client --config /etc/chef/client-prod.rb -L run.log"
+	if got := strings.Join(command, " "); got != want {
+		t.Errorf("Wrong command. Got: %s, Want: %s", got, want)
+	}
+	if opts.Dir != "/opt/cinc" || strings.Join(opts.Env, " ") != "http_proxy=http://proxy:3128 https_proxy=http://proxy:3128" {
+		t.Errorf("Wrong options. Got: %+v", opts)
+	}
+
+	client.Sudo = true
+	command, opts = client.command(nil)
+	if sudoCommand == "" {
+		if command[0] != client.Binary {
+			t.Errorf("Sudo should be ignored without a sudo command. Got: %v", command)
+		}
+		return
+	}
+	want = sudoCommand + " http_proxy=http://proxy:3128 https_proxy=http://proxy:3128 /opt/cinc/bin/cinc-client --config /etc/chef/client-prod.rb"
+	if got := strings.Join(command, " "); got != want || len(opts.Env) != 0 {
+		t.Errorf("The environment should be passed to sudo. Got: %s, Env: %v", got, opts.Env)
+	}
+}
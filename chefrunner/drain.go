@@ -0,0 +1,118 @@
+package chefrunner
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// ErrDraining is returned when a job can't be queued because chef waiter is stopping.
+var ErrDraining = errors.New("chef waiter is stopping")
+
+// terminateWait is how long a terminated job has to finish before the drain gives up on it.
+const terminateWait = time.Minute
+
+// SetDrainTimeout sets how long the running job has to finish when chef waiter stops.
+// A timeout of 0 turns the graceful stop off.
+func (r *RunRequest) SetDrainTimeout(timeout time.Duration) {
+	r.drainLock.Lock()
+	defer r.drainLock.Unlock()
+	r.drainTimeout = timeout
+}
+
+// DrainStatus returns the progress of the graceful stop.
+func (r *RunRequest) DrainStatus() internalstate.Drain {
+	r.drainLock.RLock()
+	defer r.drainLock.RUnlock()
+	return r.drain
+}
+
+// Drain stops new jobs from being queued or started and waits for the running job to finish.
+// If it is still running after the drain timeout it is terminated. Jobs that are waiting in
+// the queue are left registered so that they can be resumed after a restart.
+// Drain does nothing if the drain timeout is 0.
+func (r *RunRequest) Drain() {
+	r.stopContext()
+	r.drainLock.Lock()
+	timeout := r.drainTimeout
+	if timeout <= 0 {
+		r.drainLock.Unlock()
+		return
+	}
+	start := time.Now()
+	r.drain = internalstate.Drain{
+		State:    internalstate.DrainDraining,
+		Started:  start.Unix(),
+		Deadline: start.Add(timeout).Unix(),
+	}
+	r.drainLock.Unlock()
+	r.logger.Infof("Draining. The running job has %s to finish", timeout)
+
+	// No more jobs can start now that the drain has started so the wait group only goes down.
+	idle := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(idle)
+	}()
+	terminated := false
+	select {
+	case <-idle:
+	case <-time.After(timeout):
+		terminated = true
+		r.logger.Warningf("The running job did not finish within %s. Terminating it", timeout)
+		r.setDrainState(internalstate.DrainTerminating)
+		r.terminate()
+		select {
+		case <-idle:
+		case <-time.After(terminateWait):
+			r.logger.Errorf("The terminated job is still running after %s. Stopping anyway", terminateWait)
+		}
+	}
+	r.setDrainState(internalstate.DrainDrained)
+	metrics.Incr("drained", 1, map[string]string{"terminated": strconv.FormatBool(terminated)})
+	r.logger.Infof("Drained after %s", time.Since(start).Round(time.Second))
+}
+
+// draining returns true once the graceful stop has started.
+func (r *RunRequest) draining() bool {
+	r.drainLock.RLock()
+	defer r.drainLock.RUnlock()
+	return r.drain.State != ""
+}
+
+// terminated returns true if the running job has been told to stop.
+func (r *RunRequest) terminated() bool {
+	return r.stopContext().Err() != nil
+}
+
+// startJob marks a job as running so that the drain waits for it. It returns false if the
+// drain has started and the job must not run.
+func (r *RunRequest) startJob() bool {
+	r.drainLock.Lock()
+	defer r.drainLock.Unlock()
+	if r.drain.State != "" {
+		return false
+	}
+	r.running.Add(1)
+	return true
+}
+
+func (r *RunRequest) setDrainState(state string) {
+	r.drainLock.Lock()
+	defer r.drainLock.Unlock()
+	r.drain.State = state
+}
+
+// stopContext is passed to the commands that run jobs. It is done when the running job is terminated.
+func (r *RunRequest) stopContext() context.Context {
+	r.drainLock.Lock()
+	defer r.drainLock.Unlock()
+	if r.stop == nil {
+		r.stop, r.terminate = context.WithCancel(context.Background())
+	}
+	return r.stop
+}
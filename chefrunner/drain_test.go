@@ -0,0 +1,94 @@
+package chefrunner
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+// blockingExecutor runs a job until it is released or the job is stopped.
+type blockingExecutor struct {
+	started chan string
+	release chan struct{}
+}
+
+func (b *blockingExecutor) Execute(e Execution) (int, error) {
+	b.started <- e.GUID
+	select {
+	case <-b.release:
+		return 0, nil
+	case <-e.Context.Done():
+		return 143, nil
+	}
+}
+
+func newDrainTest(t *testing.T) (*RunRequest, *internalstate.StateTable, *blockingExecutor, func()) {
+	testDir := filet.TmpDir(t, "")
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalLogLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	chefLogger := cheflogs.New(configContainer, fakelogger)
+	st := internalstate.New(configContainer, chefLogger, fakelogger)
+	executor := &blockingExecutor{started: make(chan string, 1), release: make(chan struct{})}
+	rr := New(st, chefLogger, fakelogger, 5, executor)
+	return rr, st, executor, func() { os.RemoveAll(testDir) }
+}
+
+func TestDrainTerminates(t *testing.T) {
+	rr, st, executor, cleanup := newDrainTest(t)
+	defer cleanup()
+	rr.SetDrainTimeout(100 * time.Millisecond)
+
+	running, _ := rr.OnDemandRun(RunOptions{})
+	<-executor.started
+	queued, _ := rr.OnDemandRun(RunOptions{Coalesce: internalstate.Coalesce{AlwaysNew: true}})
+
+	rr.Drain()
+	if job, _ := st.ReadJob(running.GUID); job.Status != "terminated" || job.ExitCode != 143 {
+		t.Errorf("The running job should be terminated. Got status: %s, exit code: %d", job.Status, job.ExitCode)
+	}
+	if job, _ := st.ReadJob(queued.GUID); job.Status != "registered" {
+		t.Errorf("The queued job should be left to resume. Got: %s", job.Status)
+	}
+	if drain := rr.DrainStatus(); drain.State != internalstate.DrainDrained || drain.Started == 0 || drain.Deadline < drain.Started {
+		t.Errorf("The drain should be finished. Got: %+v", drain)
+	}
+	if _, err := rr.OnDemandRun(RunOptions{}); err != ErrDraining {
+		t.Errorf("New runs should be refused. Got: %v", err)
+	}
+}
+
+func TestDrainWaits(t *testing.T) {
+	rr, st, executor, cleanup := newDrainTest(t)
+	defer cleanup()
+
+	// The graceful stop is off.
+	rr.Drain()
+	if rr.draining() {
+		t.Fatal("Drain should do nothing without a timeout")
+	}
+
+	rr.SetDrainTimeout(time.Minute)
+	running, _ := rr.OnDemandRun(RunOptions{})
+	<-executor.started
+	time.AfterFunc(50*time.Millisecond, func() {
+		if drain := rr.DrainStatus(); drain.State != internalstate.DrainDraining {
+			t.Errorf("The drain should be waiting. Got: %+v", drain)
+		}
+		close(executor.release)
+	})
+
+	rr.Drain()
+	if job, _ := st.ReadJob(running.GUID); job.Status != "complete" {
+		t.Errorf("The running job should finish. Got: %s", job.Status)
+	}
+	if rr.terminated() {
+		t.Error("The job should not be terminated when it finishes in time")
+	}
+}
@@ -0,0 +1,122 @@
+package chefrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/morfien101/chef-waiter/cmd"
+)
+
+// Names of the executors that can be chosen in the configuration file.
+const (
+	ExecutorChefClient = "chef-client"
+	ExecutorChefSolo   = "chef-solo"
+	ExecutorLocalMode  = "local-mode"
+	ExecutorCommand    = "command"
+)
+
+// Executor runs a job for the supervisor and returns the exit code. An error is returned
+// if the job could not be started. Executors must write the output of the job to the
+// log path so that it can be read from the API.
+type Executor interface {
+	Execute(Execution) (int, error)
+}
+
+// Execution describes a job to an executor. CustomRun is the run list of a custom run
+// and is empty for other jobs. PolicyGroup and PolicyName are set for custom runs that
+// use a policy. BundlePath is the directory that an uploaded cookbook bundle was unpacked
+// in and is empty for jobs that do not run a bundle. Executors must stop the job when
+// Context is done.
+type Execution struct {
+	GUID        string
+	LogPath     string
+	CustomRun   string
+	PolicyGroup string
+	PolicyName  string
+	BundlePath  string
+	Context     context.Context
+}
+
+// ChefSolo is the executor for chef-solo and for chef-client in local mode. Binary should
+// point at chef-solo, or at chef-client with LocalMode set. The cookbooks come from the
+// tarball at RecipeURL, which can be a URL or a local path, or from a cookbook path set in
+// the config passed with Args. JSONAttributes is a file with the attributes and run list.
+// Jobs that run an uploaded bundle use the bundle instead of RecipeURL.
+type ChefSolo struct {
+	ChefClient
+	LocalMode      bool
+	RecipeURL      string
+	JSONAttributes string
+}
+
+// Execute runs chef-solo for the job and returns its exit code.
+func (s ChefSolo) Execute(e Execution) (int, error) {
+	if e.BundlePath != "" {
+		s.WorkingDir = e.BundlePath
+	}
+	return s.run(e, s.arguments(e)), nil
+}
+
+// arguments adds the local mode, cookbook and attribute arguments to the chef-client ones.
+func (s ChefSolo) arguments(e Execution) []string {
+	arguments := make([]string, 0)
+	// Bundles are already run in local mode by the chef-client arguments.
+	if s.LocalMode && e.BundlePath == "" {
+		arguments = append(arguments, "--local-mode")
+	}
+	if s.RecipeURL != "" && e.BundlePath == "" {
+		arguments = append(arguments, "--recipe-url", s.RecipeURL)
+	}
+	if s.JSONAttributes != "" {
+		arguments = append(arguments, "-j", s.JSONAttributes)
+	}
+	return append(arguments, s.ChefClient.arguments(e)...)
+}
+
+// Command is the executor for any other tool. Its output is written to the log file of the
+// job. The job is described with the CHEFWAITER_GUID, CHEFWAITER_CUSTOM_RUN,
+// CHEFWAITER_POLICY_GROUP, CHEFWAITER_POLICY_NAME, CHEFWAITER_LOG_PATH and
+// CHEFWAITER_BUNDLE_PATH environment variables. Jobs that run an
+// uploaded bundle start in the bundle directory. A Timeout of 0 lets it run until it finishes.
+type Command struct {
+	Command    string
+	Args       []string
+	Env        map[string]string
+	WorkingDir string
+	Timeout    time.Duration
+}
+
+// Execute runs the command for the job and returns its exit code.
+func (c Command) Execute(e Execution) (int, error) {
+	output, err := os.OpenFile(e.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 1, fmt.Errorf("Failed to open the log file %s. Error: %s", e.LogPath, err)
+	}
+	defer output.Close()
+
+	env := ChefClient{Env: c.Env}.environment()
+	env = append(env,
+		"CHEFWAITER_GUID="+e.GUID,
+		"CHEFWAITER_CUSTOM_RUN="+e.CustomRun,
+		"CHEFWAITER_POLICY_GROUP="+e.PolicyGroup,
+		"CHEFWAITER_POLICY_NAME="+e.PolicyName,
+		"CHEFWAITER_LOG_PATH="+e.LogPath,
+		"CHEFWAITER_BUNDLE_PATH="+e.BundlePath,
+	)
+	dir := c.WorkingDir
+	if e.BundlePath != "" {
+		dir = e.BundlePath
+	}
+	exitCode, timedOut, err := cmd.RunCommandWithTimeout(c.Timeout, cmd.Options{Env: env, Dir: dir, Context: e.Context}, output, c.Command, c.Args...)
+	if timedOut {
+		fmt.Fprintf(output, "\n%s was stopped after %s\n", c.Command, c.Timeout)
+		return exitCode, nil
+	}
+	if err == context.Canceled {
+		fmt.Fprintf(output, "\n%s was stopped as chef waiter is stopping\n", c.Command)
+		return exitCode, nil
+	}
+	return exitCode, err
+}
@@ -0,0 +1,42 @@
+package chefrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+)
+
+func TestCommandExecutor(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	logPath := filepath.Join(testDir, "1234.log")
+	command := Command{
+		Command:    "/bin/sh",
+		Args:       []string{"-c", "echo $CHEFWAITER_GUID $CHEFWAITER_CUSTOM_RUN $CONVERGE_ENV $(pwd); exit 2"},
+		Env:        map[string]string{"CONVERGE_ENV": "prod"},
+		WorkingDir: testDir,
+		Timeout:    time.Second,
+	}
+
+	exitCode, err := command.Execute(Execution{GUID: "1234", LogPath: logPath, CustomRun: "recipe[test]"})
+	if exitCode != 2 || err != nil {
+		t.Errorf("The command should exit with 2. Got: %d, Error: %v", exitCode, err)
+	}
+	output, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "1234 recipe[test] prod "+testDir {
+		t.Errorf("The output should be in the log file. Got: %s", got)
+	}
+
+	command = Command{Command: "/bin/sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond}
+	if exitCode, _ := command.Execute(Execution{GUID: "1234", LogPath: logPath}); exitCode == 0 {
+		t.Error("A command that times out should fail")
+	}
+}
@@ -0,0 +1,91 @@
+package chefrunner
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+func TestChefSoloArguments(t *testing.T) {
+	solo := ChefSolo{LocalMode: true, RecipeURL: "https://artifacts.example.com/cookbooks.tgz", JSONAttributes: "/etc/chef/node.json"}
+	got := strings.Join(solo.arguments(Execution{LogPath: "run.log", CustomRun: "recipe[test]"}), " ")
+	want := "--local-mode --recipe-url https://artifacts.example.com/cookbooks.tgz -j /etc/chef/node.json -L run.log -o recipe[test]"
+	if got != want {
+		t.Errorf("Wrong arguments. Got: %s, Want: %s", got, want)
+	}
+	if got := strings.Join(ChefSolo{}.arguments(Execution{LogPath: "run.log"}), " "); got != "-L run.log" {
+		t.Errorf("Only the log file should be passed by default. Got: %s", got)
+	}
+}
+
+func TestRunWithExecutor(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalLogLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	chefLogger := cheflogs.New(configContainer, fakelogger)
+	st := internalstate.New(configContainer, chefLogger, fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"custom": &internalstate.JobDetails{Status: "registered", OnDemand: true, CustomRun: true, CustomRunString: "recipe[test]", Attempt: 1},
+	}
+	executor := &FakeExecutor{ExitCode: 3}
+	rr := &RunRequest{state: st, queue: newRunQueue(5), logger: fakelogger, chefLogWorker: chefLogger, executor: executor}
+
+	rr.startChefRunProcess("custom")
+
+	job, _ := st.ReadJob("custom")
+	if job.Status != "failed" || job.ExitCode != 3 {
+		t.Errorf("The job should have the exit code of the executor. Got status: %s, exit code: %d", job.Status, job.ExitCode)
+	}
+	want := Execution{GUID: "custom", LogPath: chefLogger.GetLogPath("custom"), CustomRun: "recipe[test]", Context: rr.stopContext()}
+	if len(executor.Executions) != 1 || executor.Executions[0] != want {
+		t.Errorf("The executor should get the job. Got: %+v, Want: %+v", executor.Executions, want)
+	}
+}
+
+func TestBundleArguments(t *testing.T) {
+	e := Execution{LogPath: "run.log", CustomRun: "recipe[test]", BundlePath: "/var/lib/chefwaiter/bundles/1234"}
+	if got := strings.Join(ChefClient{}.arguments(e), " "); got != "-L run.log --local-mode -o recipe[test]" {
+		t.Errorf("Bundles should run in local mode. Got: %s", got)
+	}
+	solo := ChefSolo{LocalMode: true, RecipeURL: "https://artifacts.example.com/cookbooks.tgz", JSONAttributes: "/etc/chef/node.json"}
+	if got := strings.Join(solo.arguments(e), " "); got != "-j /etc/chef/node.json -L run.log --local-mode -o recipe[test]" {
+		t.Errorf("Bundles should be used instead of the recipe url. Got: %s", got)
+	}
+}
+
+func TestPolicyArguments(t *testing.T) {
+	e := Execution{LogPath: "run.log", PolicyGroup: "canary", PolicyName: "webserver"}
+	if got := strings.Join(ChefClient{}.arguments(e), " "); got != "-L run.log --policy-group canary --policy-name webserver" {
+		t.Errorf("Policy runs should pass the policy group and name. Got: %s", got)
+	}
+}
+
+func TestRetriesRunTheFirstBundle(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalLogLocation: testDir, InternalBundleLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	chefLogger := cheflogs.New(configContainer, fakelogger)
+	st := internalstate.New(configContainer, chefLogger, fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"first": &internalstate.JobDetails{Status: "failed", OnDemand: true, BundleSHA256: "abc", Attempt: 1},
+		"plain": &internalstate.JobDetails{Status: "registered", OnDemand: true, Attempt: 1},
+	}
+	rr := &RunRequest{state: st, chefLogWorker: chefLogger}
+
+	retry, _ := st.RegisterRetry("first")
+	if got := rr.execution(retry).BundlePath; got != chefLogger.GetBundlePath("first") {
+		t.Errorf("A retry should run the bundle of the first attempt. Got: %s", got)
+	}
+	if got := rr.execution("plain").BundlePath; got != "" {
+		t.Errorf("Jobs without a bundle should not get a bundle path. Got: %s", got)
+	}
+}
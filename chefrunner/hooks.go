@@ -0,0 +1,121 @@
+package chefrunner
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morfien101/chef-waiter/cmd"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// Hook is a script that runs before or after chef. An empty Command turns the hook off.
+// A Timeout of 0 lets the hook run until it finishes. AbortOnFailure is only used for
+// the pre run hook and stops chef from running if the hook fails.
+type Hook struct {
+	Command        string
+	Args           []string
+	Timeout        time.Duration
+	AbortOnFailure bool
+}
+
+// Hooks are the scripts that run around each chef run.
+type Hooks struct {
+	PreRun  Hook
+	PostRun Hook
+}
+
+// SetHooks sets the hooks used for runs that start from now on.
+func (r *RunRequest) SetHooks(hooks Hooks) {
+	r.hookLock.Lock()
+	defer r.hookLock.Unlock()
+	r.hooks = hooks
+}
+
+func (r *RunRequest) runHooks() Hooks {
+	r.hookLock.RLock()
+	defer r.hookLock.RUnlock()
+	return r.hooks
+}
+
+// hookJobType returns periodic, ondemand or custom.
+func hookJobType(job internalstate.JobDetails) string {
+	switch {
+	case job.CustomRun:
+		return "custom"
+	case job.OnDemand:
+		return "ondemand"
+	}
+	return "periodic"
+}
+
+// hookEnvironment is the environment that describes the job to a hook.
+func hookEnvironment(guid, stage string, job internalstate.JobDetails) []string {
+	env := []string{
+		"CHEFWAITER_GUID=" + guid,
+		"CHEFWAITER_HOOK=" + stage,
+		"CHEFWAITER_JOB_TYPE=" + hookJobType(job),
+		"CHEFWAITER_CUSTOM_RUN=" + job.CustomRunString,
+		"CHEFWAITER_POLICY_GROUP=" + job.PolicyGroup,
+		"CHEFWAITER_POLICY_NAME=" + job.PolicyName,
+		"CHEFWAITER_ATTEMPT=" + strconv.Itoa(job.Attempt),
+	}
+	if stage == internalstate.PostRunHook {
+		env = append(env,
+			"CHEFWAITER_EXIT_CODE="+strconv.Itoa(job.ExitCode),
+			"CHEFWAITER_STATUS="+job.Status,
+		)
+	}
+	return env
+}
+
+// runHook runs the hook for the job and records the result against it. The output of the hook
+// is appended to the hook log of the job. It returns the result and false if the hook is off.
+func (r *RunRequest) runHook(guid, stage string, hook Hook, job internalstate.JobDetails) (internalstate.HookResult, bool) {
+	if hook.Command == "" {
+		return internalstate.HookResult{}, false
+	}
+	result := internalstate.HookResult{Name: stage}
+	logPath := r.chefLogWorker.GetHookLogPath(guid)
+	output, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		r.logger.Errorf("Failed to open the hook log %s. Error: %s", logPath, err)
+		result.ExitCode = 1
+		result.Error = err.Error()
+		r.recordHook(guid, result)
+		return result, true
+	}
+	defer output.Close()
+
+	start := time.Now()
+	fmt.Fprintf(output, "==> %s hook started at %s: %s %s\n", stage, start.Format(time.RFC3339), hook.Command, strings.Join(hook.Args, " "))
+	exitCode, timedOut, err := cmd.RunCommandWithTimeout(hook.Timeout, cmd.Options{Env: hookEnvironment(guid, stage, job), Context: r.stopContext()}, output, hook.Command, hook.Args...)
+	result.ExitCode = exitCode
+	result.TimedOut = timedOut
+	result.Duration = int64(time.Since(start) / time.Millisecond)
+	if timedOut {
+		result.Error = fmt.Sprintf("timed out after %s", hook.Timeout)
+	} else if err != nil {
+		result.Error = err.Error()
+	}
+	fmt.Fprintf(output, "<== %s hook finished with exit code %d after %dms\n", stage, exitCode, result.Duration)
+	if result.Error != "" {
+		fmt.Fprintf(output, "<== %s hook error: %s\n", stage, result.Error)
+	}
+	r.recordHook(guid, result)
+	return result, true
+}
+
+// recordHook stores the hook result on the job and ships the metrics for it.
+func (r *RunRequest) recordHook(guid string, result internalstate.HookResult) {
+	r.state.UpdateHookResult(guid, result)
+	tags := map[string]string{"hook": result.Name, "failed": strconv.FormatBool(result.Failed())}
+	metrics.Timing("hook_run_time", result.Duration, tags)
+	if result.Failed() {
+		r.logger.Warningf("The %s hook for %s failed with exit code %d. %s", result.Name, guid, result.ExitCode, result.Error)
+		metrics.Incr("hook_failed", 1, map[string]string{"hook": result.Name, "timed_out": strconv.FormatBool(result.TimedOut)})
+	}
+}
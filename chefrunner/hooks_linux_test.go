@@ -0,0 +1,75 @@
+package chefrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+func TestPreRunHookAborts(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalLogLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	chefLogger := cheflogs.New(configContainer, fakelogger)
+	st := internalstate.New(configContainer, chefLogger, fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"1234": &internalstate.JobDetails{Status: "registered", OnDemand: true, CustomRun: true, CustomRunString: "recipe[test]", Attempt: 1},
+	}
+	rr := &RunRequest{state: st, queue: newRunQueue(5), logger: fakelogger, chefLogWorker: chefLogger}
+	rr.SetHooks(Hooks{
+		PreRun:  Hook{Command: "/bin/sh", Args: []string{"-c", "echo pre $CHEFWAITER_GUID $CHEFWAITER_JOB_TYPE $CHEFWAITER_CUSTOM_RUN; exit 4"}, AbortOnFailure: true},
+		PostRun: Hook{Command: "/bin/sh", Args: []string{"-c", "echo post $CHEFWAITER_STATUS $CHEFWAITER_EXIT_CODE"}},
+	})
+
+	rr.startChefRunProcess("1234")
+
+	job, _ := st.ReadJob("1234")
+	if job.Status != "aborted" || job.ExitCode != 4 {
+		t.Errorf("The run should be aborted with the exit code of the hook. Got status: %s, exit code: %d", job.Status, job.ExitCode)
+	}
+	if len(job.Hooks) != 2 || job.Hooks[0].Name != internalstate.PreRunHook || !job.Hooks[0].Failed() || job.Hooks[1].Failed() {
+		t.Errorf("Both hooks should be recorded and only the pre run hook failed. Got: %+v", job.Hooks)
+	}
+	if rr.queue.len() != 0 {
+		t.Error("Aborted runs should not be retried")
+	}
+
+	output, err := ioutil.ReadFile(chefLogger.GetHookLogPath("1234"))
+	if err != nil {
+		t.Fatalf("Failed to read the hook log. Error: %s", err)
+	}
+	for _, want := range []string{"pre 1234 custom recipe[test]", "post aborted 4"} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("Hook log is missing %q. Got: %s", want, output)
+		}
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalLogLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	chefLogger := cheflogs.New(configContainer, fakelogger)
+	st := internalstate.New(configContainer, chefLogger, fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{"1234": &internalstate.JobDetails{Status: "running"}}
+	rr := &RunRequest{state: st, logger: fakelogger, chefLogWorker: chefLogger}
+
+	if _, ran := rr.runHook("1234", internalstate.PreRunHook, Hook{}, internalstate.JobDetails{}); ran {
+		t.Error("A hook without a command should not run")
+	}
+	result, _ := rr.runHook("1234", internalstate.PostRunHook, Hook{Command: "/bin/sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond}, internalstate.JobDetails{})
+	if !result.TimedOut || !result.Failed() || result.Error == "" {
+		t.Errorf("The hook should have timed out. Got: %+v", result)
+	}
+}
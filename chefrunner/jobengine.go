@@ -1,113 +1,314 @@
 package chefrunner
 
 import (
+	"context"
 	"fmt"
-	"strings"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	uuid "github.com/satori/go.uuid"
+
 	"github.com/morfien101/chef-waiter/cheflogs"
-	"github.com/morfien101/chef-waiter/cmd"
 	"github.com/morfien101/chef-waiter/internalstate"
 	"github.com/morfien101/chef-waiter/logs"
 	"github.com/morfien101/chef-waiter/metrics"
+	"github.com/morfien101/chef-waiter/precondition"
 )
 
 // Request is a RunRequest that is used to push messaged to a queue which will trigger runs.
 var Request RunRequest
 
-// Worker is what is needed to register runs of 2 types.
+// Worker is what is needed to register runs. The runs return ErrQueueFull if they
+// can't be queued and ErrDraining once chef waiter is stopping.
 type Worker interface {
-	OnDemandRun() string
-	PeriodicRun() string
-	CustomRun(string) string
+	OnDemandRun(RunOptions) (Submission, error)
+	PeriodicRun() (string, error)
+	CustomRun(string, RunOptions) (Submission, error)
+	EmergencyRun(string, RunOptions) (Submission, error)
+	Queue() []QueuedJob
+}
+
+// RunOptions change how a run request is registered.
+type RunOptions struct {
+	// Coalesce decides if the request can join a matching job that is already queued.
+	Coalesce internalstate.Coalesce
+	// Metadata is who requested the run and why. It is only recorded when a new job is created.
+	Metadata internalstate.RunMetadata
+	// Policy is the policy group and name for a custom run. It is not checked against the allowed policies here.
+	Policy internalstate.Policy
+	// Bundle is the sha256 checksum of an uploaded cookbook bundle for the job to run. Jobs
+	// with a bundle are always new jobs.
+	Bundle string
+	// Prepare is called with the guid of a new job before it is registered. Jobs with Prepare
+	// are always new jobs. Nothing is registered and the error is returned if it fails.
+	// Bundle runs use it to unpack the bundle.
+	Prepare func(guid string) error
+}
+
+// Submission is the outcome of a run request.
+type Submission struct {
+	GUID string
+	// Created is false when the request joined a job that was already queued.
+	Created bool
 }
 
-// RunRequest holds 2 channels for on demand runs and periodic runs. It also has the functions to add jobs to the queues.
+// defaultRunDuration is used to estimate start times before we have seen a run finish.
+const defaultRunDuration = int64(5 * 60)
+
+// RunRequest holds the run queue. It also has the functions to add jobs to the queue.
 type RunRequest struct {
-	onDemandWorkQ chan string
-	periodicWorkQ chan string
+	queue         *runQueue
 	logger        logs.SysLogger
 	state         internalstate.StateTableReadWriter
 	chefLogWorker cheflogs.WorkerReader
+	executor      Executor
+	retryLock     sync.RWMutex
+	retry         RetryPolicy
+	hookLock      sync.RWMutex
+	hooks         Hooks
+	preconditions precondition.Gate
+	// submitLock makes registering a job and queueing it one step so that a request can't
+	// join a job that is then removed because the queue is full.
+	submitLock sync.Mutex
+	// drainLock guards the drain and the stop context. running counts the jobs that the
+	// supervisor has started so that the drain can wait for them.
+	drainLock    sync.RWMutex
+	drainTimeout time.Duration
+	drain        internalstate.Drain
+	running      sync.WaitGroup
+	stop         context.Context
+	terminate    context.CancelFunc
 }
 
-// OnDemandRun will return a string guid for a on demand scheduled run.
-func (r *RunRequest) OnDemandRun() string {
-	ok, guid := r.state.RegisterRun(true, false, "")
-	if ok {
-		logs.DebugMessage(fmt.Sprintf("New GUID Generated: %s, submitting a new job for onDemand", guid))
-		r.onDemandWorkQ <- guid
-	}
-	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from OnDemandRun()", guid))
-	return guid
+// OnDemandRun will register an on demand run.
+func (r *RunRequest) OnDemandRun(opts RunOptions) (Submission, error) {
+	sub, err := r.submit(true, false, "", PriorityOnDemand, opts)
+	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from OnDemandRun()", sub.GUID))
+	return sub, err
 }
 
-// CustomRun will return a guid of a custom run that has been scheduled.
-func (r *RunRequest) CustomRun(runDetails string) string {
-	ok, guid := r.state.RegisterRun(true, true, runDetails)
-	if ok {
-		logs.DebugMessage(fmt.Sprintf("New GUID Generated: %s, submitting a new job for CustomRun with text: %s", guid, runDetails))
-		r.onDemandWorkQ <- guid
-	}
-	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from CustomRun()", guid))
-	return guid
+// CustomRun will register a custom run.
+func (r *RunRequest) CustomRun(runDetails string, opts RunOptions) (Submission, error) {
+	sub, err := r.submit(true, true, runDetails, PriorityCustom, opts)
+	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from CustomRun() with text: %s", sub.GUID, runDetails))
+	return sub, err
+}
+
+// EmergencyRun will queue a run ahead of everything else. If runDetails is empty it is an
+// on demand run, otherwise it is a custom run.
+func (r *RunRequest) EmergencyRun(runDetails string, opts RunOptions) (Submission, error) {
+	sub, err := r.submit(true, runDetails != "", runDetails, PriorityEmergency, opts)
+	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from EmergencyRun()", sub.GUID))
+	return sub, err
 }
 
 // PeriodicRun will return a string guid for a scheduled run.
-func (r *RunRequest) PeriodicRun() string {
-	ok, guid := r.state.RegisterRun(false, false, "")
-	if ok {
-		logs.DebugMessage(fmt.Sprintf("New GUID Generated: %s, submitting a new job for periodic", guid))
-		r.periodicWorkQ <- guid
+func (r *RunRequest) PeriodicRun() (string, error) {
+	sub, err := r.submit(false, false, "", PriorityPeriodic, RunOptions{})
+	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from PeriodicRun()", sub.GUID))
+	return sub.GUID, err
+}
+
+// submit registers the run and queues it. If the run joined a job that is already queued
+// the job is moved up to the requested priority.
+func (r *RunRequest) submit(onDemand, custom bool, customString string, priority Priority, opts RunOptions) (Submission, error) {
+	if r.draining() {
+		r.logger.Warningf("Rejected %s run as chef waiter is stopping", priority)
+		return Submission{}, ErrDraining
+	}
+	if opts.Bundle != "" || opts.Prepare != nil {
+		opts.Coalesce = internalstate.Coalesce{AlwaysNew: true}
+	}
+	// The job can't be joined so it is prepared before it is registered. Other requests
+	// don't wait for it and nothing needs to be removed if it fails.
+	var guid string
+	if opts.Prepare != nil {
+		guid = uuid.Must(uuid.NewV4()).String()
+		if err := opts.Prepare(guid); err != nil {
+			r.logger.Warningf("Failed to prepare job %s. Error: %s", guid, err)
+			return Submission{}, err
+		}
+	}
+
+	r.submitLock.Lock()
+	defer r.submitLock.Unlock()
+	ok := true
+	if guid != "" {
+		r.state.RegisterNewRun(guid, onDemand, custom, customString, opts.Policy)
+	} else {
+		ok, guid = r.state.RegisterRun(onDemand, custom, customString, opts.Policy, opts.Coalesce)
+	}
+	if !ok {
+		if r.queue.promote(guid, priority) {
+			r.state.UpdatePriority(guid, priority.String())
+		}
+		metrics.Incr("run_joined", 1, metadataTags(opts.Metadata, map[string]string{"priority": priority.String()}))
+		if opts.Metadata.Requester != "" {
+			r.logger.Infof("Run request from %s joined queued job %s", opts.Metadata.Requester, guid)
+		}
+		return Submission{GUID: guid}, nil
+	}
+	r.state.UpdatePriority(guid, priority.String())
+	r.state.UpdateMetadata(guid, opts.Metadata)
+	if opts.Bundle != "" {
+		r.state.UpdateBundle(guid, opts.Bundle)
 	}
-	logs.DebugMessage(fmt.Sprintf("Returning GUID:%s from PeriodicRun()", guid))
-	return guid
+	if err := r.queue.push(guid, priority); err != nil {
+		// The job never made it in to the queue so it must not be joined by later requests.
+		r.state.Delete(guid)
+		metrics.Incr("run_queue_full", 1, metadataTags(opts.Metadata, map[string]string{"priority": priority.String()}))
+		r.logger.Warningf("Rejected %s run as the run queue is full", priority)
+		return Submission{}, err
+	}
+	logs.DebugMessage(fmt.Sprintf("New GUID Generated: %s, submitting a new %s job with coalesce policy %s", guid, priority, opts.Coalesce))
+	metrics.Gauge("run_queue_depth", int64(r.queue.len()), nil)
+	return Submission{GUID: guid, Created: true}, nil
+}
+
+// Queue will return the jobs waiting to run in the order that they will run.
+// The estimated start times are based on the average length of the runs that we know about.
+func (r *RunRequest) Queue() []QueuedJob {
+	items := r.queue.list()
+	jobs := r.state.ReadAllJobs()
+
+	var total, count int64
+	for _, job := range jobs {
+		if job.RunStartTime > 0 && job.RunEndTime >= job.RunStartTime {
+			total += job.RunEndTime - job.RunStartTime
+			count++
+		}
+	}
+	average := defaultRunDuration
+	if count > 0 {
+		average = total / count
+	}
+
+	// The next job starts when the running job is expected to finish.
+	now := time.Now().Unix()
+	next := now
+	for _, job := range jobs {
+		if job.Status == "running" && job.RunStartTime+average > next {
+			next = job.RunStartTime + average
+		}
+	}
+
+	queued := make([]QueuedJob, len(items))
+	for i, item := range items {
+		queued[i] = QueuedJob{
+			GUID:           item.guid,
+			Position:       i + 1,
+			Priority:       item.priority.String(),
+			QueuedTime:     item.queuedTime,
+			EstimatedStart: next + int64(i)*average,
+		}
+	}
+	return queued
+}
+
+// QueueDepth returns the number of jobs waiting to run.
+func (r *RunRequest) QueueDepth() int {
+	return r.queue.len()
 }
 
 // New - Runs the worker process that will run the commands one at a time.
-func New(state *internalstate.StateTable, chefLogWorker cheflogs.WorkerReader, logger logs.SysLogger) *RunRequest {
+// queueSize is the most jobs that can wait to run and executor runs them.
+func New(state *internalstate.StateTable, chefLogWorker cheflogs.WorkerReader, logger logs.SysLogger, queueSize int, executor Executor) *RunRequest {
 	logs.DebugMessage("StartWorker()")
 	worker := &RunRequest{
-		onDemandWorkQ: make(chan string, 10),
-		periodicWorkQ: make(chan string, 10),
+		queue:         newRunQueue(queueSize),
 		state:         state,
 		logger:        logger,
 		chefLogWorker: chefLogWorker,
+		executor:      executor,
 	}
 
+	worker.resumeQueuedRuns()
 	go worker.supervisor()
 	go worker.periodicRunEngine()
 	return worker
 }
 
-func (r *RunRequest) supervisor() {
-	// Preamble for metrics shipping
-	start := func(jobType string) {
-		metrics.Incr("run_starting", 1, map[string]string{"type": jobType})
+// resumeQueuedRuns puts the jobs that were resumed from the state file back in the queue
+// in the order that they were first registered. Jobs that don't fit are abandoned.
+func (r *RunRequest) resumeQueuedRuns() {
+	type resumed struct {
+		guid string
+		job  internalstate.JobDetails
+	}
+	jobs := []resumed{}
+	for guid, job := range r.state.ReadAllJobs() {
+		if job.Status == "registered" && job.Resumed {
+			jobs = append(jobs, resumed{guid: guid, job: job})
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].job.QueuedTime < jobs[j].job.QueuedTime
+	})
+	for _, resumed := range jobs {
+		priority := jobPriority(resumed.job)
+		if err := r.queue.push(resumed.guid, priority); err != nil {
+			r.logger.Warningf("Could not resume %s run %s. Error: %s", priority, resumed.guid, err)
+			r.state.UpdateStatus(resumed.guid, "abandoned")
+			continue
+		}
+		r.logger.Infof("Resumed %s run %s from before the restart", priority, resumed.guid)
+		metrics.Incr("run_resumed", 1, map[string]string{"priority": priority.String()})
 	}
+}
 
-	finished := func(jobType string) {
-		metrics.Incr("run_finished", 1, map[string]string{"type": jobType})
+// metadataTags adds the requester and labels of a run to the metric tags.
+// The tags that are passed in are never replaced by a label.
+func metadataTags(metadata internalstate.RunMetadata, tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(tags)+len(metadata.Labels)+1)
+	for key, value := range metadata.Labels {
+		merged[key] = value
 	}
+	if metadata.Requester != "" {
+		merged["requester"] = metadata.Requester
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return merged
+}
 
+func (r *RunRequest) supervisor() {
+	// Preamble for metrics shipping
 	timer := func(f func(string), guid, jobType string) {
-		start(jobType)
+		job, _ := r.state.ReadJob(guid)
+		tags := metadataTags(job.RunMetadata, map[string]string{"type": jobType, "attempt": strconv.Itoa(job.Attempt)})
+		metrics.Incr("run_starting", 1, tags)
 		start := time.Now()
 		f(guid)
-		metrics.Timing("chef_run_time", int64(time.Since(start)/time.Millisecond), map[string]string{"type": jobType})
-		finished(jobType)
+		metrics.Timing("chef_run_time", int64(time.Since(start)/time.Millisecond), tags)
+		metrics.Incr("run_finished", 1, tags)
 	}
 
 	for {
-		select {
-		case guid := <-r.periodicWorkQ:
-			//run chef as periodic job
-			if r.state.ReadPeriodicRuns() {
-				timer(r.startChefRunProcess, guid, "periodic")
-			}
-		case guid := <-r.onDemandWorkQ:
-			timer(r.startChefRunProcess, guid, "demand")
+		guid, priority := r.queue.pop()
+		metrics.Gauge("run_queue_depth", int64(r.queue.len()), nil)
+		if !r.startJob() {
+			// The job stays registered so that it can be resumed after the restart.
+			r.logger.Infof("Not starting %s as chef waiter is stopping", guid)
+			continue
 		}
+		func() {
+			defer r.running.Done()
+			jobType := "demand"
+			if priority == PriorityPeriodic {
+				//run chef as periodic job
+				if r.periodicOff(guid) {
+					return
+				}
+				jobType = "periodic"
+			}
+			if r.skipped(guid) {
+				return
+			}
+			timer(r.startChefRunProcess, guid, jobType)
+		}()
 	}
 }
 
@@ -130,20 +331,46 @@ func (r *RunRequest) startChefRunProcess(guid string) {
 		r.state.UpdatelastRunStartTime(time.Now().Unix())
 	}
 
+	r.state.UpdateRunStartTime(guid, time.Now().Unix())
 	r.state.UpdateStatus(guid, "running")
 
-	exitCode := r.runChef(guid)
+	hooks := r.runHooks()
+	job, _ := r.state.ReadJob(guid)
+	status := "complete"
+	var exitCode int
+	if pre, ran := r.runHook(guid, internalstate.PreRunHook, hooks.PreRun, job); ran && pre.Failed() && hooks.PreRun.AbortOnFailure {
+		// Chef never ran so the exit code of the hook is recorded instead.
+		r.logger.Warningf("Not running chef for %s as the pre run hook failed", guid)
+		status = "aborted"
+		exitCode = pre.ExitCode
+	} else {
+		exitCode = r.runChef(guid)
+		if exitCode != 0 {
+			status = "failed"
+		}
+	}
+	// The job was cut short by the drain so the result does not say how chef did.
+	if r.terminated() {
+		status = "terminated"
+	}
+	r.state.UpdateRunEndTime(guid, time.Now().Unix())
 	r.state.UpdateExitCode(guid, exitCode)
 
-	if exitCode != 0 {
-		r.state.UpdateStatus(guid, "failed")
-	} else {
-		r.state.UpdateStatus(guid, "complete")
+	// The post run hook runs before the final status is set so that its result is part of the run.
+	// It does not run for terminated jobs as chef waiter is stopping.
+	if status != "terminated" {
+		job, _ = r.state.ReadJob(guid)
+		job.Status = status
+		r.runHook(guid, internalstate.PostRunHook, hooks.PostRun, job)
 	}
+	r.state.UpdateStatus(guid, status)
 
 	r.state.WriteLastRunGUID(guid)
 
 	r.logger.Infof("Finished %s run with guid: %s, exit code was: %d", lmsg, guid, exitCode)
+	if status != "aborted" && status != "terminated" {
+		r.scheduleRetry(guid, exitCode)
+	}
 }
 
 // PeriodicRunEngine - checks if we need to run chef and sends a request to run chef on a interval of 1 minute.
@@ -152,7 +379,9 @@ func (r *RunRequest) periodicRunEngine() {
 	trigger := time.NewTicker(time.Minute * 1)
 	for _ = range trigger.C {
 		if r.timeToRunChef() && r.state.ReadPeriodicRuns() {
-			r.PeriodicRun()
+			if _, err := r.PeriodicRun(); err != nil {
+				r.logger.Warningf("Failed to queue the periodic run. Error: %s", err)
+			}
 		}
 	}
 }
@@ -169,24 +398,34 @@ func (r *RunRequest) timeToRunChef() bool {
 	return (time.Now().Unix() > r.state.GetlastRunStartTime()+r.state.ReadChefRunTimer()) && !r.state.InMaintenceMode()
 }
 
-// runChef will run the command based on the OS
-func (r *RunRequest) runChef(guid string) (exitCode int) {
-	command := chefClientCommand
-	command = append(command, r.chefClientArguments(guid)...)
-	logs.DebugMessage(fmt.Sprintf("runChef(%s): %s %s", guid, command[0], strings.Join(command[1:], " ")))
-	stdout, stderr, exitCode := cmd.RunCommand(command[0], command[1:]...)
-	logs.DebugMessage(fmt.Sprintf("STDOUT %s: %s", guid, stdout))
-	logs.DebugMessage(fmt.Sprintf("STDERR %s: %s", guid, stderr))
-	return
+// runChef will run the job with the executor
+func (r *RunRequest) runChef(guid string) int {
+	exitCode, err := r.executor.Execute(r.execution(guid))
+	if err != nil {
+		r.logger.Errorf("Failed to run %s. Error: %s", guid, err)
+	}
+	return exitCode
 }
 
-// chefClientArguments will compile the arguments and return them as a []string
-func (r *RunRequest) chefClientArguments(guid string) []string {
-	arguments := make([]string, 0)
-	arguments = append(arguments, "-L", r.chefLogWorker.GetLogPath(guid))
-	customJob, strValue := r.state.IsCustomJob(guid)
-	if customJob {
-		arguments = append(arguments, "-o", fmt.Sprintf(`%s`, strValue))
+// execution describes the job to the executor.
+func (r *RunRequest) execution(guid string) Execution {
+	execution := Execution{GUID: guid, LogPath: r.chefLogWorker.GetLogPath(guid), Context: r.stopContext()}
+	if customJob, strValue := r.state.IsCustomJob(guid); customJob {
+		execution.CustomRun = strValue
+	}
+	job, ok := r.state.ReadJob(guid)
+	if !ok {
+		return execution
+	}
+	execution.PolicyGroup = job.PolicyGroup
+	execution.PolicyName = job.PolicyName
+	// Retries run the bundle that was unpacked for the first attempt.
+	if job.BundleSHA256 != "" {
+		root := guid
+		if job.ParentGUID != "" {
+			root = job.ParentGUID
+		}
+		execution.BundlePath = r.chefLogWorker.GetBundlePath(root)
 	}
-	return arguments
+	return execution
 }
@@ -1,5 +1,4 @@
 package chefrunner
 
-var (
-	chefClientCommand = []string{"/usr/bin/sudo", "/usr/bin/chef-client"}
-)
+// sudoCommand is put in front of chef-client when ChefClient.Sudo is true.
+const sudoCommand = "/usr/bin/sudo"
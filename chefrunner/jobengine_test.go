@@ -46,7 +46,7 @@ func TestCustomJob(t *testing.T) {
 		chefLogWorker: chefLogger,
 	}
 
-	args := rr.chefClientArguments(testGUID)
+	args := ChefClient{}.arguments(rr.execution(testGUID))
 	expectedLogPath := fmt.Sprintf("%s/%s.log", testLogLocation, testGUID)
 	collectedRecipe := args[len(args)-1]
 	// Test the log location
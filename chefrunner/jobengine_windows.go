@@ -1,5 +1,4 @@
 package chefrunner
 
-var (
-	chefClientCommand = []string{"chef-client"}
-)
+// sudoCommand is empty as there is no sudo on Windows. ChefClient.Sudo is ignored.
+const sudoCommand = ""
@@ -0,0 +1,52 @@
+package chefrunner
+
+import (
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/metrics"
+	"github.com/morfien101/chef-waiter/precondition"
+)
+
+// SetPreconditions sets the checks that must pass before a job can start.
+func (r *RunRequest) SetPreconditions(checks ...precondition.Check) {
+	r.preconditions.Set(checks...)
+}
+
+// PreconditionResults returns the results from the last time a job left the queue.
+func (r *RunRequest) PreconditionResults() []internalstate.PreconditionResult {
+	return r.preconditions.Results()
+}
+
+// periodicOff marks a periodic job as skipped if periodic runs were turned off after it was
+// queued. It must not stay registered or later periodic requests would join a job that never runs.
+func (r *RunRequest) periodicOff(guid string) bool {
+	if r.state.ReadPeriodicRuns() {
+		return false
+	}
+	job, _ := r.state.ReadJob(guid)
+	r.state.UpdateSkipReason(guid, "periodic runs are turned off")
+	r.state.UpdateStatus(guid, "skipped")
+	r.logger.Infof("Skipped periodic run %s as periodic runs are turned off", guid)
+	metrics.Incr("run_skipped", 1, metadataTags(job.RunMetadata, map[string]string{"priority": jobPriority(job).String()}))
+	return true
+}
+
+// skipped evaluates the preconditions for a job that has left the queue. If any of them fail
+// the job is marked as skipped with the reason and true is returned.
+// A skipped periodic job still counts as the periodic run so the next one waits for the interval.
+func (r *RunRequest) skipped(guid string) bool {
+	reason := r.preconditions.Evaluate()
+	if reason == "" {
+		return false
+	}
+	job, _ := r.state.ReadJob(guid)
+	if !job.OnDemand {
+		r.state.UpdatelastRunStartTime(time.Now().Unix())
+	}
+	r.state.UpdateSkipReason(guid, reason)
+	r.state.UpdateStatus(guid, "skipped")
+	r.logger.Warningf("Skipped run %s as a precondition failed. %s", guid, reason)
+	metrics.Incr("run_skipped", 1, metadataTags(job.RunMetadata, map[string]string{"priority": jobPriority(job).String()}))
+	return true
+}
@@ -0,0 +1,83 @@
+package chefrunner
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/precondition"
+)
+
+func TestSkippedRun(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"periodic": &internalstate.JobDetails{Status: "registered", Attempt: 1},
+	}
+	rr := &RunRequest{state: st, logger: fakelogger}
+
+	if rr.skipped("periodic") {
+		t.Fatal("A job should not be skipped without preconditions")
+	}
+
+	var failure error
+	rr.SetPreconditions(precondition.Check{Name: "flag", Run: func() error { return failure }})
+	if rr.skipped("periodic") {
+		t.Fatal("A job should not be skipped when the preconditions pass")
+	}
+	failure = errors.New("do not converge")
+	if !rr.skipped("periodic") {
+		t.Fatal("A job should be skipped when a precondition fails")
+	}
+	job, _ := st.ReadJob("periodic")
+	if job.Status != "skipped" || job.SkipReason != "flag: do not converge" {
+		t.Errorf("The job should be skipped with the reason. Got status: %s, reason: %s", job.Status, job.SkipReason)
+	}
+	if st.GetlastRunStartTime() == 0 {
+		t.Error("A skipped periodic run should count as the periodic run")
+	}
+	if results := rr.PreconditionResults(); len(results) != 1 || results[0].Passed {
+		t.Errorf("The failed result should be kept for the status page. Got: %+v", results)
+	}
+}
+
+func TestPeriodicOff(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalStateTableSize: 20}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	st.WritePeriodicRuns(true)
+	rr := &RunRequest{state: st, queue: newRunQueue(5), logger: fakelogger}
+
+	first, err := rr.PeriodicRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	guid, _ := rr.queue.pop()
+	if rr.periodicOff(guid) {
+		t.Fatal("The job should run while periodic runs are on")
+	}
+
+	st.WritePeriodicRuns(false)
+	if !rr.periodicOff(guid) {
+		t.Fatal("The job should be skipped once periodic runs are off")
+	}
+	job, _ := st.ReadJob(first)
+	if job.Status != "skipped" || job.SkipReason == "" {
+		t.Errorf("The job should be skipped with the reason. Got status: %s, reason: %s", job.Status, job.SkipReason)
+	}
+	// Later periodic requests must not join the job that left the queue.
+	if second, _ := rr.PeriodicRun(); second == first || rr.queue.len() != 1 {
+		t.Errorf("A new periodic job should be queued. Got: %s, queue length: %d", second, rr.queue.len())
+	}
+}
@@ -0,0 +1,164 @@
+package chefrunner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+// Priority decides the order that jobs leave the run queue. Higher priorities run first
+// and jobs with the same priority run in the order they were queued.
+type Priority int
+
+// Priorities of the jobs in the run queue, lowest first.
+const (
+	PriorityPeriodic Priority = iota
+	PriorityOnDemand
+	PriorityCustom
+	PriorityEmergency
+)
+
+var priorityNames = map[Priority]string{
+	PriorityPeriodic:  "periodic",
+	PriorityOnDemand:  "ondemand",
+	PriorityCustom:    "custom",
+	PriorityEmergency: "emergency",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// jobPriority returns the priority that was recorded for the job. Jobs from before priorities
+// were recorded get the priority of their type.
+func jobPriority(job internalstate.JobDetails) Priority {
+	for priority, name := range priorityNames {
+		if job.Priority == name {
+			return priority
+		}
+	}
+	switch {
+	case !job.OnDemand:
+		return PriorityPeriodic
+	case job.CustomRun:
+		return PriorityCustom
+	}
+	return PriorityOnDemand
+}
+
+// ErrQueueFull is returned when a job can't be queued because the run queue is at its limit.
+var ErrQueueFull = errors.New("the run queue is full")
+
+// QueuedJob is a job waiting in the run queue.
+type QueuedJob struct {
+	GUID           string `json:"guid"`
+	Position       int    `json:"position"`
+	Priority       string `json:"priority"`
+	QueuedTime     int64  `json:"queued_time"`
+	EstimatedStart int64  `json:"estimated_start"`
+}
+
+type queueItem struct {
+	guid       string
+	priority   Priority
+	seq        uint64
+	queuedTime int64
+}
+
+// runQueue is a bounded priority queue of guids waiting to run.
+type runQueue struct {
+	sync.Mutex
+	items  []queueItem
+	size   int
+	seq    uint64
+	notify chan struct{}
+}
+
+func newRunQueue(size int) *runQueue {
+	return &runQueue{
+		size:   size,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push adds the guid to the queue. It returns ErrQueueFull if the queue is at its limit.
+func (q *runQueue) push(guid string, priority Priority) error {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.items) >= q.size {
+		return ErrQueueFull
+	}
+	q.seq++
+	q.items = append(q.items, queueItem{guid: guid, priority: priority, seq: q.seq, queuedTime: time.Now().Unix()})
+	q.sort()
+	q.wake()
+	return nil
+}
+
+// promote raises the priority of a queued guid. It returns true if the priority was raised.
+func (q *runQueue) promote(guid string, priority Priority) bool {
+	q.Lock()
+	defer q.Unlock()
+	for i := range q.items {
+		if q.items[i].guid == guid && q.items[i].priority < priority {
+			q.items[i].priority = priority
+			q.sort()
+			return true
+		}
+	}
+	return false
+}
+
+// pop blocks until there is a guid in the queue and returns the one with the highest priority.
+func (q *runQueue) pop() (string, Priority) {
+	for {
+		q.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items = q.items[1:]
+			q.Unlock()
+			return item.guid, item.priority
+		}
+		q.Unlock()
+		<-q.notify
+	}
+}
+
+// len returns the number of jobs waiting to run.
+func (q *runQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
+
+// list returns a copy of the queue in the order the jobs will run.
+func (q *runQueue) list() []queueItem {
+	q.Lock()
+	defer q.Unlock()
+	return append([]queueItem(nil), q.items...)
+}
+
+// sort orders the items by priority then by the order they were queued. The caller must hold the lock.
+func (q *runQueue) sort() {
+	sort.Slice(q.items, func(i, j int) bool {
+		if q.items[i].priority != q.items[j].priority {
+			return q.items[i].priority > q.items[j].priority
+		}
+		return q.items[i].seq < q.items[j].seq
+	})
+}
+
+// wake lets a blocked pop know that there is something in the queue. The caller must hold the lock.
+func (q *runQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,231 @@
+package chefrunner
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+func TestRunQueueOrder(t *testing.T) {
+	q := newRunQueue(5)
+	q.push("periodic", PriorityPeriodic)
+	q.push("demand-1", PriorityOnDemand)
+	q.push("custom", PriorityCustom)
+	q.push("demand-2", PriorityOnDemand)
+	q.push("emergency", PriorityEmergency)
+
+	if err := q.push("extra", PriorityEmergency); err != ErrQueueFull {
+		t.Errorf("Pushing to a full queue should return ErrQueueFull. Got: %v", err)
+	}
+
+	want := []string{"emergency", "custom", "demand-1", "demand-2", "periodic"}
+	for _, guid := range want {
+		got, _ := q.pop()
+		if got != guid {
+			t.Errorf("Queue order is incorrect. Got: %s, Want: %s", got, guid)
+		}
+	}
+}
+
+func TestRunQueuePromote(t *testing.T) {
+	q := newRunQueue(5)
+	q.push("demand", PriorityOnDemand)
+	q.push("custom", PriorityCustom)
+
+	if q.promote("custom", PriorityOnDemand) {
+		t.Error("promote should not lower a priority")
+	}
+	if !q.promote("demand", PriorityEmergency) {
+		t.Error("promote should raise the priority of a queued job")
+	}
+	if guid, priority := q.pop(); guid != "demand" || priority != PriorityEmergency {
+		t.Errorf("Promoted job should run first. Got: %s at %s", guid, priority)
+	}
+}
+
+func TestRunQueuePopBlocks(t *testing.T) {
+	q := newRunQueue(1)
+	popped := make(chan string)
+	go func() {
+		guid, _ := q.pop()
+		popped <- guid
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.push("late", PriorityOnDemand)
+	select {
+	case guid := <-popped:
+		if guid != "late" {
+			t.Errorf("pop returned the wrong guid. Got: %s", guid)
+		}
+	case <-time.After(time.Second):
+		t.Error("pop did not wake up after a push")
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalStateTableSize: 20}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	rr := &RunRequest{queue: newRunQueue(2), state: st, logger: fakelogger}
+
+	sub, err := rr.OnDemandRun(RunOptions{})
+	if err != nil || !sub.Created {
+		t.Fatalf("First run should be created. Got: %+v, Error: %v", sub, err)
+	}
+	demand := sub.GUID
+	// A second request joins the queued job and moves it up.
+	emergency, err := rr.EmergencyRun("", RunOptions{})
+	if err != nil || emergency.GUID != demand || emergency.Created {
+		t.Errorf("Emergency run should join the queued on demand job. Got: %+v, Want: %s, Error: %v", emergency, demand, err)
+	}
+	if job := st.ReadAllJobs()[demand]; job.Priority != "emergency" {
+		t.Errorf("Joined job should be promoted to emergency. Got: %s", job.Priority)
+	}
+	if _, err := rr.PeriodicRun(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rr.CustomRun("recipe[chefwaiter::test]", RunOptions{}); err != ErrQueueFull {
+		t.Errorf("Third job should not fit in the queue. Got: %v", err)
+	}
+	if len(st.ReadAllJobs()) != 2 {
+		t.Errorf("Rejected jobs should be removed from the state table. Got %d jobs", len(st.ReadAllJobs()))
+	}
+
+	queue := rr.Queue()
+	if len(queue) != 2 || queue[0].GUID != demand || queue[1].Priority != "periodic" {
+		t.Fatalf("Queue is incorrect. Got: %+v", queue)
+	}
+	if queue[1].EstimatedStart-queue[0].EstimatedStart != defaultRunDuration {
+		t.Errorf("Without finished runs jobs should be spaced by the default run duration. Got: %+v", queue)
+	}
+}
+
+// slowDelete widens the gap between a rejected job being registered and removed.
+type slowDelete struct {
+	*internalstate.StateTable
+}
+
+func (s slowDelete) Delete(guid string) {
+	time.Sleep(time.Millisecond)
+	s.StateTable.Delete(guid)
+}
+
+func TestSubmitFullQueueConcurrently(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalStateTableSize: 1000}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	rr := &RunRequest{queue: newRunQueue(1), state: slowDelete{st}, logger: fakelogger}
+	if _, err := rr.CustomRun("recipe[chefwaiter::test]", RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every on demand request either makes the job that is rejected or joins it. A request
+	// that joins must never get a guid that is then removed.
+	var wg sync.WaitGroup
+	accepted := make(chan string, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub, err := rr.OnDemandRun(RunOptions{})
+			if err == nil {
+				accepted <- sub.GUID
+			} else if err != ErrQueueFull {
+				t.Errorf("Request should be rejected as the queue is full. Got: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(accepted)
+	for guid := range accepted {
+		if _, ok := st.ReadJob(guid); !ok {
+			t.Errorf("Request was given %s which was then removed", guid)
+		}
+	}
+	if len(st.ReadAllJobs()) != 1 {
+		t.Errorf("Only the queued job should be in the state table. Got %d jobs", len(st.ReadAllJobs()))
+	}
+}
+
+func TestSubmitPrepare(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalStateTableSize: 20}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	rr := &RunRequest{queue: newRunQueue(5), state: st, logger: fakelogger}
+
+	if _, err := rr.OnDemandRun(RunOptions{Prepare: func(string) error { return errors.New("bad bundle") }}); err == nil {
+		t.Error("A failed prepare should be returned")
+	}
+	if len(st.ReadAllJobs()) != 0 || rr.queue.len() != 0 {
+		t.Errorf("A failed prepare should not register a job. Got %d jobs", len(st.ReadAllJobs()))
+	}
+
+	preparing := make(chan string)
+	release := make(chan struct{})
+	prepared := make(chan Submission)
+	go func() {
+		sub, err := rr.CustomRun("recipe[base]", RunOptions{Bundle: "sha", Prepare: func(guid string) error {
+			preparing <- guid
+			<-release
+			return nil
+		}})
+		if err != nil {
+			t.Error(err)
+		}
+		prepared <- sub
+	}()
+	guid := <-preparing
+	if _, ok := st.ReadJob(guid); ok {
+		t.Error("The job should not be registered until it is prepared")
+	}
+	// Other requests must not wait for the job to be prepared.
+	if _, err := rr.OnDemandRun(RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	sub := <-prepared
+	if job, ok := st.ReadJob(sub.GUID); sub.GUID != guid || !sub.Created || !ok || job.BundleSHA256 != "sha" {
+		t.Errorf("The prepared job should be registered with the guid it was prepared with. Got: %+v, %+v", sub, job)
+	}
+}
+
+func TestResumeQueuedRuns(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir, InternalStateTableSize: 20}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"second":    &internalstate.JobDetails{Status: "registered", Resumed: true, OnDemand: true, QueuedTime: 2},
+		"first":     &internalstate.JobDetails{Status: "registered", Resumed: true, OnDemand: true, QueuedTime: 1},
+		"periodic":  &internalstate.JobDetails{Status: "registered", Resumed: true, QueuedTime: 0},
+		"overflow":  &internalstate.JobDetails{Status: "registered", Resumed: true, OnDemand: true, QueuedTime: 3},
+		"abandoned": &internalstate.JobDetails{Status: "abandoned", OnDemand: true},
+	}
+	rr := &RunRequest{queue: newRunQueue(3), state: st, logger: fakelogger}
+	rr.resumeQueuedRuns()
+
+	for _, want := range []string{"first", "second", "periodic"} {
+		if guid, _ := rr.queue.pop(); guid != want {
+			t.Errorf("Resumed jobs are out of order. Got: %s, Want: %s", guid, want)
+		}
+	}
+	if status := st.ReadAllJobs()["overflow"].Status; status != "abandoned" {
+		t.Errorf("Jobs that don't fit in the queue should be abandoned. Got: %s", status)
+	}
+}
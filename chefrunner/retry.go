@@ -0,0 +1,117 @@
+package chefrunner
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// RetryPolicy decides if a failed run is tried again. MaxAttempts includes the first run
+// so a value of 1 or less turns retries off. The wait before each retry starts at Backoff
+// and doubles after each attempt. An empty ExitCodes retries any failure.
+// Periodic runs are retried. On demand and custom runs are only retried if OnDemand is true.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	ExitCodes   []int
+	OnDemand    bool
+}
+
+// retryable returns true if the job should be tried again after failing with the exit code.
+func (p RetryPolicy) retryable(job internalstate.JobDetails, exitCode int) bool {
+	if exitCode == 0 || job.Attempt >= p.MaxAttempts {
+		return false
+	}
+	if job.OnDemand && !p.OnDemand {
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	for _, code := range p.ExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the retry that follows the attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return p.Backoff << uint(attempt-1)
+}
+
+// SetRetryPolicy sets the policy used for runs that fail from now on.
+func (r *RunRequest) SetRetryPolicy(policy RetryPolicy) {
+	r.retryLock.Lock()
+	defer r.retryLock.Unlock()
+	r.retry = policy
+}
+
+func (r *RunRequest) retryPolicy() RetryPolicy {
+	r.retryLock.RLock()
+	defer r.retryLock.RUnlock()
+	return r.retry
+}
+
+// scheduleRetry queues a retry of the failed job after the backoff if the policy allows it.
+func (r *RunRequest) scheduleRetry(guid string, exitCode int) {
+	job, ok := r.state.ReadJob(guid)
+	policy := r.retryPolicy()
+	if !ok || !policy.retryable(job, exitCode) {
+		return
+	}
+	wait := policy.backoff(job.Attempt)
+	r.logger.Infof("Run %s failed with exit code %d on attempt %d. Retrying in %s", guid, exitCode, job.Attempt, wait)
+	metrics.Incr("run_retry_scheduled", 1, map[string]string{"priority": jobPriority(job).String(), "attempt": strconv.Itoa(job.Attempt + 1)})
+	time.AfterFunc(wait, func() {
+		r.queueRetry(guid)
+	})
+}
+
+// queueRetry registers and queues the retry. The retry is skipped if chef waiter is stopping,
+// runs are locked or the run queue is full. Retries of periodic runs are also skipped while
+// periodic runs are off or chef waiter is in maintenance.
+func (r *RunRequest) queueRetry(failedGUID string) {
+	failed, ok := r.state.ReadJob(failedGUID)
+	if !ok {
+		return
+	}
+	priority := jobPriority(failed)
+	reason := ""
+	switch {
+	case r.draining():
+		reason = "stopping"
+	case r.state.ReadRunLock():
+		reason = "locked"
+	case !failed.OnDemand && !r.state.ReadPeriodicRuns():
+		reason = "periodic_off"
+	case !failed.OnDemand && r.state.InMaintenceMode():
+		reason = "maintenance"
+	}
+	if reason != "" {
+		r.logger.Infof("Not retrying %s as the chef waiter is %s", failedGUID, reason)
+		metrics.Incr("run_retry_skipped", 1, map[string]string{"priority": priority.String(), "reason": reason})
+		return
+	}
+
+	r.submitLock.Lock()
+	defer r.submitLock.Unlock()
+	guid, ok := r.state.RegisterRetry(failedGUID)
+	if !ok {
+		return
+	}
+	if err := r.queue.push(guid, priority); err != nil {
+		r.state.Delete(guid)
+		metrics.Incr("run_retry_skipped", 1, map[string]string{"priority": priority.String(), "reason": "queue_full"})
+		r.logger.Warningf("Not retrying %s as the run queue is full", failedGUID)
+		return
+	}
+	r.logger.Infof("Queued %s as a retry of %s", guid, failedGUID)
+	metrics.Gauge("run_queue_depth", int64(r.queue.len()), nil)
+}
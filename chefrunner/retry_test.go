@@ -0,0 +1,83 @@
+package chefrunner
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Second, ExitCodes: []int{1}}
+	periodic := internalstate.JobDetails{Attempt: 1}
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		job      internalstate.JobDetails
+		exitCode int
+		want     bool
+	}{
+		{name: "Retryable exit code", policy: policy, job: periodic, exitCode: 1, want: true},
+		{name: "Passed", policy: policy, job: periodic, exitCode: 0},
+		{name: "Other exit code", policy: policy, job: periodic, exitCode: 2},
+		{name: "Last attempt", policy: policy, job: internalstate.JobDetails{Attempt: 3}, exitCode: 1},
+		{name: "On demand", policy: policy, job: internalstate.JobDetails{Attempt: 1, OnDemand: true}, exitCode: 1},
+		{name: "On demand allowed", policy: RetryPolicy{MaxAttempts: 2, OnDemand: true}, job: internalstate.JobDetails{Attempt: 1, OnDemand: true}, exitCode: 5, want: true},
+		{name: "Off", policy: RetryPolicy{MaxAttempts: 1}, job: periodic, exitCode: 1},
+	}
+	for _, test := range tests {
+		if got := test.policy.retryable(test.job, test.exitCode); got != test.want {
+			t.Errorf("%s: retryable should be %t", test.name, test.want)
+		}
+	}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+		if got := policy.backoff(attempt); got != want {
+			t.Errorf("Backoff after attempt %d is wrong. Got: %s, Want: %s", attempt, got, want)
+		}
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	testDir := filet.TmpDir(t, "")
+	defer os.RemoveAll(testDir)
+	configContainer := &config.ValuesContainer{InternalStateFileLocation: testDir}
+	fakelogger := logs.NewFakeLogger(false)
+	st := internalstate.New(configContainer, cheflogs.New(configContainer, fakelogger), fakelogger)
+	st.Status = map[string]*internalstate.JobDetails{
+		"failed": &internalstate.JobDetails{Status: "failed", OnDemand: true, Priority: "emergency", Attempt: 1},
+	}
+	rr := &RunRequest{state: st, queue: newRunQueue(5), logger: fakelogger}
+
+	rr.queueRetry("failed")
+	queued := rr.queue.list()
+	if len(queued) != 1 || queued[0].priority != PriorityEmergency {
+		t.Fatalf("The retry should be queued with the priority of the failed job. Got: %+v", queued)
+	}
+	retry, _ := st.ReadJob(queued[0].guid)
+	if retry.ParentGUID != "failed" || retry.Attempt != 2 || !retry.OnDemand {
+		t.Errorf("Retry is not linked to the failed job. Got: %+v", retry)
+	}
+
+	// A retry of the retry keeps the first job as the parent.
+	st.UpdateStatus(queued[0].guid, "failed")
+	rr.queue.pop()
+	rr.queueRetry(queued[0].guid)
+	again, _ := st.ReadJob(rr.queue.list()[0].guid)
+	if again.ParentGUID != "failed" || again.Attempt != 3 {
+		t.Errorf("Second retry is not linked to the first job. Got: %+v", again)
+	}
+	rr.queue.pop()
+
+	st.LockRuns(true)
+	rr.queueRetry("failed")
+	if rr.queue.len() != 0 {
+		t.Error("Retries should not be queued while runs are locked")
+	}
+}
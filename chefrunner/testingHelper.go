@@ -1,29 +1,85 @@
 package chefrunner
 
+import (
+	"sync"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
 // This is a basic implementation of the chef worker that can assit in testing in other package.
 
 //FakeChefRunnerWorker used for testing
 // Fake out the things we need to isolate the web package form the rest of chefwaiter.
 type FakeChefRunnerWorker struct {
 	maintenance bool
+	// State is optional. If set the fake guids are added to it so that they can be read back.
+	State internalstate.StateTableWriter
+	// QueueFull makes every run fail with ErrQueueFull.
+	QueueFull bool
+	// Draining makes every run fail with ErrDraining.
+	Draining bool
+	queued    []QueuedJob
 }
 
 // OnDemandRun will return a static string with onde to identify that it was a on demand job.
 // The string will statify the regex for guids
-func (c *FakeChefRunnerWorker) OnDemandRun() string {
-	return `onde-1234-1234-1234-1234`
+func (c *FakeChefRunnerWorker) OnDemandRun(opts RunOptions) (Submission, error) {
+	return c.submit(`onde-1234-1234-1234-1234`, true, PriorityOnDemand, opts)
 }
 
 // PeriodicRun will return a static string with onde to identify that it was a periodic job.
 // The string will statify the regex for guids
-func (c *FakeChefRunnerWorker) PeriodicRun() string {
-	return `peri-1234-1234-1234-1234`
+func (c *FakeChefRunnerWorker) PeriodicRun() (string, error) {
+	return c.register(`peri-1234-1234-1234-1234`, false, PriorityPeriodic, RunOptions{})
 }
 
 // CustomRun will return a static string with onde to identify that it was a periodic job.
 // The string will statify the regex for guids
-func (c *FakeChefRunnerWorker) CustomRun(jobDetails string) string {
-	return `cust-1234-1234-1234-1234`
+func (c *FakeChefRunnerWorker) CustomRun(jobDetails string, opts RunOptions) (Submission, error) {
+	return c.submit(`cust-1234-1234-1234-1234`, true, PriorityCustom, opts)
+}
+
+// EmergencyRun will return a static string with emer to identify that it was an emergency job.
+func (c *FakeChefRunnerWorker) EmergencyRun(jobDetails string, opts RunOptions) (Submission, error) {
+	return c.submit(`emer-1234-1234-1234-1234`, true, PriorityEmergency, opts)
+}
+
+// submit registers the fake guid. A guid is only created the first time it is handed out.
+func (c *FakeChefRunnerWorker) submit(guid string, ondemand bool, priority Priority, opts RunOptions) (Submission, error) {
+	for _, queued := range c.queued {
+		if queued.GUID == guid && opts.Bundle == "" {
+			return Submission{GUID: guid}, nil
+		}
+	}
+	guid, err := c.register(guid, ondemand, priority, opts)
+	return Submission{GUID: guid, Created: err == nil}, err
+}
+
+// Queue will return the fake jobs in the order they were requested.
+func (c *FakeChefRunnerWorker) Queue() []QueuedJob {
+	return c.queued
+}
+
+func (c *FakeChefRunnerWorker) register(guid string, ondemand bool, priority Priority, opts RunOptions) (string, error) {
+	if c.QueueFull {
+		return "", ErrQueueFull
+	}
+	if c.Draining {
+		return "", ErrDraining
+	}
+	if opts.Prepare != nil {
+		if err := opts.Prepare(guid); err != nil {
+			return "", err
+		}
+	}
+	if c.State != nil {
+		c.State.Add(guid, ondemand)
+		c.State.UpdatePriority(guid, priority.String())
+		c.State.UpdateMetadata(guid, opts.Metadata)
+		c.State.UpdateBundle(guid, opts.Bundle)
+	}
+	c.queued = append(c.queued, QueuedJob{GUID: guid, Position: len(c.queued) + 1, Priority: priority.String()})
+	return guid, nil
 }
 
 // InMaintenanceMode will return the maintenace value
@@ -35,3 +91,19 @@ func (c *FakeChefRunnerWorker) InMaintenanceMode() bool {
 func NewFakeChefRunnerWorker(inMaintenanceMode bool) *FakeChefRunnerWorker {
 	return &FakeChefRunnerWorker{maintenance: inMaintenanceMode}
 }
+
+// FakeExecutor records the jobs it is asked to run instead of running them.
+// Every job exits with ExitCode.
+type FakeExecutor struct {
+	sync.Mutex
+	ExitCode   int
+	Executions []Execution
+}
+
+// Execute records the job and returns ExitCode.
+func (f *FakeExecutor) Execute(e Execution) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.Executions = append(f.Executions, e)
+	return f.ExitCode, nil
+}
@@ -0,0 +1,174 @@
+// Package cli holds the client sub commands of the chefwaiter binary.
+// They talk to a local or remote chef waiter over HTTP(S) using the client package.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/morfien101/chef-waiter/client"
+)
+
+// Exit codes used when we don't have an exit code from chef.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Exit codes for runs that finished without chef giving a result. They are
+// kept clear of the codes that chef-client uses.
+const (
+	exitSkipped    = 100
+	exitAborted    = 101
+	exitTerminated = 102
+)
+
+// defaultAddress is used when --addr and CHEFWAITER_ADDR are not set.
+const defaultAddress = "http://127.0.0.1:8901"
+
+// command is a sub command of the chefwaiter binary.
+type command struct {
+	description string
+	run         func(env *environment, args []string) int
+}
+
+var commands = map[string]command{
+	"run":         {description: "Start a chef run and optionally wait for it to finish.", run: runCommand},
+	"logs":        {description: "Show the chef logs for a run.", run: logsCommand},
+	"lock":        {description: "Lock the chef waiter so that no runs can start.", run: lockCommand},
+	"unlock":      {description: "Remove the lock from the chef waiter.", run: unlockCommand},
+	"status":      {description: "Show the status of the chef waiter.", run: statusCommand},
+	"maintenance": {description: "Start or end a maintenance window. eg: maintenance start 30m", run: maintenanceCommand},
+	"diagnostics": {description: "Check the connection from chef-client to its chef server.", run: diagnosticsCommand},
+}
+
+// environment holds what the commands need to talk to the chef waiter and the user.
+type environment struct {
+	stdout io.Writer
+	stderr io.Writer
+	flags  *flag.FlagSet
+	opts   *globalOptions
+}
+
+// globalOptions are the flags that every command accepts.
+type globalOptions struct {
+	address  string
+	insecure bool
+	caCert   string
+	timeout  time.Duration
+	retries  int
+}
+
+// IsCommand will return true if name is a client sub command.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Usage writes out the list of sub commands.
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Client commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintln(w, "Use 'chefwaiter <command> -h' to see the flags for a command.")
+}
+
+// Run will run the sub command in args[0] and return the exit code for the process.
+// When waiting on a chef run the exit code is the exit code of chef.
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || !IsCommand(args[0]) {
+		Usage(stderr)
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("chefwaiter "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts := &globalOptions{}
+	address := os.Getenv("CHEFWAITER_ADDR")
+	if address == "" {
+		address = defaultAddress
+	}
+	fs.StringVar(&opts.address, "addr", address, "Address of the chef waiter. Can also be set with CHEFWAITER_ADDR.")
+	fs.BoolVar(&opts.insecure, "insecure", false, "Skip TLS certificate verification.")
+	fs.StringVar(&opts.caCert, "ca-cert", "", "PEM file with the CA that signed the chef waiter certificate.")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "Timeout for each request to the chef waiter.")
+	fs.IntVar(&opts.retries, "retries", 3, "Number of times to retry a failed request.")
+
+	return commands[args[0]].run(&environment{stdout: stdout, stderr: stderr, flags: fs, opts: opts}, args[1:])
+}
+
+// parse will parse the flags and return the positional arguments. Flags are allowed
+// after positional arguments, eg: logs <guid> --follow
+func (env *environment) parse(args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := env.flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = env.flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// client builds a client from the global flags.
+func (env *environment) client() (*client.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: env.opts.insecure}
+	if env.opts.caCert != "" {
+		pem, err := ioutil.ReadFile(env.opts.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate. Error: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", env.opts.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	httpClient := &http.Client{
+		Timeout:   env.opts.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	return client.New(env.opts.address, client.WithHTTPClient(httpClient), client.WithRetries(env.opts.retries, time.Second))
+}
+
+// printJSON writes v to stdout as indented JSON.
+func (env *environment) printJSON(v interface{}) int {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return env.fail(err)
+	}
+	fmt.Fprintln(env.stdout, string(b))
+	return exitOK
+}
+
+// fail writes the error out and returns the generic error exit code.
+func (env *environment) fail(err error) int {
+	fmt.Fprintf(env.stderr, "Error: %s\n", err)
+	return exitError
+}
+
+// usage writes out the error and the flags for the command.
+func (env *environment) usage(format string, a ...interface{}) int {
+	fmt.Fprintf(env.stderr, format+"\n", a...)
+	env.flags.PrintDefaults()
+	return exitUsage
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/client"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/webengine"
+)
+
+type fakeAppStatus struct{}
+
+func (fa *fakeAppStatus) JSONEncoded() ([]byte, error) {
+	return json.Marshal(&internalstate.AppStatus{ServiceName: "ChefWaiter", Healthy: true})
+}
+
+// newTestServer starts a chef waiter API backed by fakes.
+func newTestServer(t *testing.T) (*httptest.Server, *internalstate.StateTable) {
+	logger := logs.NewFakeLogger(false)
+	configFile, err := config.TestConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(configFile.Name())
+	cfg, err := config.New(configFile.Name(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create the config handler. Error: %s", err)
+	}
+	cheflogsworker := cheflogs.NewFakeChefLogWorker("")
+	state := internalstate.New(cfg, cheflogsworker, logger)
+	worker := chefrunner.NewFakeChefRunnerWorker(false)
+	worker.State = state
+	engine := webengine.New(state, &fakeAppStatus{}, worker, cheflogsworker, logger)
+	return httptest.NewServer(engine), state
+}
+
+func run(args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := Run(args, stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	server, state := newTestServer(t)
+	defer server.Close()
+	addr := "--addr=" + server.URL
+
+	// Flags are allowed after the positional arguments.
+	code, stdout, stderr := run("lock", "--reason", "deploying", addr)
+	if code != exitOK || !strings.Contains(stdout, `"reason": "deploying"`) {
+		t.Errorf("lock failed. Code: %d, Stdout: %s, Stderr: %s", code, stdout, stderr)
+	}
+	if code, _, stderr = run("run", addr); code != exitError || !strings.Contains(stderr, "locked") {
+		t.Errorf("run while locked should fail. Code: %d, Stderr: %s", code, stderr)
+	}
+	if code, _, _ = run("unlock", addr); code != exitOK {
+		t.Errorf("unlock failed. Code: %d", code)
+	}
+	if code, stdout, _ = run("status", addr); code != exitOK || !strings.Contains(stdout, "ChefWaiter") {
+		t.Errorf("status failed. Code: %d, Stdout: %s", code, stdout)
+	}
+	if code, _, stderr = run("diagnostics", "--retries=0", addr); code != exitError || !strings.Contains(stderr, "not set up") {
+		t.Errorf("diagnostics that are not set up should fail. Code: %d, Stderr: %s", code, stderr)
+	}
+	if code, stdout, _ = run("maintenance", "start", "30m", addr); code != exitOK || !strings.Contains(stdout, `"in_maintenance": true`) {
+		t.Errorf("maintenance start failed. Code: %d, Stdout: %s", code, stdout)
+	}
+	if code, stdout, _ = run("maintenance", "end", addr); code != exitOK || !strings.Contains(stdout, `"in_maintenance": false`) {
+		t.Errorf("maintenance end failed. Code: %d, Stdout: %s", code, stdout)
+	}
+
+	// The fake worker always hands out the same guid for custom runs.
+	guid := "cust-1234-1234-1234-1234"
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		state.UpdateExitCode(guid, 4)
+		state.UpdateStatus(guid, "failed")
+	}()
+	code, stdout, stderr = run("run", "--custom", "recipe[chefwaiter::test]", "--wait", "--poll", "10ms", "--requester", "alice", "--label", "team=web", "--label", "ticket=OPS-1", addr)
+	if code != 4 {
+		t.Errorf("run --wait should exit with the chef exit code 4. Got: %d, Stdout: %s, Stderr: %s", code, stdout, stderr)
+	}
+	if job, _ := state.ReadJob(guid); job.Requester != "alice" || job.Labels["team"] != "web" || job.Labels["ticket"] != "OPS-1" {
+		t.Errorf("run should record the requester and labels. Got: %+v", job.RunMetadata)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{"nope"},
+		{"run", "extra"},
+		{"run", "--force"},
+		{"run", "--bundle", "cookbooks.tgz", "--coalesce", "always-new"},
+		{"run", "--policy-group", "canary"},
+		{"run", "--policy-group", "canary", "--policy-name", "web", "--custom", "recipe[base]"},
+		{"run", "--label", "team"},
+		{"logs"},
+		{"status", "--bogus"},
+		{"diagnostics", "extra"},
+		{"maintenance", "start", "soon"},
+		{"maintenance", "pause"},
+	}
+	for _, args := range tests {
+		if code, _, _ := run(append(args, "--addr=http://127.0.0.1:1")...); code != exitUsage {
+			t.Errorf("%v should be a usage error. Got exit code %d", args, code)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		job  client.Job
+		want int
+	}{
+		{job: client.Job{Status: "complete"}, want: exitOK},
+		{job: client.Job{Status: "complete", ExitCode: 35}, want: 35},
+		{job: client.Job{Status: "failed", ExitCode: 4}, want: 4},
+		{job: client.Job{Status: "failed"}, want: exitError},
+		{job: client.Job{Status: "skipped"}, want: exitSkipped},
+		// The exit code of an aborted job is from the pre run hook, not chef.
+		{job: client.Job{Status: "aborted", ExitCode: 3}, want: exitAborted},
+		{job: client.Job{Status: "terminated", ExitCode: -1}, want: exitTerminated},
+		{job: client.Job{Status: "abandoned"}, want: exitError},
+		{job: client.Job{Status: "unknown"}, want: exitError},
+	}
+	for _, test := range tests {
+		if got := exitCodeFor(&test.job); got != test.want {
+			t.Errorf("%s with exit code %d should exit with %d. Got: %d", test.job.Status, test.job.ExitCode, test.want, got)
+		}
+	}
+}
+
+func TestParseMaintenanceDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30m": 30 * time.Minute,
+		"2h":  2 * time.Hour,
+		"15":  15 * time.Minute,
+	}
+	for value, want := range tests {
+		if got, err := parseMaintenanceDuration(value); err != nil || got != want {
+			t.Errorf("parseMaintenanceDuration(%s) = %s, %v. Want %s", value, got, err, want)
+		}
+	}
+	if _, err := parseMaintenanceDuration("-5m"); err == nil {
+		t.Error("A negative duration should be rejected")
+	}
+}
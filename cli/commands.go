@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morfien101/chef-waiter/client"
+)
+
+// runCommand starts a chef run. With --wait it returns the exit code of chef.
+func runCommand(env *environment, args []string) int {
+	custom := env.flags.String("custom", "", "Custom run list to use, eg: recipe[chefwaiter::test]")
+	force := env.flags.Bool("force", false, "Run the custom run list even if the chef waiter is locked.")
+	priority := env.flags.String("priority", "", "Set to emergency to put the run at the front of the queue.")
+	coalesce := env.flags.String("coalesce", "", "join-queued, always-new or after-timestamp=<epoch>. Defaults to join-queued.")
+	requester := env.flags.String("requester", currentUser(), "Who is requesting the run. Defaults to the current user.")
+	reason := env.flags.String("reason", "", "Why the run is needed.")
+	pipelineURL := env.flags.String("pipeline-url", "", "Link to the pipeline or build that requested the run.")
+	labels := labelFlag{}
+	env.flags.Var(labels, "label", "A key=value label for the run. Can be given many times.")
+	wait := env.flags.Bool("wait", false, "Wait for the run to finish and exit with the chef exit code.")
+	poll := env.flags.Duration("poll", 5*time.Second, "How often to check the run when waiting.")
+	policyGroup := env.flags.String("policy-group", "", "Policyfile policy group for a custom run. Needs --policy-name.")
+	policyName := env.flags.String("policy-name", "", "Policyfile policy name for a custom run. Needs --policy-group.")
+	bundle := env.flags.String("bundle", "", "Gzipped tar of cookbooks or a policy archive to upload and run in local mode.")
+	positional, err := env.parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		return env.usage("run does not take any arguments. Got: %s", strings.Join(positional, " "))
+	}
+	policy := *policyGroup != "" || *policyName != ""
+	if policy && (*policyGroup == "" || *policyName == "") {
+		return env.usage("--policy-group and --policy-name must be used together")
+	}
+	if policy && (*custom != "" || *bundle != "") {
+		return env.usage("--policy-group and --policy-name can not be used with --custom or --bundle")
+	}
+	if *force && *custom == "" && !policy {
+		return env.usage("--force can only be used with --custom or a policy")
+	}
+	if *bundle != "" && (*force || *coalesce != "") {
+		return env.usage("--force and --coalesce can not be used with --bundle")
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+
+	ctx := context.Background()
+	metadata := client.RunMetadata{
+		Requester:   *requester,
+		Reason:      *reason,
+		PipelineURL: *pipelineURL,
+		Labels:      labels,
+	}
+	var created *client.CreatedJob
+	if *bundle != "" {
+		content, readErr := ioutil.ReadFile(*bundle)
+		if readErr != nil {
+			return env.fail(readErr)
+		}
+		created, err = c.TriggerBundleRun(ctx, content, client.BundleRequest{RunList: *custom, Priority: *priority, RunMetadata: metadata})
+	} else {
+		created, err = c.Trigger(ctx, client.RunRequest{
+			CustomRun:   *custom,
+			Force:       *force,
+			Priority:    *priority,
+			Coalesce:    *coalesce,
+			Policy:      client.Policy{PolicyGroup: *policyGroup, PolicyName: *policyName},
+			RunMetadata: metadata,
+		})
+	}
+	if err != nil {
+		return env.fail(err)
+	}
+	if !*wait {
+		return env.printJSON(created)
+	}
+
+	job, err := c.Wait(ctx, created.GUID, *poll)
+	if err != nil {
+		return env.fail(err)
+	}
+	if code := env.printJSON(job); code != exitOK {
+		return code
+	}
+	return exitCodeFor(job)
+}
+
+// logsCommand prints the chef logs for a run. With --follow it keeps printing
+// new log lines until the run has finished. With --hooks it prints the hook output instead.
+func logsCommand(env *environment, args []string) int {
+	follow := env.flags.Bool("follow", false, "Keep printing the logs until the run finishes.")
+	poll := env.flags.Duration("poll", 2*time.Second, "How often to check for new logs when following.")
+	hooks := env.flags.Bool("hooks", false, "Show the output of the pre and post run hooks instead of the chef logs.")
+	positional, err := env.parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		return env.usage("logs needs a single run guid. eg: chefwaiter logs <guid>")
+	}
+	guid := positional[0]
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+
+	fetch := c.Logs
+	if *hooks {
+		fetch = c.HookLogs
+	}
+	ctx := context.Background()
+	if !*follow {
+		text, err := fetch(ctx, guid)
+		if err != nil {
+			return env.fail(err)
+		}
+		fmt.Fprint(env.stdout, text)
+		return exitOK
+	}
+
+	printed := 0
+	for {
+		// Check the job first so that the last read of the logs happens after it finished.
+		job, err := c.Run(ctx, guid)
+		if err != nil {
+			return env.fail(err)
+		}
+		text, err := fetch(ctx, guid)
+		// The log file only exists once the run has started.
+		if err != nil && !(client.IsCode(err, "not_found") && !job.Finished()) {
+			return env.fail(err)
+		}
+		if len(text) > printed {
+			fmt.Fprint(env.stdout, text[printed:])
+			printed = len(text)
+		}
+		if job.Finished() {
+			return exitCodeFor(job)
+		}
+		time.Sleep(*poll)
+	}
+}
+
+// lockCommand locks the chef waiter.
+func lockCommand(env *environment, args []string) int {
+	reason := env.flags.String("reason", "", "Why the chef waiter is locked. Shown to anyone that checks the lock.")
+	if code, ok := env.noArgs("lock", args); !ok {
+		return code
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+	lock, err := c.Lock(context.Background(), *reason)
+	if err != nil {
+		return env.fail(err)
+	}
+	return env.printJSON(lock)
+}
+
+// unlockCommand removes the lock from the chef waiter.
+func unlockCommand(env *environment, args []string) int {
+	if code, ok := env.noArgs("unlock", args); !ok {
+		return code
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+	lock, err := c.Unlock(context.Background())
+	if err != nil {
+		return env.fail(err)
+	}
+	return env.printJSON(lock)
+}
+
+// statusCommand prints the status of the chef waiter.
+func statusCommand(env *environment, args []string) int {
+	if code, ok := env.noArgs("status", args); !ok {
+		return code
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+	status, err := c.Status(context.Background())
+	if err != nil {
+		return env.fail(err)
+	}
+	return env.printJSON(status)
+}
+
+// diagnosticsCommand prints the chef server diagnostics. It exits with an error if a check failed.
+func diagnosticsCommand(env *environment, args []string) int {
+	if code, ok := env.noArgs("diagnostics", args); !ok {
+		return code
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+	report, err := c.Diagnostics(context.Background())
+	if err != nil {
+		return env.fail(err)
+	}
+	if code := env.printJSON(report); code != exitOK || report.Passed {
+		return code
+	}
+	return exitError
+}
+
+// maintenanceCommand shows, starts or ends a maintenance window.
+func maintenanceCommand(env *environment, args []string) int {
+	positional, err := env.parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	c, err := env.client()
+	if err != nil {
+		return env.fail(err)
+	}
+
+	ctx := context.Background()
+	var maintenance *client.Maintenance
+	switch {
+	case len(positional) == 0:
+		maintenance, err = c.Maintenance(ctx)
+	case positional[0] == "start" && len(positional) == 2:
+		d, parseErr := parseMaintenanceDuration(positional[1])
+		if parseErr != nil {
+			return env.usage("%s", parseErr)
+		}
+		maintenance, err = c.SetMaintenance(ctx, d)
+	case positional[0] == "end" && len(positional) == 1:
+		maintenance, err = c.EndMaintenance(ctx)
+	default:
+		return env.usage("maintenance takes 'start <duration>' or 'end'. eg: chefwaiter maintenance start 30m")
+	}
+	if err != nil {
+		return env.fail(err)
+	}
+	return env.printJSON(maintenance)
+}
+
+// noArgs parses the flags for commands that don't take positional arguments.
+func (env *environment) noArgs(name string, args []string) (int, bool) {
+	positional, err := env.parse(args)
+	if err != nil {
+		return exitUsage, false
+	}
+	if len(positional) != 0 {
+		return env.usage("%s does not take any arguments. Got: %s", name, strings.Join(positional, " ")), false
+	}
+	return exitOK, true
+}
+
+// parseMaintenanceDuration accepts a Go duration like 30m or 2h, or a plain number of minutes.
+func parseMaintenanceDuration(value string) (time.Duration, error) {
+	if minutes, err := strconv.ParseInt(value, 10, 64); err == nil {
+		value = fmt.Sprintf("%dm", minutes)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s is not a valid maintenance duration. eg: 30m or 2h", value)
+	}
+	return d, nil
+}
+
+// labelFlag collects the key=value pairs from a repeated flag.
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("labels must be in the form key=value. Got: %s", value)
+	}
+	l[parts[0]] = parts[1]
+	return nil
+}
+
+// currentUser is the name of the user running the command, if we can find it.
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// exitCodeFor returns the exit code for a finished job. Only jobs that ran chef
+// pass on the exit code of chef, the rest have their own exit codes.
+func exitCodeFor(job *client.Job) int {
+	switch job.Status {
+	case "complete", "failed":
+		if job.ExitCode != 0 {
+			return job.ExitCode
+		}
+		if job.Status == "failed" {
+			return exitError
+		}
+		return exitOK
+	case "skipped":
+		return exitSkipped
+	case "aborted":
+		return exitAborted
+	case "terminated":
+		return exitTerminated
+	}
+	return exitError
+}
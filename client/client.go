@@ -0,0 +1,492 @@
+// Package client is a Go client for the chef waiter API.
+// Every method maps to an operation in the OpenAPI document served at /openapi.json.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// operation is a route on the chef waiter. The key in operations is the
+// operationId from the OpenAPI document.
+type operation struct {
+	method string
+	path   string
+}
+
+var operations = map[string]operation{
+	"createRun":        {http.MethodPost, "/v2/runs"},
+	"createBundleRun":  {http.MethodPost, "/v2/runs/bundle"},
+	"listRuns":         {http.MethodGet, "/v2/runs"},
+	"getRun":           {http.MethodGet, "/v2/runs/{guid}"},
+	"getRunLogs":       {http.MethodGet, "/v2/runs/{guid}/logs"},
+	"getRunHookLogs":   {http.MethodGet, "/v2/runs/{guid}/hooks"},
+	"getInterval":      {http.MethodGet, "/v2/interval"},
+	"setInterval":      {http.MethodPut, "/v2/interval"},
+	"getPeriodic":      {http.MethodGet, "/v2/periodic"},
+	"setPeriodic":      {http.MethodPut, "/v2/periodic"},
+	"getMaintenance":   {http.MethodGet, "/v2/maintenance"},
+	"startMaintenance": {http.MethodPut, "/v2/maintenance"},
+	"endMaintenance":   {http.MethodDelete, "/v2/maintenance"},
+	"getLock":          {http.MethodGet, "/v2/lock"},
+	"setLock":          {http.MethodPut, "/v2/lock"},
+	"removeLock":       {http.MethodDelete, "/v2/lock"},
+	"getStatus":        {http.MethodGet, "/v2/status"},
+	"getNextRun":       {http.MethodGet, "/chef/nextrun"},
+	"getLastRun":       {http.MethodGet, "/chef/lastrun"},
+	"getQueue":         {http.MethodGet, "/chef/queue"},
+	"getDiagnostics":   {http.MethodGet, "/chef/diagnostics"},
+	"getNode":          {http.MethodGet, "/chef/node"},
+	"getFailedRun":     {http.MethodGet, "/chef/failed-run"},
+	"healthCheck":      {http.MethodGet, "/healthcheck"},
+	"liveCheck":        {http.MethodGet, "/livez"},
+	"readyCheck":       {http.MethodGet, "/readyz"},
+	"getOpenAPI":       {http.MethodGet, "/openapi.json"},
+	"streamEvents":     {http.MethodGet, "/events"},
+}
+
+// Client talks to a single chef waiter.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retries    int
+	retryWait  time.Duration
+}
+
+// Option is used to change the defaults of a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used to make requests. Use this to set up TLS.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and how long to wait
+// before the first retry. The wait doubles after each retry.
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryWait = wait
+	}
+}
+
+// New will return a Client for the chef waiter at baseURL, eg: http://127.0.0.1:8901
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("chefwaiter address %s must start with http:// or https://", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    3,
+		retryWait:  500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Trigger will request the run described by req.
+func (c *Client) Trigger(ctx context.Context, req RunRequest) (*CreatedJob, error) {
+	job := &CreatedJob{}
+	return job, c.do(ctx, "createRun", nil, &req, job)
+}
+
+// TriggerBundleRun uploads a gzipped tar of cookbooks or a policy archive and runs it in
+// local mode. The sha256 checksum is worked out from bundle. A new job is always created.
+func (c *Client) TriggerBundleRun(ctx context.Context, bundle []byte, req BundleRequest) (*CreatedJob, error) {
+	job := &CreatedJob{}
+	query := req.query()
+	sum := sha256.Sum256(bundle)
+	query.Set("sha256", hex.EncodeToString(sum[:]))
+	return job, c.doQuery(ctx, "createBundleRun", nil, query, rawBody{contentType: "application/gzip", data: bundle}, job)
+}
+
+// TriggerRun will request an on demand chef run.
+// If a run is already queued its job is returned.
+func (c *Client) TriggerRun(ctx context.Context) (*CreatedJob, error) {
+	return c.Trigger(ctx, RunRequest{})
+}
+
+// TriggerCustomRun will request a run with a custom run list, eg: recipe[chefwaiter::test].
+// force will run it even if the chef waiter is locked.
+func (c *Client) TriggerCustomRun(ctx context.Context, runList string, force bool) (*CreatedJob, error) {
+	return c.Trigger(ctx, RunRequest{CustomRun: runList, Force: force})
+}
+
+// TriggerPolicyRun will request a custom run that uses the policy group and name.
+// They must be in the allowed policies of the chef waiter.
+func (c *Client) TriggerPolicyRun(ctx context.Context, policyGroup, policyName string, force bool) (*CreatedJob, error) {
+	return c.Trigger(ctx, RunRequest{Policy: Policy{PolicyGroup: policyGroup, PolicyName: policyName}, Force: force})
+}
+
+// TriggerEmergencyRun will request a run that goes to the front of the queue.
+// If runList is empty it is an on demand run, otherwise it is a custom run.
+func (c *Client) TriggerEmergencyRun(ctx context.Context, runList string, force bool) (*CreatedJob, error) {
+	return c.Trigger(ctx, RunRequest{CustomRun: runList, Force: force, Priority: "emergency"})
+}
+
+// Run will return the job for the guid.
+func (c *Client) Run(ctx context.Context, guid string) (*Job, error) {
+	job := &Job{}
+	return job, c.do(ctx, "getRun", map[string]string{"guid": guid}, nil, job)
+}
+
+// Runs will return all the jobs that the chef waiter knows about.
+func (c *Client) Runs(ctx context.Context) ([]Job, error) {
+	jobs := []Job{}
+	err := c.do(ctx, "listRuns", nil, nil, &jobs)
+	return jobs, err
+}
+
+// RunsMatching will return the jobs that match the filter.
+func (c *Client) RunsMatching(ctx context.Context, filter RunFilter) ([]Job, error) {
+	jobs := []Job{}
+	err := c.doQuery(ctx, "listRuns", nil, filter.query(), nil, &jobs)
+	return jobs, err
+}
+
+// Queue will return the jobs waiting to run in the order that they will run.
+func (c *Client) Queue(ctx context.Context) ([]QueuedJob, error) {
+	queue := []QueuedJob{}
+	err := c.do(ctx, "getQueue", nil, nil, &queue)
+	return queue, err
+}
+
+// Wait will poll the job every interval until it has finished or the context is done.
+func (c *Client) Wait(ctx context.Context, guid string, interval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Run(ctx, guid)
+		if err != nil {
+			return nil, err
+		}
+		if job.Finished() {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Logs will return the chef logs for the guid.
+func (c *Client) Logs(ctx context.Context, guid string) (string, error) {
+	buf := &bytes.Buffer{}
+	err := c.do(ctx, "getRunLogs", map[string]string{"guid": guid}, nil, buf)
+	return buf.String(), err
+}
+
+// HookLogs will return the output of the pre and post run hooks for the guid.
+func (c *Client) HookLogs(ctx context.Context, guid string) (string, error) {
+	buf := &bytes.Buffer{}
+	err := c.do(ctx, "getRunHookLogs", map[string]string{"guid": guid}, nil, buf)
+	return buf.String(), err
+}
+
+// Interval will return the time between periodic runs.
+func (c *Client) Interval(ctx context.Context) (*Interval, error) {
+	interval := &Interval{}
+	return interval, c.do(ctx, "getInterval", nil, nil, interval)
+}
+
+// SetInterval will set the minutes between periodic runs.
+func (c *Client) SetInterval(ctx context.Context, minutes int64) (*Interval, error) {
+	interval := &Interval{}
+	return interval, c.do(ctx, "setInterval", nil, &intervalRequest{Minutes: minutes}, interval)
+}
+
+// Periodic will return if periodic runs are enabled.
+func (c *Client) Periodic(ctx context.Context) (*Periodic, error) {
+	periodic := &Periodic{}
+	return periodic, c.do(ctx, "getPeriodic", nil, nil, periodic)
+}
+
+// SetPeriodic will turn periodic runs on or off.
+func (c *Client) SetPeriodic(ctx context.Context, enabled bool) (*Periodic, error) {
+	periodic := &Periodic{}
+	return periodic, c.do(ctx, "setPeriodic", nil, &periodicRequest{Enabled: enabled}, periodic)
+}
+
+// Maintenance will return the maintenance window.
+func (c *Client) Maintenance(ctx context.Context) (*Maintenance, error) {
+	maintenance := &Maintenance{}
+	return maintenance, c.do(ctx, "getMaintenance", nil, nil, maintenance)
+}
+
+// SetMaintenance will start a maintenance window. The duration is rounded up to whole minutes.
+func (c *Client) SetMaintenance(ctx context.Context, d time.Duration) (*Maintenance, error) {
+	minutes := int64((d + time.Minute - 1) / time.Minute)
+	maintenance := &Maintenance{}
+	return maintenance, c.do(ctx, "startMaintenance", nil, &maintenanceRequest{Minutes: minutes}, maintenance)
+}
+
+// EndMaintenance will end the maintenance window.
+func (c *Client) EndMaintenance(ctx context.Context) (*Maintenance, error) {
+	maintenance := &Maintenance{}
+	return maintenance, c.do(ctx, "endMaintenance", nil, nil, maintenance)
+}
+
+// LockStatus will return the state of the run lock.
+func (c *Client) LockStatus(ctx context.Context) (*Lock, error) {
+	lock := &Lock{}
+	return lock, c.do(ctx, "getLock", nil, nil, lock)
+}
+
+// Lock will stop the chef waiter from accepting runs. The reason is shown to anyone
+// that checks the lock and can be empty.
+func (c *Client) Lock(ctx context.Context, reason string) (*Lock, error) {
+	lock := &Lock{}
+	return lock, c.do(ctx, "setLock", nil, &lockRequest{Reason: reason}, lock)
+}
+
+// Unlock will allow the chef waiter to accept runs again.
+func (c *Client) Unlock(ctx context.Context) (*Lock, error) {
+	lock := &Lock{}
+	return lock, c.do(ctx, "removeLock", nil, nil, lock)
+}
+
+// Status will return the status of the chef waiter service.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	status := &Status{}
+	return status, c.do(ctx, "getStatus", nil, nil, status)
+}
+
+// NextRun will return the time of the next periodic run.
+func (c *Client) NextRun(ctx context.Context) (*NextRun, error) {
+	next := &NextRun{}
+	return next, c.do(ctx, "getNextRun", nil, nil, next)
+}
+
+// LastRun will return the guid of the last run.
+func (c *Client) LastRun(ctx context.Context) (*LastRun, error) {
+	last := &LastRun{}
+	return last, c.do(ctx, "getLastRun", nil, nil, last)
+}
+
+// Health will return the health of the chef waiter.
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	health := &Health{}
+	return health, c.do(ctx, "healthCheck", nil, nil, health)
+}
+
+// Diagnostics will check the client.rb, client key, connection to the chef server and clock skew.
+// A failed check is not an error. Look at Passed on the result.
+func (c *Client) Diagnostics(ctx context.Context) (*Diagnostics, error) {
+	diagnostics := &Diagnostics{}
+	return diagnostics, c.do(ctx, "getDiagnostics", nil, nil, diagnostics)
+}
+
+// Node will return the node from the chef cache with the attributes that the chef waiter allows.
+// The error is an APIError with the code not_found if chef has not written the node.
+func (c *Client) Node(ctx context.Context) (*Node, error) {
+	node := &Node{}
+	return node, c.do(ctx, "getNode", nil, nil, node)
+}
+
+// FailedRun will return the error and updated resources from the last failed chef run.
+// The error is an APIError with the code not_found if no run has failed.
+func (c *Client) FailedRun(ctx context.Context) (*FailedRun, error) {
+	run := &FailedRun{}
+	return run, c.do(ctx, "getFailedRun", nil, nil, run)
+}
+
+// Live will run the liveness checks and return the result of each one.
+// If a check fails the error is an APIError with the code unhealthy and the failed checks in the details.
+func (c *Client) Live(ctx context.Context) (*Health, error) {
+	health := &Health{}
+	return health, c.doQuery(ctx, "liveCheck", nil, url.Values{"verbose": {"true"}}, nil, health)
+}
+
+// Ready will run every check and return the result of each one.
+// If a check fails the error is an APIError with the code unhealthy and the failed checks in the details.
+func (c *Client) Ready(ctx context.Context) (*Health, error) {
+	health := &Health{}
+	return health, c.doQuery(ctx, "readyCheck", nil, url.Values{"verbose": {"true"}}, nil, health)
+}
+
+// OpenAPI will return the raw OpenAPI document that the chef waiter serves.
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := c.do(ctx, "getOpenAPI", nil, nil, buf)
+	return buf.Bytes(), err
+}
+
+// Events streams changes of state from the chef waiter. If types are given only those
+// events are sent. The stream runs until ctx is cancelled or the connection drops, then
+// the channel is closed. The stream is not retried and ignores the client timeout.
+func (c *Client) Events(ctx context.Context, types ...string) (<-chan Event, error) {
+	op := operations["streamEvents"]
+	path := op.path
+	if len(types) > 0 {
+		path += "?" + url.Values{"types": {strings.Join(types, ",")}}.Encode()
+	}
+	req, err := http.NewRequest(op.method, c.baseURL.String()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	stream := make(chan Event)
+	go func() {
+		defer close(stream)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			// Every event is on a single data line, the id and event lines repeat what is in it.
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			event := Event{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				continue
+			}
+			select {
+			case stream <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// do will make the request for the operation and decode the response into out.
+// If out is an io.Writer the body is copied into it rather than decoded.
+func (c *Client) do(ctx context.Context, operationID string, pathParams map[string]string, in, out interface{}) error {
+	return c.doQuery(ctx, operationID, pathParams, nil, in, out)
+}
+
+// doQuery is do with query parameters added to the URL.
+func (c *Client) doQuery(ctx context.Context, operationID string, pathParams map[string]string, query url.Values, in, out interface{}) error {
+	op, ok := operations[operationID]
+	if !ok {
+		return fmt.Errorf("unknown operation %s", operationID)
+	}
+	path := op.path
+	for key, value := range pathParams {
+		path = strings.Replace(path, "{"+key+"}", url.PathEscape(value), 1)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var body []byte
+	contentType := "application/json"
+	if raw, ok := in.(rawBody); ok {
+		body, contentType = raw.data, raw.contentType
+	} else if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		retry, err := c.attempt(ctx, op.method, path, contentType, body, out)
+		if err == nil || !retry || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// attempt makes a single request. It returns true if the request can be retried.
+func (c *Client) attempt(ctx context.Context, method, path, contentType string, body []byte, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.baseURL.String()+path, reader)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		// Creating runs is not idempotent, we can't tell if the server got the request.
+		return method != http.MethodPost, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return retryable(method, resp.StatusCode), decodeError(resp)
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err = io.Copy(w, resp.Body)
+		return false, err
+	}
+	return false, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable decides if a status code is worth trying again.
+// The server rejected the request with 429 and 503 so they are safe for any method.
+func retryable(method string, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method != http.MethodPost
+	}
+	return false
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		envelope := &errorEnvelope{Error: apiErr}
+		json.Unmarshal(body, envelope)
+	}
+	apiErr.StatusCode = resp.StatusCode
+	return apiErr
+}
@@ -0,0 +1,375 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/webengine"
+)
+
+type fakeAppStatus struct{}
+
+func (fa *fakeAppStatus) JSONEncoded() ([]byte, error) {
+	return json.Marshal(&internalstate.AppStatus{ServiceName: "ChefWaiter", Healthy: true})
+}
+
+// newTestServer starts a chef waiter API backed by fakes.
+func newTestServer(t *testing.T) (*httptest.Server, *internalstate.StateTable) {
+	logger := logs.NewFakeLogger(false)
+	configFile, err := config.TestConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(configFile.Name())
+	cfg, err := config.New(configFile.Name(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create the config handler. Error: %s", err)
+	}
+	cheflogsworker := cheflogs.NewFakeChefLogWorker("")
+	state := internalstate.New(cfg, cheflogsworker, logger)
+	worker := chefrunner.NewFakeChefRunnerWorker(false)
+	worker.State = state
+	engine := webengine.New(state, &fakeAppStatus{}, worker, cheflogsworker, logger)
+	return httptest.NewServer(engine), state
+}
+
+// openAPIDoc is the part of the OpenAPI document that the tests need.
+type openAPIDoc struct {
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func fetchSpec(t *testing.T, c *Client) *openAPIDoc {
+	raw, err := c.OpenAPI(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to fetch the OpenAPI document. Error: %s", err)
+	}
+	doc := &openAPIDoc{}
+	if err := json.Unmarshal(raw, doc); err != nil {
+		t.Fatalf("Failed to decode the OpenAPI document. Error: %s", err)
+	}
+	return doc
+}
+
+func TestOperationsMatchSpec(t *testing.T) {
+	server, _ := newTestServer(t)
+	defer server.Close()
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := fetchSpec(t, c)
+
+	served := make(map[string]operation)
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			served[op.OperationID] = operation{method: strings.ToUpper(method), path: path}
+		}
+	}
+
+	for id, op := range operations {
+		specOp, ok := served[id]
+		if !ok {
+			t.Errorf("Client uses %s which is not in the OpenAPI document", id)
+			continue
+		}
+		if specOp != op {
+			t.Errorf("Client has %s as %s %s, the OpenAPI document has %s %s", id, op.method, op.path, specOp.method, specOp.path)
+		}
+	}
+	// The legacy routes are covered by their /v2/ replacements.
+	for id := range served {
+		if _, ok := operations[id]; !ok && !strings.HasPrefix(id, "legacy") {
+			t.Errorf("OpenAPI operation %s has no client method", id)
+		}
+	}
+}
+
+func TestTypesMatchSpec(t *testing.T) {
+	server, _ := newTestServer(t)
+	defer server.Close()
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := fetchSpec(t, c)
+
+	types := map[string]interface{}{
+		"Job":         Job{},
+		"CreatedJob":  CreatedJob{},
+		"Event":       Event{},
+		"Interval":    Interval{},
+		"Periodic":    Periodic{},
+		"Maintenance": Maintenance{},
+		"Lock":        Lock{},
+		"LastRun":     LastRun{},
+		"NextRun":     NextRun{},
+		"Health":      Health{},
+		"Status":      Status{},
+		"Diagnostics": Diagnostics{},
+		"Node":        Node{},
+		"FailedRun":   FailedRun{},
+	}
+	for schema, value := range types {
+		fields := make(map[string]bool)
+		rt := reflect.TypeOf(value)
+		jsonFields(rt, fields)
+		properties := doc.Components.Schemas[schema].Properties
+		for property := range properties {
+			if !fields[property] {
+				t.Errorf("%s is missing %s from the OpenAPI document", rt.Name(), property)
+			}
+		}
+		for field := range fields {
+			if _, ok := properties[field]; !ok {
+				t.Errorf("%s has %s which is not in the OpenAPI schema %s", rt.Name(), field, schema)
+			}
+		}
+	}
+}
+
+// jsonFields collects the json names of the fields, flattening embedded structs like encoding/json.
+func jsonFields(rt reflect.Type, fields map[string]bool) {
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Anonymous {
+			jsonFields(field.Type, fields)
+			continue
+		}
+		fields[strings.Split(field.Tag.Get("json"), ",")[0]] = true
+	}
+}
+
+func TestClient(t *testing.T) {
+	server, state := newTestServer(t)
+	defer server.Close()
+	c, err := New(server.URL, WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if lock, err := c.Lock(ctx, "deploying"); err != nil || !lock.Locked || lock.Reason != "deploying" {
+		t.Fatalf("Lock failed. Got: %v, Error: %v", lock, err)
+	}
+	if _, err := c.TriggerRun(ctx); !IsCode(err, "locked") {
+		t.Errorf("TriggerRun while locked should fail with locked. Got: %v", err)
+	}
+	if job, err := c.TriggerCustomRun(ctx, "recipe[chefwaiter::test]", true); err != nil || job.GUID == "" {
+		t.Errorf("Forced custom run failed. Got: %v, Error: %v", job, err)
+	}
+	if lock, err := c.Unlock(ctx); err != nil || lock.Locked {
+		t.Fatalf("Unlock failed. Got: %v, Error: %v", lock, err)
+	}
+
+	job, err := c.TriggerRun(ctx)
+	if err != nil {
+		t.Fatalf("TriggerRun failed. Error: %s", err)
+	}
+	if job.Status != "registered" || !job.Created {
+		t.Errorf("New job should be registered and created. Got: %+v", job)
+	}
+	if joined, err := c.TriggerRun(ctx); err != nil || joined.Created || joined.GUID != job.GUID {
+		t.Errorf("Second run should join the first. Got: %+v, Error: %v", joined, err)
+	}
+	if _, err := c.Trigger(ctx, RunRequest{Coalesce: "sometimes"}); !IsCode(err, "bad_request") {
+		t.Errorf("An unknown coalesce policy should be bad_request. Got: %v", err)
+	}
+	if _, err := c.Run(ctx, "nope"); !IsCode(err, "not_found") {
+		t.Errorf("Run for a missing guid should be not_found. Got: %v", err)
+	}
+	jobs, err := c.Runs(ctx)
+	if err != nil || len(jobs) != 2 {
+		t.Errorf("Runs should return 2 jobs. Got: %d, Error: %v", len(jobs), err)
+	}
+	tagged, err := c.Trigger(ctx, RunRequest{Priority: "emergency", RunMetadata: RunMetadata{Requester: "ci", Reason: "hotfix", Labels: map[string]string{"team": "web"}}})
+	if err != nil || tagged.Requester != "ci" || tagged.Reason != "hotfix" {
+		t.Errorf("Run metadata was not recorded. Got: %+v, Error: %v", tagged, err)
+	}
+	if jobs, err := c.RunsMatching(ctx, RunFilter{Requester: "ci", Labels: map[string]string{"team": "web"}}); err != nil || len(jobs) != 1 || jobs[0].GUID != tagged.GUID {
+		t.Errorf("RunsMatching should only return the tagged run. Got: %+v, Error: %v", jobs, err)
+	}
+	if jobs, err := c.RunsMatching(ctx, RunFilter{Type: []string{"custom", "ondemand"}, Sort: "oldest", Limit: 1}); err != nil || len(jobs) != 1 {
+		t.Errorf("RunsMatching should page the runs. Got: %+v, Error: %v", jobs, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		state.UpdateExitCode(job.GUID, 3)
+		state.UpdateStatus(job.GUID, "failed")
+	}()
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	finished, err := c.Wait(waitCtx, job.GUID, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Wait failed. Error: %s", err)
+	}
+	if finished.ExitCode != 3 {
+		t.Errorf("Wait returned exit code %d, want 3", finished.ExitCode)
+	}
+
+	if interval, err := c.SetInterval(ctx, 45); err != nil || interval.Minutes != 45 {
+		t.Errorf("SetInterval failed. Got: %v, Error: %v", interval, err)
+	}
+	if _, err := c.SetInterval(ctx, -1); !IsCode(err, "bad_request") {
+		t.Errorf("SetInterval with a negative number should be bad_request. Got: %v", err)
+	}
+	if periodic, err := c.SetPeriodic(ctx, false); err != nil || periodic.ChefRunsEnabled {
+		t.Errorf("SetPeriodic failed. Got: %v, Error: %v", periodic, err)
+	}
+	if maintenance, err := c.SetMaintenance(ctx, 90*time.Second); err != nil || !maintenance.InMaintenance {
+		t.Errorf("SetMaintenance failed. Got: %v, Error: %v", maintenance, err)
+	}
+	if maintenance, err := c.EndMaintenance(ctx); err != nil || maintenance.InMaintenance {
+		t.Errorf("EndMaintenance failed. Got: %v, Error: %v", maintenance, err)
+	}
+	if status, err := c.Status(ctx); err != nil || status.ServiceName != "ChefWaiter" {
+		t.Errorf("Status failed. Got: %v, Error: %v", status, err)
+	}
+	if health, err := c.Health(ctx); err != nil || health.State != "OK" {
+		t.Errorf("Health failed. Got: %v, Error: %v", health, err)
+	}
+	if health, err := c.Live(ctx); err != nil || health.State != "OK" {
+		t.Errorf("Live failed. Got: %v, Error: %v", health, err)
+	}
+	if health, err := c.Ready(ctx); err != nil || health.State != "OK" {
+		t.Errorf("Ready failed. Got: %v, Error: %v", health, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server, _ := newTestServer(t)
+	defer server.Close()
+	c, err := New(server.URL, WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Events(ctx, "lock_changed", "run_registered")
+	if err != nil {
+		t.Fatalf("Events failed. Error: %s", err)
+	}
+	if _, err := c.Lock(ctx, "deploying"); err != nil {
+		t.Fatal(err)
+	}
+	// Not asked for so it should not be sent.
+	if _, err := c.SetInterval(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.TriggerRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"lock_changed", "lock_changed", "run_registered"}
+	for i, eventType := range want {
+		event := <-stream
+		if event.Type != eventType {
+			t.Fatalf("Event %d has the wrong type. Got: %s, Want: %s", i, event.Type, eventType)
+		}
+		if i == 0 && !strings.Contains(string(event.Data), `"reason":"deploying"`) {
+			t.Errorf("Lock event should have the reason. Got: %s", event.Data)
+		}
+		if eventType == "run_registered" && event.GUID != job.GUID {
+			t.Errorf("Run event has the wrong guid. Got: %s, Want: %s", event.GUID, job.GUID)
+		}
+	}
+
+	cancel()
+	for range stream {
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"locked": true}`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := c.LockStatus(context.Background())
+	if err != nil {
+		t.Fatalf("Request should have worked after retries. Error: %s", err)
+	}
+	if !lock.Locked || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Expected 3 calls and a locked response. Got %d calls and %v", calls, lock)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	c, _ = New(server.URL, WithRetries(1, time.Millisecond))
+	_, err = c.LockStatus(context.Background())
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 APIError after running out of retries. Got: %v", err)
+	}
+}
+
+func TestTriggerBundleRun(t *testing.T) {
+	bundle := []byte("not really a tarball")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		query := r.URL.Query()
+		if r.URL.Path != "/v2/runs/bundle" || r.Header.Get("Content-Type") != "application/gzip" || string(body) != string(bundle) {
+			t.Errorf("The bundle should be uploaded as it is. Got: %s %s %q", r.URL.Path, r.Header.Get("Content-Type"), body)
+		}
+		if sum := sha256.Sum256(bundle); query.Get("sha256") != hex.EncodeToString(sum[:]) {
+			t.Errorf("The checksum should be sent. Got: %s", query.Get("sha256"))
+		}
+		if query.Get("run_list") != "recipe[base]" || query.Get("requester") != "ci" || query.Get("label") != "team=infra" {
+			t.Errorf("The request should be sent as query parameters. Got: %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"guid": "1234", "created": true}`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := BundleRequest{RunList: "recipe[base]", RunMetadata: RunMetadata{Requester: "ci", Labels: map[string]string{"team": "infra"}}}
+	job, err := c.TriggerBundleRun(context.Background(), bundle, req)
+	if err != nil || job.GUID != "1234" || !job.Created {
+		t.Errorf("The created job should be returned. Got: %+v, Error: %v", job, err)
+	}
+}
+
+func TestNewRejectsBadAddress(t *testing.T) {
+	if _, err := New("127.0.0.1:8901"); err == nil {
+		t.Error("An address without a scheme should be rejected")
+	}
+}
@@ -0,0 +1,424 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Job is a chef run known to the chef waiter.
+type Job struct {
+	GUID            string       `json:"guid"`
+	Status          string       `json:"status"`
+	ExitCode        int          `json:"exitcode"`
+	RegisteredTime  int64        `json:"starttime"`
+	OnDemand        bool         `json:"ondemand"`
+	CustomRun       bool         `json:"custom_run"`
+	CustomRunString string       `json:"custom_run_string"`
+	Priority        string       `json:"priority"`
+	RunStartTime    int64        `json:"run_start_time"`
+	RunEndTime      int64        `json:"run_end_time"`
+	Resumed         bool         `json:"resumed"`
+	ParentGUID      string       `json:"parent_guid"`
+	Attempt         int          `json:"attempt"`
+	Hooks           []HookResult `json:"hooks,omitempty"`
+	SkipReason      string       `json:"skip_reason,omitempty"`
+	BundleSHA256    string       `json:"bundle_sha256,omitempty"`
+	ChefVersion     string       `json:"chef_version,omitempty"`
+	Policy
+	RunMetadata
+}
+
+// Policy is the Policyfile group and name used by a custom run.
+type Policy struct {
+	PolicyGroup string `json:"policy_group,omitempty"`
+	PolicyName  string `json:"policy_name,omitempty"`
+}
+
+// HookResult is the outcome of a pre_run or post_run hook. Duration is in milliseconds.
+type HookResult struct {
+	Name     string `json:"name"`
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out"`
+	Duration int64  `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunMetadata records who requested a run and why. Labels are free form key/value pairs.
+type RunMetadata struct {
+	Requester   string            `json:"requester,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+	PipelineURL string            `json:"pipeline_url,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// RunFilter selects the runs returned by RunsMatching. Empty values match every run
+// and a run must have all of the labels. Type can hold periodic, ondemand and custom.
+// Since and Until are epoch times that the runs were registered between.
+// Sort is newest, the default, or oldest. A Limit of 0 returns every run.
+type RunFilter struct {
+	Requester   string
+	PipelineURL string
+	Labels      map[string]string
+	Status      []string
+	Type        []string
+	Since       int64
+	Until       int64
+	Sort        string
+	Limit       int
+	Offset      int
+}
+
+func (f RunFilter) query() url.Values {
+	query := url.Values{}
+	if len(f.Status) > 0 {
+		query.Set("status", strings.Join(f.Status, ","))
+	}
+	if len(f.Type) > 0 {
+		query.Set("type", strings.Join(f.Type, ","))
+	}
+	if f.Since > 0 {
+		query.Set("since", strconv.FormatInt(f.Since, 10))
+	}
+	if f.Until > 0 {
+		query.Set("until", strconv.FormatInt(f.Until, 10))
+	}
+	if f.Sort != "" {
+		query.Set("sort", f.Sort)
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Offset > 0 {
+		query.Set("offset", strconv.Itoa(f.Offset))
+	}
+	if f.Requester != "" {
+		query.Set("requester", f.Requester)
+	}
+	if f.PipelineURL != "" {
+		query.Set("pipeline_url", f.PipelineURL)
+	}
+	for key, value := range f.Labels {
+		query.Add("label", key+"="+value)
+	}
+	return query
+}
+
+// Finished will return true if the job is no longer queued or running.
+func (j *Job) Finished() bool {
+	return j.Status != "registered" && j.Status != "running"
+}
+
+// QueuedJob is a job waiting to run. Position 1 runs next.
+type QueuedJob struct {
+	GUID           string `json:"guid"`
+	Position       int    `json:"position"`
+	Priority       string `json:"priority"`
+	QueuedTime     int64  `json:"queued_time"`
+	EstimatedStart int64  `json:"estimated_start"`
+}
+
+// Interval is the time between periodic runs.
+type Interval struct {
+	CurrentInterval string `json:"current_interval"`
+	Minutes         int64  `json:"minutes"`
+}
+
+// Periodic shows if periodic runs are enabled.
+type Periodic struct {
+	ChefRunsEnabled bool `json:"chef_runs_enabled"`
+}
+
+// Maintenance describes the maintenance window.
+type Maintenance struct {
+	EndTime       string `json:"end_time"`
+	EndTimeEpoch  int64  `json:"end_time_epoch"`
+	InMaintenance bool   `json:"in_maintenance"`
+}
+
+// Lock shows if the chef waiter is locked.
+type Lock struct {
+	Locked bool   `json:"locked"`
+	Reason string `json:"reason"`
+}
+
+// LastRun holds the guid of the last run.
+type LastRun struct {
+	LastRunGUID string `json:"last_run_guid"`
+}
+
+// NextRun is the time of the next periodic run.
+type NextRun struct {
+	Epoch int64  `json:"epoch"`
+	Human string `json:"human"`
+}
+
+// Health is the health of the chef waiter. Checks is only filled in when verbose is asked for.
+type Health struct {
+	State  string        `json:"state"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of a single health check. Status is ok, warn or fail.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// Status is the status of the chef waiter service.
+type Status struct {
+	ServiceName       string   `json:"service_name"`
+	HostName          string   `json:"hostname"`
+	StartTime         int64    `json:"start_time"`
+	Uptime            int64    `json:"uptime"`
+	StartTimeHuman    string   `json:"start_time_human_readable"`
+	Version           string   `json:"version"`
+	ChefVersion       string   `json:"chef_version"`
+	Healthy           bool     `json:"healthy"`
+	InMaintenance     bool     `json:"in_maintenance_mode"`
+	LastRunGUID       string   `json:"last_run_id"`
+	RunningGUID       string   `json:"running_id"`
+	QueueDepth        int      `json:"queue_depth"`
+	NextRunTime       int64    `json:"next_run_time"`
+	Locked            bool     `json:"locked"`
+	WhiteListsEnabled bool     `json:"whitelisting_enabled"`
+	WhiteList         []string `json:"whitelisted_payloads"`
+	// AllowedPolicies are the policies that custom runs can use.
+	AllowedPolicies []Policy `json:"allowed_policies"`
+	// Preconditions are the results from the last time a job left the queue.
+	Preconditions []PreconditionResult `json:"preconditions"`
+	// Drain is the progress of a graceful stop.
+	Drain Drain `json:"drain"`
+	// The periodic runs that have failed in a row. Drifted is true when a threshold was crossed.
+	ConsecutivePeriodicFailures int   `json:"consecutive_periodic_failures"`
+	LastPeriodicSuccess         int64 `json:"last_periodic_success"`
+	PeriodicFailingSince        int64 `json:"periodic_failing_since"`
+	Drifted                     bool  `json:"drifted"`
+	// ChefVersionHistory is every change to the version of chef-client, oldest first.
+	ChefVersionHistory []ChefVersionChange `json:"chef_version_history"`
+}
+
+// ChefVersionChange is a change to the version of chef-client. From is empty for the
+// first version seen.
+type ChefVersionChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Time int64  `json:"time"`
+}
+
+// Drain is the progress of a graceful stop. State is empty until the stop starts, then
+// draining, terminating and drained. Started and Deadline are unix times.
+type Drain struct {
+	State    string `json:"state,omitempty"`
+	Started  int64  `json:"started,omitempty"`
+	Deadline int64  `json:"deadline,omitempty"`
+}
+
+// PreconditionResult is the outcome of a check that must pass before a run can start.
+type PreconditionResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// Diagnostics is the outcome of the checks on the connection to the chef server.
+// Passed is false if any of them failed.
+type Diagnostics struct {
+	Passed       bool                    `json:"passed"`
+	Time         int64                   `json:"time"`
+	Config       DiagnosticsConfig       `json:"config"`
+	ClientKey    DiagnosticsClientKey    `json:"client_key"`
+	Connectivity DiagnosticsConnectivity `json:"connectivity"`
+	ClockSkew    DiagnosticsClockSkew    `json:"clock_skew"`
+}
+
+// DiagnosticsConfig is the settings read from client.rb.
+type DiagnosticsConfig struct {
+	Path            string `json:"path"`
+	ChefServerURL   string `json:"chef_server_url"`
+	NodeName        string `json:"node_name"`
+	ClientKey       string `json:"client_key"`
+	SSLVerifyMode   string `json:"ssl_verify_mode"`
+	TrustedCertsDir string `json:"trusted_certs_dir"`
+	Passed          bool   `json:"passed"`
+	Message         string `json:"message,omitempty"`
+}
+
+// DiagnosticsClientKey describes the client key. Mode is empty if the key does not exist.
+type DiagnosticsClientKey struct {
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Mode    string `json:"mode,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// DiagnosticsConnectivity describes the connection to the chef server. Latency is in
+// milliseconds and CertificateExpiry is the unix time the server certificate expires.
+type DiagnosticsConnectivity struct {
+	Address           string `json:"address"`
+	TCP               bool   `json:"tcp"`
+	TLS               bool   `json:"tls"`
+	TLSVersion        string `json:"tls_version,omitempty"`
+	CertificateExpiry int64  `json:"certificate_expiry,omitempty"`
+	Latency           int64  `json:"latency"`
+	Passed            bool   `json:"passed"`
+	Message           string `json:"message,omitempty"`
+}
+
+// DiagnosticsClockSkew compares the clock with the chef server. Skew is in seconds and is
+// positive when the chef waiter is ahead.
+type DiagnosticsClockSkew struct {
+	ServerTime int64  `json:"server_time,omitempty"`
+	Skew       int64  `json:"skew"`
+	Passed     bool   `json:"passed"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Node is the node from the chef cache. Attributes only holds the attributes that the chef
+// waiter allows, keyed by their dotted path. Updated is the unix time chef wrote the node.
+type Node struct {
+	Name            string                 `json:"name"`
+	ChefEnvironment string                 `json:"chef_environment"`
+	RunList         []string               `json:"run_list"`
+	PolicyName      string                 `json:"policy_name,omitempty"`
+	PolicyGroup     string                 `json:"policy_group,omitempty"`
+	Attributes      map[string]interface{} `json:"attributes"`
+	Updated         int64                  `json:"updated"`
+}
+
+// FailedRun is the last failed chef run. ElapsedTime is in seconds.
+type FailedRun struct {
+	RunID            string     `json:"run_id"`
+	StartTime        string     `json:"start_time"`
+	EndTime          string     `json:"end_time"`
+	ElapsedTime      float64    `json:"elapsed_time"`
+	Exception        string     `json:"exception"`
+	Backtrace        []string   `json:"backtrace"`
+	TotalResources   int        `json:"total_resources"`
+	UpdatedResources []Resource `json:"updated_resources"`
+	Updated          int64      `json:"updated"`
+}
+
+// Resource names a resource that was updated in a chef run.
+type Resource struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Cookbook string `json:"cookbook,omitempty"`
+	Recipe   string `json:"recipe,omitempty"`
+}
+
+// CreatedJob is returned when a run is requested. Created is false if the request
+// joined a job that was already queued.
+type CreatedJob struct {
+	Job
+	Created bool `json:"created"`
+}
+
+// RunRequest describes the run to create. The zero value is an on demand run.
+// Priority can be emergency. Coalesce can be join-queued, always-new or after-timestamp=<epoch>.
+// A Policy makes a custom run that uses that policy instead of a run list.
+// The metadata is only recorded if a new job is created.
+type RunRequest struct {
+	CustomRun string `json:"custom_run,omitempty"`
+	Force     bool   `json:"force,omitempty"`
+	Priority  string `json:"priority,omitempty"`
+	Coalesce  string `json:"coalesce,omitempty"`
+	Policy
+	RunMetadata
+}
+
+// BundleRequest describes a run of an uploaded cookbook bundle. RunList is optional and
+// replaces the run list in the bundle. Priority can be emergency.
+type BundleRequest struct {
+	RunList  string
+	Priority string
+	RunMetadata
+}
+
+func (b BundleRequest) query() url.Values {
+	query := url.Values{}
+	if b.RunList != "" {
+		query.Set("run_list", b.RunList)
+	}
+	if b.Priority != "" {
+		query.Set("priority", b.Priority)
+	}
+	if b.Requester != "" {
+		query.Set("requester", b.Requester)
+	}
+	if b.Reason != "" {
+		query.Set("reason", b.Reason)
+	}
+	if b.PipelineURL != "" {
+		query.Set("pipeline_url", b.PipelineURL)
+	}
+	for key, value := range b.Labels {
+		query.Add("label", key+"="+value)
+	}
+	return query
+}
+
+// rawBody is a request body that is sent as it is rather than encoded as JSON.
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+type intervalRequest struct {
+	Minutes int64 `json:"minutes"`
+}
+
+type periodicRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type lockRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type maintenanceRequest struct {
+	Minutes int64 `json:"minutes"`
+}
+
+// Event is a change of state in the chef waiter. Data depends on the type. For run
+// events it is the Job without the guid.
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time int64           `json:"time"`
+	GUID string          `json:"guid,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// APIError is returned when the chef waiter responds with an error.
+type APIError struct {
+	StatusCode int               `json:"-"`
+	Code       string            `json:"code"`
+	Message    string            `json:"message"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("chefwaiter returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("chefwaiter returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCode will return true if err is an APIError with the supplied code.
+func IsCode(err error, code string) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == code
+}
+
+type errorEnvelope struct {
+	Error *APIError `json:"error"`
+}
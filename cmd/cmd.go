@@ -2,20 +2,46 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 const defaultFailedCode = 1
 
+// stopGracePeriod is how long a command has to exit after it is asked to stop before it is killed.
+const stopGracePeriod = 10 * time.Second
+
+// Options change how a command is started. Env is added to the environment of this
+// process and an empty Dir uses the current working directory.
+// The command is asked to stop when Context is done and killed if it is still running
+// after stopGracePeriod. A nil Context lets the command run until it finishes.
+type Options struct {
+	Env     []string
+	Dir     string
+	Context context.Context
+}
+
 // RunCommand will run the shell command with the supplied arguments
 func RunCommand(name string, args ...string) (stdout string, stderr string, exitCode int) {
+	return RunCommandWithOptions(Options{}, name, args...)
+}
+
+// RunCommandWithOptions will run the shell command with the supplied arguments, environment and working directory.
+func RunCommandWithOptions(opts Options, name string, args ...string) (stdout string, stderr string, exitCode int) {
 	var outbuf, errbuf bytes.Buffer
 	cmd := exec.Command(name, args...)
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	cmd.Dir = opts.Dir
 	cmd.Stdout = &outbuf
 	cmd.Stderr = &errbuf
 
-	err := cmd.Run()
+	err := run(opts.Context, cmd)
 	stdout = outbuf.String()
 	stderr = errbuf.String()
 
@@ -42,6 +68,64 @@ func RunCommand(name string, args ...string) (stdout string, stderr string, exit
 	return
 }
 
+// RunCommandWithTimeout will run the command with the options and write both stdout and
+// stderr to output. The command is killed if it runs for longer than timeout, in which
+// case timedOut is true. A timeout of 0 lets the command run until it finishes.
+func RunCommandWithTimeout(timeout time.Duration, opts Options, output io.Writer, name string, args ...string) (exitCode int, timedOut bool, err error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Dir = opts.Dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = run(opts.Context, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return defaultFailedCode, true, ctx.Err()
+	}
+	if opts.Context != nil && opts.Context.Err() != nil {
+		return defaultFailedCode, false, opts.Context.Err()
+	}
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return exitError.Sys().(syscall.WaitStatus).ExitStatus(), false, nil
+		}
+		return defaultFailedCode, false, err
+	}
+	return 0, false, nil
+}
+
+// run starts the command and waits for it to finish. If ctx is done first the command is
+// asked to stop so that it can clean up, then killed after stopGracePeriod.
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if ctx == nil {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+		}
+		stopProcess(cmd.Process)
+		select {
+		case <-finished:
+		case <-time.After(stopGracePeriod):
+			cmd.Process.Kill()
+		}
+	}()
+	return cmd.Wait()
+}
+
 // Chomp will remove the \n on the end of a string
 func Chomp(s string) string {
 	for {
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandWithTimeout(t *testing.T) {
+	output := &bytes.Buffer{}
+	exitCode, timedOut, err := RunCommandWithTimeout(time.Second, Options{Env: []string{"HOOK_TEST=hello"}}, output, "/bin/sh", "-c", "echo $HOOK_TEST; echo oops >&2; exit 3")
+	if exitCode != 3 || timedOut || err != nil {
+		t.Errorf("Wrong result. Exit code: %d, Timed out: %t, Error: %v", exitCode, timedOut, err)
+	}
+	if got := output.String(); !strings.Contains(got, "hello") || !strings.Contains(got, "oops") {
+		t.Errorf("Output should have stdout, stderr and the environment. Got: %q", got)
+	}
+
+	exitCode, timedOut, err = RunCommandWithTimeout(50*time.Millisecond, Options{}, output, "/bin/sleep", "5")
+	if !timedOut || exitCode == 0 || err == nil {
+		t.Errorf("Command should have timed out. Exit code: %d, Timed out: %t, Error: %v", exitCode, timedOut, err)
+	}
+
+	if _, _, err = RunCommandWithTimeout(0, Options{}, output, "/does/not/exist"); err == nil {
+		t.Error("A missing command should return an error")
+	}
+}
+
+func TestRunCommandStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	output := &bytes.Buffer{}
+	exitCode, timedOut, err := RunCommandWithTimeout(time.Minute, Options{Context: ctx}, output, "/bin/sh", "-c", "trap 'echo stopping; exit 4' TERM; sleep 5 >/dev/null 2>&1 & wait")
+	if exitCode == 0 || timedOut || err != context.Canceled {
+		t.Errorf("Command should have been stopped. Exit code: %d, Timed out: %t, Error: %v", exitCode, timedOut, err)
+	}
+	if time.Since(start) > 2*time.Second || !strings.Contains(output.String(), "stopping") {
+		t.Errorf("Command should have been asked to stop. Output: %q", output.String())
+	}
+
+	_, _, exitCode = RunCommandWithOptions(Options{Context: ctx}, "/bin/sleep", "5")
+	if exitCode == 0 {
+		t.Error("A command started after the context is done should be stopped")
+	}
+}
+
+func TestRunCommandWithOptions(t *testing.T) {
+	stdout, _, exitCode := RunCommandWithOptions(Options{Env: []string{"CHEF_TEST=hello"}, Dir: "/tmp"}, "/bin/sh", "-c", "echo $CHEF_TEST $(pwd)")
+	if exitCode != 0 || strings.TrimSpace(stdout) != "hello /tmp" {
+		t.Errorf("The environment and working directory should be used. Exit code: %d, Output: %q", exitCode, stdout)
+	}
+}
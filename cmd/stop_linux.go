@@ -0,0 +1,12 @@
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// stopProcess asks the process to stop with SIGTERM. sudo passes the signal on to the
+// command that it started.
+func stopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
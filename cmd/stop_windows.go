@@ -0,0 +1,8 @@
+package cmd
+
+import "os"
+
+// stopProcess kills the process as Windows can't ask a process to stop.
+func stopProcess(p *os.Process) error {
+	return p.Kill()
+}
@@ -24,6 +24,66 @@ type Config interface {
 	KeyPath() string
 	WhiteListCustomRuns() bool
 	AllowedCustomRuns() []string
+	AllowedPolicies() []PolicyConfig
+	LegacyGetMutators() bool
+	QueueSize() int
+	ResumeQueuedRuns() bool
+	ResumeMaxAge() int64
+	HealthMaxRunTime() int64
+	HealthMinFreeSpace() uint64
+	DriftFailedRuns() int
+	DriftMaxAge() int64
+	DriftAlertURL() string
+	RetryMaxAttempts() int
+	RetryBackoff() int64
+	RetryExitCodes() []int
+	RetryOnDemand() bool
+	PreRunHook() HookConfig
+	PostRunHook() HookConfig
+	Preconditions() PreconditionConfig
+	ChefClientBinary() string
+	ChefClientSudo() bool
+	ChefClientArgs() []string
+	ChefClientEnv() map[string]string
+	ChefClientWorkingDir() string
+	Executor() string
+	ExecutorRecipeURL() string
+	ExecutorJSONAttributes() string
+	ExecutorCommand() HookConfig
+	BundleRunsEnabled() bool
+	BundleMaxSize() int64
+	BundleLocation() string
+	ChefClientConfig() string
+	DiagnosticsTimeout() int64
+	ChefCacheLocation() string
+	NodeCacheFile() string
+	NodeAttributes() []string
+	DrainTimeout() int64
+}
+
+// HookConfig describes a script that is run before or after chef. An empty Command turns
+// the hook off. Timeout is in seconds and 0 lets the hook run until it finishes.
+// AbortOnFailure only applies to pre run hooks and stops chef running if the hook fails.
+type HookConfig struct {
+	Command        string   `json:"command"`
+	Args           []string `json:"args"`
+	Timeout        int64    `json:"timeout"`
+	AbortOnFailure bool     `json:"abort_on_failure"`
+}
+
+// PreconditionConfig describes the checks that must pass before a run can start.
+// FreeSpace maps a directory to the megabytes that must be free on its disk.
+type PreconditionConfig struct {
+	FilesExist  []string          `json:"files_exist"`
+	FilesAbsent []string          `json:"files_absent"`
+	Commands    []HookConfig      `json:"commands"`
+	FreeSpace   map[string]uint64 `json:"free_space"`
+}
+
+// PolicyConfig is a Policyfile group and name that custom runs are allowed to use.
+type PolicyConfig struct {
+	PolicyGroup string `json:"policy_group"`
+	PolicyName  string `json:"policy_name"`
 }
 
 func (vc *ValuesContainer) StateTableSize() int {
@@ -104,6 +164,216 @@ func (vc *ValuesContainer) AllowedCustomRuns() []string {
 	return vc.InternalAllowedCustomRuns
 }
 
+func (vc *ValuesContainer) AllowedPolicies() []PolicyConfig {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalAllowedPolicies
+}
+
+func (vc *ValuesContainer) LegacyGetMutators() bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalLegacyGetMutators
+}
+
+func (vc *ValuesContainer) QueueSize() int {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalQueueSize
+}
+
+func (vc *ValuesContainer) ResumeQueuedRuns() bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalResumeQueuedRuns
+}
+
+func (vc *ValuesContainer) ResumeMaxAge() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalResumeMaxAge
+}
+
+func (vc *ValuesContainer) HealthMaxRunTime() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalHealthMaxRunTime
+}
+
+func (vc *ValuesContainer) HealthMinFreeSpace() uint64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalHealthMinFreeSpace
+}
+
+func (vc *ValuesContainer) DriftFailedRuns() int {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalDriftFailedRuns
+}
+
+func (vc *ValuesContainer) DriftMaxAge() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalDriftMaxAge
+}
+
+func (vc *ValuesContainer) DriftAlertURL() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalDriftAlertURL
+}
+
+func (vc *ValuesContainer) RetryMaxAttempts() int {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalRetryMaxAttempts
+}
+
+func (vc *ValuesContainer) RetryBackoff() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalRetryBackoff
+}
+
+func (vc *ValuesContainer) RetryExitCodes() []int {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalRetryExitCodes
+}
+
+func (vc *ValuesContainer) RetryOnDemand() bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalRetryOnDemand
+}
+
+func (vc *ValuesContainer) PreRunHook() HookConfig {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalPreRunHook
+}
+
+func (vc *ValuesContainer) PostRunHook() HookConfig {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalPostRunHook
+}
+
+func (vc *ValuesContainer) Preconditions() PreconditionConfig {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalPreconditions
+}
+
+func (vc *ValuesContainer) ChefClientBinary() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientBinary
+}
+
+func (vc *ValuesContainer) ChefClientSudo() bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientSudo
+}
+
+func (vc *ValuesContainer) ChefClientArgs() []string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientArgs
+}
+
+func (vc *ValuesContainer) ChefClientEnv() map[string]string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientEnv
+}
+
+func (vc *ValuesContainer) ChefClientWorkingDir() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientWorkingDir
+}
+
+func (vc *ValuesContainer) Executor() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalExecutor
+}
+
+func (vc *ValuesContainer) ExecutorRecipeURL() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalExecutorRecipeURL
+}
+
+func (vc *ValuesContainer) ExecutorJSONAttributes() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalExecutorJSONAttributes
+}
+
+func (vc *ValuesContainer) ExecutorCommand() HookConfig {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalExecutorCommand
+}
+
+func (vc *ValuesContainer) BundleRunsEnabled() bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalBundleRunsEnabled
+}
+
+func (vc *ValuesContainer) BundleMaxSize() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalBundleMaxSize
+}
+
+func (vc *ValuesContainer) BundleLocation() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalBundleLocation
+}
+
+func (vc *ValuesContainer) ChefClientConfig() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefClientConfig
+}
+
+func (vc *ValuesContainer) DiagnosticsTimeout() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalDiagnosticsTimeout
+}
+
+func (vc *ValuesContainer) ChefCacheLocation() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalChefCacheLocation
+}
+
+func (vc *ValuesContainer) NodeCacheFile() string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalNodeCacheFile
+}
+
+func (vc *ValuesContainer) NodeAttributes() []string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalNodeAttributes
+}
+
+func (vc *ValuesContainer) DrainTimeout() int64 {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.InternalDrainTimeout
+}
+
 // ValuesContainer is a struct that holds the values of the configuration file.
 type ValuesContainer struct {
 	InternalStateTableSize      int               `json:"state_table_size"`
@@ -122,6 +392,52 @@ type ValuesContainer struct {
 	MetricsDefaultTags          map[string]string `json:"metrics_default_tags"`
 	InternalWhiteListCustomRuns bool              `json:"whitelist_custom_runs"`
 	InternalAllowedCustomRuns   []string          `json:"allowed_custom_runs"`
+	InternalAllowedPolicies     []PolicyConfig    `json:"allowed_policies"`
+	InternalLegacyGetMutators   bool              `json:"legacy_get_mutators"`
+	InternalQueueSize           int               `json:"queue_size"`
+	InternalResumeQueuedRuns    bool              `json:"resume_queued_runs"`
+	InternalResumeMaxAge        int64             `json:"resume_max_age"`
+	// Health check settings. 0 turns the check off.
+	InternalHealthMaxRunTime   int64  `json:"health_max_run_time"`
+	InternalHealthMinFreeSpace uint64 `json:"health_min_free_space"`
+	// Drift thresholds for the periodic runs. 0 turns the threshold off.
+	InternalDriftFailedRuns int    `json:"drift_failed_runs"`
+	InternalDriftMaxAge     int64  `json:"drift_max_age"`
+	InternalDriftAlertURL   string `json:"drift_alert_url"`
+	// Retry policy for failed runs. A max attempts of 1 turns retries off.
+	InternalRetryMaxAttempts int   `json:"retry_max_attempts"`
+	InternalRetryBackoff     int64 `json:"retry_backoff"`
+	InternalRetryExitCodes   []int `json:"retry_exit_codes"`
+	InternalRetryOnDemand    bool  `json:"retry_on_demand"`
+	// Scripts that run around each chef run.
+	InternalPreRunHook  HookConfig `json:"pre_run_hook"`
+	InternalPostRunHook HookConfig `json:"post_run_hook"`
+	// Checks that must pass before a run can start.
+	InternalPreconditions PreconditionConfig `json:"preconditions"`
+	// How chef-client is started. The defaults depend on the OS.
+	InternalChefClientBinary     string            `json:"chef_client_binary"`
+	InternalChefClientSudo       bool              `json:"chef_client_sudo"`
+	InternalChefClientArgs       []string          `json:"chef_client_args"`
+	InternalChefClientEnv        map[string]string `json:"chef_client_env"`
+	InternalChefClientWorkingDir string            `json:"chef_client_working_dir"`
+	// What runs the jobs. chef-client, chef-solo, local-mode or command.
+	InternalExecutor               string     `json:"executor"`
+	InternalExecutorRecipeURL      string     `json:"executor_recipe_url"`
+	InternalExecutorJSONAttributes string     `json:"executor_json_attributes"`
+	InternalExecutorCommand        HookConfig `json:"executor_command"`
+	// Uploaded cookbook bundles that run in local mode. The max size is in MB.
+	InternalBundleRunsEnabled bool   `json:"bundle_runs_enabled"`
+	InternalBundleMaxSize     int64  `json:"bundle_max_size"`
+	InternalBundleLocation    string `json:"bundle_location"`
+	// The client.rb read by the diagnostics endpoint. The timeout is in seconds.
+	InternalChefClientConfig   string `json:"chef_client_config"`
+	InternalDiagnosticsTimeout int64  `json:"diagnostics_timeout"`
+	// What is read from the chef cache. Only the node attributes listed are served.
+	InternalChefCacheLocation string   `json:"chef_cache_location"`
+	InternalNodeCacheFile     string   `json:"node_cache_file"`
+	InternalNodeAttributes    []string `json:"node_attributes"`
+	// How long in seconds the running job has to finish when the service stops. 0 turns it off.
+	InternalDrainTimeout int64 `json:"drain_timeout"`
 	sync.RWMutex
 }
 
@@ -140,6 +456,23 @@ func New(fileLocation string, logger logs.SysLogger) (*ValuesContainer, error) {
 		InternalKeyPath:        "./key.key",
 		MetricsHost:            "127.0.0.1:8125",
 		MetricsDefaultTags:     make(map[string]string),
+		// Legacy GET endpoints that change state stay on until users have moved to /v2/.
+		InternalLegacyGetMutators:  true,
+		InternalQueueSize:          20,
+		InternalResumeMaxAge:       60,
+		InternalHealthMaxRunTime:   120,
+		InternalHealthMinFreeSpace: 100,
+		InternalDriftFailedRuns:    3,
+		InternalDriftMaxAge:        48,
+		InternalRetryMaxAttempts:   1,
+		InternalRetryBackoff:       60,
+		InternalPreRunHook:         HookConfig{Timeout: 300},
+		InternalPostRunHook:        HookConfig{Timeout: 300},
+		InternalExecutor:           "chef-client",
+		InternalBundleMaxSize:      100,
+		InternalDiagnosticsTimeout: 5,
+		InternalNodeCacheFile:      "node.json",
+		InternalNodeAttributes:     []string{},
 	}
 	// Call OS_default for config files
 	nc.writeConfigFileOSDefaults()
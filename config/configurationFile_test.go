@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -21,19 +22,65 @@ func generateFileContent() []*ValuesContainer {
 			InternalCertPath:          "./cert.pem",
 			InternalKeyPath:           "./key.key",
 			InternalTLSEnabled:        false,
+			InternalQueueSize:         5,
 		},
 		&ValuesContainer{
-			InternalStateTableSize:    100000,
-			InternalControlChefRun:    true,
-			InternalPeriodicTimer:     600324234,
-			InternalDebug:             false,
-			InternalLogLocation:       "ajfhoaskfmlkasmdoaijmcapsjpaowsmcpam",
-			InternalStateFileLocation: "carasdasdsadsadasdwewsarot",
-			InternalListenPort:        14521452145214,
-			InternalListenAddress:     "0.0.0.0",
-			InternalCertPath:          "./cert.pem",
-			InternalKeyPath:           "./key.key",
-			InternalTLSEnabled:        true,
+			InternalStateTableSize:     100000,
+			InternalControlChefRun:     true,
+			InternalPeriodicTimer:      600324234,
+			InternalDebug:              false,
+			InternalLogLocation:        "ajfhoaskfmlkasmdoaijmcapsjpaowsmcpam",
+			InternalStateFileLocation:  "carasdasdsadsadasdwewsarot",
+			InternalListenPort:         14521452145214,
+			InternalListenAddress:      "0.0.0.0",
+			InternalCertPath:           "./cert.pem",
+			InternalKeyPath:            "./key.key",
+			InternalTLSEnabled:         true,
+			InternalLegacyGetMutators:  true,
+			InternalQueueSize:          50,
+			InternalResumeQueuedRuns:   true,
+			InternalResumeMaxAge:       15,
+			InternalHealthMaxRunTime:   30,
+			InternalDriftFailedRuns:    5,
+			InternalDriftMaxAge:        12,
+			InternalDriftAlertURL:      "https://alerts.example.com/hook",
+			InternalRetryMaxAttempts:   3,
+			InternalRetryBackoff:       30,
+			InternalRetryExitCodes:     []int{1, 35},
+			InternalRetryOnDemand:      true,
+			InternalHealthMinFreeSpace: 2048,
+			InternalPreRunHook: HookConfig{
+				Command:        "/usr/local/bin/drain",
+				Args:           []string{"--wait", "30"},
+				Timeout:        60,
+				AbortOnFailure: true,
+			},
+			InternalPostRunHook: HookConfig{Command: "/usr/local/bin/undrain"},
+			InternalPreconditions: PreconditionConfig{
+				FilesExist:  []string{"/etc/chef/client.pem"},
+				FilesAbsent: []string{"/etc/chef/do_not_converge"},
+				Commands:    []HookConfig{{Command: "/usr/bin/flock", Args: []string{"-n", "/var/lib/dpkg/lock", "true"}, Timeout: 10}},
+				FreeSpace:   map[string]uint64{"/var": 500},
+			},
+			InternalChefClientBinary:       "/opt/cinc/bin/cinc-client",
+			InternalChefClientSudo:         false,
+			InternalChefClientArgs:         []string{"--config", "/etc/chef/client-prod.rb", "-l", "info"},
+			InternalChefClientEnv:          map[string]string{"https_proxy": "http://proxy:3128"},
+			InternalChefClientWorkingDir:   "/opt/cinc",
+			InternalExecutor:               "chef-solo",
+			InternalExecutorRecipeURL:      "https://artifacts.example.com/cookbooks.tgz",
+			InternalExecutorJSONAttributes: "/etc/chef/node.json",
+			InternalExecutorCommand:        HookConfig{Command: "/usr/local/bin/converge", Timeout: 3600},
+			InternalBundleRunsEnabled:      true,
+			InternalBundleMaxSize:          250,
+			InternalBundleLocation:         "/srv/chefwaiter/bundles",
+			InternalAllowedPolicies:        []PolicyConfig{{PolicyGroup: "canary", PolicyName: "webserver"}},
+			InternalChefClientConfig:       "/etc/chef/client-prod.rb",
+			InternalDiagnosticsTimeout:     10,
+			InternalChefCacheLocation:      "/var/cache/chef",
+			InternalNodeCacheFile:          "nodes/web01.json",
+			InternalNodeAttributes:         []string{"platform", "nginx.port"},
+			InternalDrainTimeout:           600,
 		},
 	}
 }
@@ -91,6 +138,111 @@ func TestReadConfigFile(t *testing.T) {
 		if values.KeyPath() != fileContents.InternalKeyPath {
 			t.Errorf("InternalKeyPath is incorrect. Wanted: %v, Got: %v", fileContents.InternalKeyPath, values.KeyPath())
 		}
+		if values.LegacyGetMutators() != fileContents.InternalLegacyGetMutators {
+			t.Errorf("InternalLegacyGetMutators is incorrect. Wanted: %v, Got: %v", fileContents.InternalLegacyGetMutators, values.LegacyGetMutators())
+		}
+		if values.QueueSize() != fileContents.InternalQueueSize {
+			t.Errorf("InternalQueueSize is incorrect. Wanted: %v, Got: %v", fileContents.InternalQueueSize, values.QueueSize())
+		}
+		if values.ResumeQueuedRuns() != fileContents.InternalResumeQueuedRuns {
+			t.Errorf("InternalResumeQueuedRuns is incorrect. Wanted: %v, Got: %v", fileContents.InternalResumeQueuedRuns, values.ResumeQueuedRuns())
+		}
+		if values.ResumeMaxAge() != fileContents.InternalResumeMaxAge {
+			t.Errorf("InternalResumeMaxAge is incorrect. Wanted: %v, Got: %v", fileContents.InternalResumeMaxAge, values.ResumeMaxAge())
+		}
+		if values.HealthMaxRunTime() != fileContents.InternalHealthMaxRunTime {
+			t.Errorf("InternalHealthMaxRunTime is incorrect. Wanted: %v, Got: %v", fileContents.InternalHealthMaxRunTime, values.HealthMaxRunTime())
+		}
+		if values.HealthMinFreeSpace() != fileContents.InternalHealthMinFreeSpace {
+			t.Errorf("InternalHealthMinFreeSpace is incorrect. Wanted: %v, Got: %v", fileContents.InternalHealthMinFreeSpace, values.HealthMinFreeSpace())
+		}
+		if values.DriftFailedRuns() != fileContents.InternalDriftFailedRuns {
+			t.Errorf("InternalDriftFailedRuns is incorrect. Wanted: %v, Got: %v", fileContents.InternalDriftFailedRuns, values.DriftFailedRuns())
+		}
+		if values.DriftMaxAge() != fileContents.InternalDriftMaxAge {
+			t.Errorf("InternalDriftMaxAge is incorrect. Wanted: %v, Got: %v", fileContents.InternalDriftMaxAge, values.DriftMaxAge())
+		}
+		if values.DriftAlertURL() != fileContents.InternalDriftAlertURL {
+			t.Errorf("InternalDriftAlertURL is incorrect. Wanted: %v, Got: %v", fileContents.InternalDriftAlertURL, values.DriftAlertURL())
+		}
+		if values.RetryMaxAttempts() != fileContents.InternalRetryMaxAttempts {
+			t.Errorf("InternalRetryMaxAttempts is incorrect. Wanted: %v, Got: %v", fileContents.InternalRetryMaxAttempts, values.RetryMaxAttempts())
+		}
+		if values.RetryBackoff() != fileContents.InternalRetryBackoff {
+			t.Errorf("InternalRetryBackoff is incorrect. Wanted: %v, Got: %v", fileContents.InternalRetryBackoff, values.RetryBackoff())
+		}
+		if fmt.Sprint(values.RetryExitCodes()) != fmt.Sprint(fileContents.InternalRetryExitCodes) {
+			t.Errorf("InternalRetryExitCodes is incorrect. Wanted: %v, Got: %v", fileContents.InternalRetryExitCodes, values.RetryExitCodes())
+		}
+		if values.RetryOnDemand() != fileContents.InternalRetryOnDemand {
+			t.Errorf("InternalRetryOnDemand is incorrect. Wanted: %v, Got: %v", fileContents.InternalRetryOnDemand, values.RetryOnDemand())
+		}
+		if fmt.Sprint(values.PreRunHook()) != fmt.Sprint(fileContents.InternalPreRunHook) {
+			t.Errorf("InternalPreRunHook is incorrect. Wanted: %v, Got: %v", fileContents.InternalPreRunHook, values.PreRunHook())
+		}
+		if fmt.Sprint(values.PostRunHook()) != fmt.Sprint(fileContents.InternalPostRunHook) {
+			t.Errorf("InternalPostRunHook is incorrect. Wanted: %v, Got: %v", fileContents.InternalPostRunHook, values.PostRunHook())
+		}
+		if fmt.Sprint(values.Preconditions()) != fmt.Sprint(fileContents.InternalPreconditions) {
+			t.Errorf("InternalPreconditions is incorrect. Wanted: %v, Got: %v", fileContents.InternalPreconditions, values.Preconditions())
+		}
+		if values.ChefClientBinary() != fileContents.InternalChefClientBinary {
+			t.Errorf("InternalChefClientBinary is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientBinary, values.ChefClientBinary())
+		}
+		if values.ChefClientSudo() != fileContents.InternalChefClientSudo {
+			t.Errorf("InternalChefClientSudo is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientSudo, values.ChefClientSudo())
+		}
+		if fmt.Sprint(values.ChefClientArgs()) != fmt.Sprint(fileContents.InternalChefClientArgs) {
+			t.Errorf("InternalChefClientArgs is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientArgs, values.ChefClientArgs())
+		}
+		if fmt.Sprint(values.ChefClientEnv()) != fmt.Sprint(fileContents.InternalChefClientEnv) {
+			t.Errorf("InternalChefClientEnv is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientEnv, values.ChefClientEnv())
+		}
+		if values.ChefClientWorkingDir() != fileContents.InternalChefClientWorkingDir {
+			t.Errorf("InternalChefClientWorkingDir is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientWorkingDir, values.ChefClientWorkingDir())
+		}
+		if values.Executor() != fileContents.InternalExecutor {
+			t.Errorf("InternalExecutor is incorrect. Wanted: %v, Got: %v", fileContents.InternalExecutor, values.Executor())
+		}
+		if values.ExecutorRecipeURL() != fileContents.InternalExecutorRecipeURL {
+			t.Errorf("InternalExecutorRecipeURL is incorrect. Wanted: %v, Got: %v", fileContents.InternalExecutorRecipeURL, values.ExecutorRecipeURL())
+		}
+		if values.ExecutorJSONAttributes() != fileContents.InternalExecutorJSONAttributes {
+			t.Errorf("InternalExecutorJSONAttributes is incorrect. Wanted: %v, Got: %v", fileContents.InternalExecutorJSONAttributes, values.ExecutorJSONAttributes())
+		}
+		if fmt.Sprint(values.ExecutorCommand()) != fmt.Sprint(fileContents.InternalExecutorCommand) {
+			t.Errorf("InternalExecutorCommand is incorrect. Wanted: %v, Got: %v", fileContents.InternalExecutorCommand, values.ExecutorCommand())
+		}
+		if values.BundleRunsEnabled() != fileContents.InternalBundleRunsEnabled {
+			t.Errorf("InternalBundleRunsEnabled is incorrect. Wanted: %v, Got: %v", fileContents.InternalBundleRunsEnabled, values.BundleRunsEnabled())
+		}
+		if values.BundleMaxSize() != fileContents.InternalBundleMaxSize {
+			t.Errorf("InternalBundleMaxSize is incorrect. Wanted: %v, Got: %v", fileContents.InternalBundleMaxSize, values.BundleMaxSize())
+		}
+		if fmt.Sprint(values.AllowedPolicies()) != fmt.Sprint(fileContents.InternalAllowedPolicies) {
+			t.Errorf("InternalAllowedPolicies is incorrect. Wanted: %v, Got: %v", fileContents.InternalAllowedPolicies, values.AllowedPolicies())
+		}
+		if values.BundleLocation() != fileContents.InternalBundleLocation {
+			t.Errorf("InternalBundleLocation is incorrect. Wanted: %v, Got: %v", fileContents.InternalBundleLocation, values.BundleLocation())
+		}
+		if values.ChefClientConfig() != fileContents.InternalChefClientConfig {
+			t.Errorf("InternalChefClientConfig is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefClientConfig, values.ChefClientConfig())
+		}
+		if values.DiagnosticsTimeout() != fileContents.InternalDiagnosticsTimeout {
+			t.Errorf("InternalDiagnosticsTimeout is incorrect. Wanted: %v, Got: %v", fileContents.InternalDiagnosticsTimeout, values.DiagnosticsTimeout())
+		}
+		if values.ChefCacheLocation() != fileContents.InternalChefCacheLocation {
+			t.Errorf("InternalChefCacheLocation is incorrect. Wanted: %v, Got: %v", fileContents.InternalChefCacheLocation, values.ChefCacheLocation())
+		}
+		if values.NodeCacheFile() != fileContents.InternalNodeCacheFile {
+			t.Errorf("InternalNodeCacheFile is incorrect. Wanted: %v, Got: %v", fileContents.InternalNodeCacheFile, values.NodeCacheFile())
+		}
+		if fmt.Sprint(values.NodeAttributes()) != fmt.Sprint(fileContents.InternalNodeAttributes) {
+			t.Errorf("InternalNodeAttributes is incorrect. Wanted: %v, Got: %v", fileContents.InternalNodeAttributes, values.NodeAttributes())
+		}
+		if values.DrainTimeout() != fileContents.InternalDrainTimeout {
+			t.Errorf("InternalDrainTimeout is incorrect. Wanted: %v, Got: %v", fileContents.InternalDrainTimeout, values.DrainTimeout())
+		}
 
 		err = os.Remove(f.Name())
 		if err != nil {
@@ -5,4 +5,8 @@ var defaultFileLocation = "C:\\Program Files\\chefwaiter\\config.json"
 func (vc *ValuesContainer) writeConfigFileOSDefaults() {
 	vc.InternalLogLocation = "c:\\logs\\chefwaiter"
 	vc.InternalStateFileLocation = "C:\\Program Files\\chefwaiter"
+	vc.InternalChefClientBinary = "chef-client"
+	vc.InternalBundleLocation = "C:\\Program Files\\chefwaiter\\bundles"
+	vc.InternalChefClientConfig = "C:\\chef\\client.rb"
+	vc.InternalChefCacheLocation = "C:\\chef\\cache"
 }
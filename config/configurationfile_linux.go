@@ -5,4 +5,9 @@ var defaultFileLocation = "/etc/chefwaiter/config.json"
 func (vc *ValuesContainer) writeConfigFileOSDefaults() {
 	vc.InternalLogLocation = "/var/log/chefwaiter"
 	vc.InternalStateFileLocation = "/etc/chefwaiter"
+	vc.InternalChefClientBinary = "/usr/bin/chef-client"
+	vc.InternalChefClientSudo = true
+	vc.InternalBundleLocation = "/var/lib/chefwaiter/bundles"
+	vc.InternalChefClientConfig = "/etc/chef/client.rb"
+	vc.InternalChefCacheLocation = "/var/chef/cache"
 }
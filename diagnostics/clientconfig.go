@@ -0,0 +1,61 @@
+package diagnostics
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// settingRegex matches the settings in client.rb that are set to a plain string or symbol,
+// eg: chef_server_url "https://chef.example.com" or ssl_verify_mode :verify_none.
+// Settings that are worked out with Ruby are not understood.
+var settingRegex = regexp.MustCompile(`^\s*([a-z_]+)\s*\(?\s*(?:"([^"]*)"|'([^']*)'|:([a-z_]+))`)
+
+// ClientConfig is the part of the chef-client config that is needed to talk to the chef server.
+type ClientConfig struct {
+	Path            string `json:"path"`
+	ChefServerURL   string `json:"chef_server_url"`
+	NodeName        string `json:"node_name"`
+	ClientKey       string `json:"client_key"`
+	SSLVerifyMode   string `json:"ssl_verify_mode"`
+	TrustedCertsDir string `json:"trusted_certs_dir"`
+}
+
+// ReadClientConfig reads the settings from a client.rb. client_key and trusted_certs_dir
+// default to the same places as chef-client, next to the config file.
+func ReadClientConfig(path string) (ClientConfig, error) {
+	config := ClientConfig{
+		Path:            path,
+		ClientKey:       filepath.Join(filepath.Dir(path), "client.pem"),
+		SSLVerifyMode:   "verify_peer",
+		TrustedCertsDir: filepath.Join(filepath.Dir(path), "trusted_certs"),
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := settingRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		value := match[2] + match[3] + match[4]
+		switch match[1] {
+		case "chef_server_url":
+			config.ChefServerURL = value
+		case "node_name":
+			config.NodeName = value
+		case "client_key":
+			config.ClientKey = value
+		case "ssl_verify_mode":
+			config.SSLVerifyMode = value
+		case "trusted_certs_dir":
+			config.TrustedCertsDir = value
+		}
+	}
+	return config, scanner.Err()
+}
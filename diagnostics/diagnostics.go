@@ -0,0 +1,264 @@
+// Package diagnostics checks that chef-client can talk to its chef server. It reads the
+// local client.rb, looks at the client key and then checks that the chef server can be
+// reached over TCP and TLS and that the clock agrees with the server. These are the usual
+// causes of runs that fail with 401s or time outs.
+package diagnostics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxClockSkew is how far the clock can be from the chef server before requests are refused.
+// The chef server refuses signed requests that are more than 15 minutes out.
+const MaxClockSkew = 15 * time.Minute
+
+// Report is the outcome of the diagnostics. Passed is false if any of the checks failed.
+type Report struct {
+	Passed       bool               `json:"passed"`
+	Time         int64              `json:"time"`
+	Config       ConfigResult       `json:"config"`
+	ClientKey    ClientKeyResult    `json:"client_key"`
+	Connectivity ConnectivityResult `json:"connectivity"`
+	ClockSkew    ClockSkewResult    `json:"clock_skew"`
+}
+
+// ConfigResult is the settings read from client.rb.
+type ConfigResult struct {
+	ClientConfig
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// ClientKeyResult describes the client key. Mode is empty if the key does not exist.
+type ClientKeyResult struct {
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Mode    string `json:"mode,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// ConnectivityResult describes the connection to the chef server. Latency is in milliseconds
+// and CertificateExpiry is the unix time that the server certificate expires.
+type ConnectivityResult struct {
+	Address           string `json:"address"`
+	TCP               bool   `json:"tcp"`
+	TLS               bool   `json:"tls"`
+	TLSVersion        string `json:"tls_version,omitempty"`
+	CertificateExpiry int64  `json:"certificate_expiry,omitempty"`
+	Latency           int64  `json:"latency"`
+	Passed            bool   `json:"passed"`
+	Message           string `json:"message,omitempty"`
+}
+
+// ClockSkewResult compares the local clock with the Date header from the chef server.
+// Skew is in seconds and is positive when the local clock is ahead.
+type ClockSkewResult struct {
+	ServerTime int64  `json:"server_time,omitempty"`
+	Skew       int64  `json:"skew"`
+	Passed     bool   `json:"passed"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Diagnoser runs the diagnostics for the client.rb at ConfigPath. Timeout limits each of
+// the network checks.
+type Diagnoser struct {
+	ConfigPath string
+	Timeout    time.Duration
+}
+
+// Run runs every check. The network checks are skipped if client.rb can't be read or
+// does not have a chef_server_url.
+func (d *Diagnoser) Run() Report {
+	report := Report{Time: time.Now().Unix()}
+	config, err := ReadClientConfig(d.ConfigPath)
+	report.Config = ConfigResult{ClientConfig: config, Passed: err == nil}
+	switch {
+	case err != nil:
+		report.Config.Message = fmt.Sprintf("Failed to read %s. Error: %s", d.ConfigPath, err)
+	case config.ChefServerURL == "":
+		report.Config.Passed = false
+		report.Config.Message = "chef_server_url is not set"
+	case config.NodeName == "":
+		report.Config.Message = "node_name is not set. chef-client will use the FQDN"
+	}
+	report.ClientKey = checkClientKey(config.ClientKey)
+
+	if report.Config.Passed {
+		report.Connectivity = checkConnectivity(config, d.Timeout)
+		report.ClockSkew = checkClockSkew(config, d.Timeout, time.Now)
+	} else {
+		report.Connectivity.Message = "Skipped because the chef server is not known"
+		report.ClockSkew.Message = "Skipped because the chef server is not known"
+	}
+	report.Passed = report.Config.Passed && report.ClientKey.Passed && report.Connectivity.Passed && report.ClockSkew.Passed
+	return report
+}
+
+func checkClientKey(path string) ClientKeyResult {
+	result := ClientKeyResult{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Message = fmt.Sprintf("Failed to read the client key. Error: %s", err)
+		return result
+	}
+	result.Exists = true
+	result.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	if info.IsDir() {
+		result.Message = fmt.Sprintf("%s is a directory", path)
+		return result
+	}
+	if err := keyPermissions(info.Mode()); err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// serverAddress returns the host:port for the chef server url.
+func serverAddress(serverURL *url.URL) string {
+	if serverURL.Port() != "" {
+		return serverURL.Host
+	}
+	if serverURL.Scheme == "http" {
+		return net.JoinHostPort(serverURL.Hostname(), "80")
+	}
+	return net.JoinHostPort(serverURL.Hostname(), "443")
+}
+
+func checkConnectivity(config ClientConfig, timeout time.Duration) ConnectivityResult {
+	result := ConnectivityResult{}
+	serverURL, err := url.Parse(config.ChefServerURL)
+	if err != nil || serverURL.Hostname() == "" {
+		result.Message = fmt.Sprintf("%q is not a valid url", config.ChefServerURL)
+		return result
+	}
+	result.Address = serverAddress(serverURL)
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", result.Address, timeout)
+	if err != nil {
+		result.Message = fmt.Sprintf("Failed to connect to %s. Error: %s", result.Address, err)
+		return result
+	}
+	defer conn.Close()
+	result.TCP = true
+	result.Latency = int64(time.Since(start) / time.Millisecond)
+	if serverURL.Scheme != "https" {
+		result.Passed = true
+		result.Message = "The chef server does not use TLS"
+		return result
+	}
+
+	tlsConfig, err := clientTLSConfig(config)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	tlsConfig.ServerName = serverURL.Hostname()
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		result.Message = fmt.Sprintf("TLS handshake with %s failed. Error: %s", result.Address, err)
+		return result
+	}
+	result.TLS = true
+	state := tlsConn.ConnectionState()
+	result.TLSVersion = tlsVersion(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		result.CertificateExpiry = state.PeerCertificates[0].NotAfter.Unix()
+	}
+	result.Passed = true
+	return result
+}
+
+// clientTLSConfig trusts the system certificates and the ones in trusted_certs_dir,
+// the same as chef-client. verify_none turns off the checks.
+func clientTLSConfig(config ClientConfig) (*tls.Config, error) {
+	if config.SSLVerifyMode == "verify_none" {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	files, err := ioutil.ReadDir(config.TrustedCertsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read %s. Error: %s", config.TrustedCertsDir, err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		pem, err := ioutil.ReadFile(filepath.Join(config.TrustedCertsDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s. Error: %s", file.Name(), err)
+		}
+		roots.AppendCertsFromPEM(pem)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// checkClockSkew reads the Date header of the chef server. Any response will do so the
+// request is not signed.
+func checkClockSkew(config ClientConfig, timeout time.Duration, now func() time.Time) ClockSkewResult {
+	result := ClockSkewResult{}
+	tlsConfig, err := clientTLSConfig(config)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+	response, err := client.Get(config.ChefServerURL)
+	if err != nil {
+		result.Message = fmt.Sprintf("Failed to read the time from the chef server. Error: %s", err)
+		return result
+	}
+	response.Body.Close()
+	localTime := now()
+	serverTime, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		result.Message = "The chef server did not send a valid Date header"
+		return result
+	}
+	result.ServerTime = serverTime.Unix()
+	skew := localTime.Sub(serverTime).Round(time.Second)
+	result.Skew = int64(skew / time.Second)
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		result.Message = fmt.Sprintf("The clock is %s out from the chef server. The limit is %s", skew, MaxClockSkew)
+		return result
+	}
+	result.Passed = true
+	return result
+}
@@ -0,0 +1,168 @@
+package diagnostics
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// stubChefServer starts a TLS server that answers with the given Date header and writes its
+// certificate to the trusted_certs directory in dir.
+func stubChefServer(t *testing.T, dir string, date time.Time) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", date.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	certs := filepath.Join(dir, "trusted_certs")
+	if err := os.MkdirAll(certs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(certs, "stub.crt"), cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func writeClientConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "client.rb")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "client.pem"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReadClientConfig(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeClientConfig(t, dir, `# Managed by chef
+log_location     STDOUT
+chef_server_url  "https://chef.example.com/organizations/test"
+node_name        'web01.example.com'
+ssl_verify_mode  :verify_none
+validation_key   File.join(__dir__, "validator.pem")
+`)
+
+	config, err := ReadClientConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ClientConfig{
+		Path:            path,
+		ChefServerURL:   "https://chef.example.com/organizations/test",
+		NodeName:        "web01.example.com",
+		ClientKey:       filepath.Join(dir, "client.pem"),
+		SSLVerifyMode:   "verify_none",
+		TrustedCertsDir: filepath.Join(dir, "trusted_certs"),
+	}
+	if config != expected {
+		t.Errorf("Got %+v, expected %+v", config, expected)
+	}
+}
+
+func TestRunAgainstStubServer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := stubChefServer(t, dir, time.Now())
+	defer server.Close()
+	diagnoser := &Diagnoser{
+		ConfigPath: writeClientConfig(t, dir, fmt.Sprintf("chef_server_url %q\nnode_name \"web01\"\n", server.URL)),
+		Timeout:    5 * time.Second,
+	}
+
+	report := diagnoser.Run()
+	if !report.Passed {
+		t.Fatalf("The diagnostics should pass. Got: %+v", report)
+	}
+	if !report.Connectivity.TCP || !report.Connectivity.TLS || report.Connectivity.TLSVersion == "" || report.Connectivity.CertificateExpiry == 0 {
+		t.Errorf("The connection should be described. Got: %+v", report.Connectivity)
+	}
+	if report.ClockSkew.Skew < -2 || report.ClockSkew.Skew > 2 {
+		t.Errorf("There should be no clock skew. Got: %d", report.ClockSkew.Skew)
+	}
+	if report.ClientKey.Mode != "0600" && runtime.GOOS != "windows" {
+		t.Errorf("The key mode should be reported. Got: %s", report.ClientKey.Mode)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := stubChefServer(t, dir, time.Now().Add(-time.Hour))
+	defer server.Close()
+	diagnoser := &Diagnoser{
+		ConfigPath: writeClientConfig(t, dir, fmt.Sprintf("chef_server_url %q\n", server.URL)),
+		Timeout:    5 * time.Second,
+	}
+
+	report := diagnoser.Run()
+	if report.Passed || report.ClockSkew.Passed || report.ClockSkew.Skew < 3500 {
+		t.Errorf("An hour of skew should fail. Got: %+v", report.ClockSkew)
+	}
+	if !report.Config.Passed || report.Config.Message == "" {
+		t.Errorf("A missing node_name should be noted but not fail. Got: %+v", report.Config)
+	}
+
+	// The certificate is no longer trusted.
+	os.RemoveAll(filepath.Join(dir, "trusted_certs"))
+	report = diagnoser.Run()
+	if report.Connectivity.Passed || !report.Connectivity.TCP || report.Connectivity.TLS {
+		t.Errorf("The TLS handshake should fail. Got: %+v", report.Connectivity)
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(filepath.Join(dir, "client.pem"), 0644)
+		if report = diagnoser.Run(); report.ClientKey.Passed || !report.ClientKey.Exists {
+			t.Errorf("A key that others can read should fail. Got: %+v", report.ClientKey)
+		}
+	}
+	os.Remove(filepath.Join(dir, "client.pem"))
+	if report = diagnoser.Run(); report.ClientKey.Passed || report.ClientKey.Exists {
+		t.Errorf("A missing key should fail. Got: %+v", report.ClientKey)
+	}
+}
+
+func TestRunServerDown(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	diagnoser := &Diagnoser{
+		ConfigPath: writeClientConfig(t, dir, fmt.Sprintf("chef_server_url \"https://%s\"\n", address)),
+		Timeout:    time.Second,
+	}
+
+	report := diagnoser.Run()
+	if report.Passed || report.Connectivity.TCP || report.Connectivity.Address != address || report.ClockSkew.Passed {
+		t.Errorf("A server that is down should fail. Got: %+v", report)
+	}
+
+	diagnoser.ConfigPath = filepath.Join(dir, "missing.rb")
+	report = diagnoser.Run()
+	if report.Config.Passed || report.Connectivity.Message == "" {
+		t.Errorf("A missing client.rb should skip the network checks. Got: %+v", report)
+	}
+}
@@ -0,0 +1,14 @@
+package diagnostics
+
+import (
+	"fmt"
+	"os"
+)
+
+// keyPermissions fails if anyone other than the owner can read the client key.
+func keyPermissions(mode os.FileMode) error {
+	if mode.Perm()&0077 != 0 {
+		return fmt.Errorf("The client key has mode %04o. It should only be readable by its owner", mode.Perm())
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package diagnostics
+
+import "os"
+
+// keyPermissions does nothing on Windows. Access to the key is controlled by ACLs which
+// are not in the file mode.
+func keyPermissions(mode os.FileMode) error {
+	return nil
+}
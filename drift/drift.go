@@ -0,0 +1,163 @@
+// Package drift watches the periodic runs that fail in a row. When a threshold is crossed
+// the node has drifted. The monitor fails its health check, sends a metric and an event
+// and posts an alert to a webhook if one is set. It does the same again when a periodic
+// run completes and the drift clears.
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// checkInterval is how often the age threshold is checked between runs.
+const checkInterval = time.Minute
+
+// alertTimeout is how long the webhook has to accept an alert.
+const alertTimeout = 10 * time.Second
+
+// StateReadWriter is the part of the state table that holds the drift.
+type StateReadWriter interface {
+	ReadDrift() internalstate.Drift
+	WriteDrifted(bool)
+}
+
+// Thresholds decide when the periodic runs have drifted. A value of 0 turns the threshold off.
+type Thresholds struct {
+	// FailedRuns is the number of periodic runs in a row that can fail.
+	FailedRuns int
+	// MaxAge is how long the periodic runs can fail for.
+	MaxAge time.Duration
+}
+
+// crossed returns an error that says which threshold was crossed, or nil.
+func (t Thresholds) crossed(drift internalstate.Drift, now int64) error {
+	if t.FailedRuns > 0 && drift.ConsecutiveFailures >= t.FailedRuns {
+		return fmt.Errorf("The last %d periodic runs failed", drift.ConsecutiveFailures)
+	}
+	if t.MaxAge > 0 && drift.ConsecutiveFailures > 0 && now-drift.FailingSince >= int64(t.MaxAge/time.Second) {
+		return fmt.Errorf("Periodic runs have been failing for %s", time.Duration(now-drift.FailingSince)*time.Second)
+	}
+	return nil
+}
+
+// Alert is sent to the webhook and the event bus when the drift changes.
+type Alert struct {
+	Type     string `json:"type"`
+	HostName string `json:"hostname"`
+	Message  string `json:"message"`
+	Time     int64  `json:"time"`
+	internalstate.Drift
+}
+
+// Monitor checks the drift against the thresholds.
+type Monitor struct {
+	sync.Mutex
+	state      StateReadWriter
+	thresholds Thresholds
+	alertURL   string
+	httpClient *http.Client
+	logger     logs.SysLogger
+}
+
+// New returns a monitor. alertURL can be empty to turn off the webhook.
+func New(state StateReadWriter, thresholds Thresholds, alertURL string, logger logs.SysLogger) *Monitor {
+	return &Monitor{
+		state:      state,
+		thresholds: thresholds,
+		alertURL:   alertURL,
+		httpClient: &http.Client{Timeout: alertTimeout},
+		logger:     logger,
+	}
+}
+
+// Run checks the drift when a run finishes and every minute for the age threshold.
+// This is designed to be run as a go func.
+func (m *Monitor) Run() {
+	sub := events.Subscribe(10)
+	defer events.Unsubscribe(sub)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	m.Check()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if event.Type == events.RunFinished {
+				m.Check()
+			}
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
+
+// Check returns an error if the periodic runs have drifted. It can be used as a health check.
+// Alerts are sent when the drift is first detected and when it clears.
+func (m *Monitor) Check() error {
+	m.Lock()
+	defer m.Unlock()
+	drift := m.state.ReadDrift()
+	now := time.Now().Unix()
+	err := m.thresholds.crossed(drift, now)
+	metrics.Gauge("periodic_consecutive_failures", int64(drift.ConsecutiveFailures), nil)
+
+	switch {
+	case err != nil && !drift.Drifted:
+		m.logger.Warningf("Drift detected. %s", err)
+		m.state.WriteDrifted(true)
+		drift.Drifted = true
+		m.alert(events.DriftDetected, err.Error(), drift, now)
+	case err == nil && drift.Drifted:
+		m.logger.Info("Drift cleared. Periodic runs are passing again.")
+		m.state.WriteDrifted(false)
+		drift.Drifted = false
+		m.alert(events.DriftCleared, "Periodic runs are passing again", drift, now)
+	}
+	return err
+}
+
+// alert sends the metric, the event and the webhook for a change in the drift.
+func (m *Monitor) alert(alertType, message string, drift internalstate.Drift, now int64) {
+	metrics.Incr(alertType, 1, nil)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "not_available"
+	}
+	alert := Alert{Type: alertType, HostName: hostname, Message: message, Time: now, Drift: drift}
+	events.Publish(alertType, "", alert)
+	if m.alertURL != "" {
+		go m.send(alert)
+	}
+}
+
+// send posts the alert to the webhook.
+func (m *Monitor) send(alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		m.logger.Errorf("Failed to encode the %s alert. Error: %s", alert.Type, err)
+		return
+	}
+	resp, err := m.httpClient.Post(m.alertURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		metrics.Incr("drift_alert_failed", 1, nil)
+		m.logger.Errorf("Failed to send the %s alert. Error: %s", alert.Type, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		metrics.Incr("drift_alert_failed", 1, nil)
+		m.logger.Errorf("Failed to send the %s alert. The webhook returned %s", alert.Type, resp.Status)
+	}
+}
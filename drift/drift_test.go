@@ -0,0 +1,88 @@
+package drift
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+type fakeState struct {
+	drift internalstate.Drift
+}
+
+func (f *fakeState) ReadDrift() internalstate.Drift {
+	return f.drift
+}
+
+func (f *fakeState) WriteDrifted(drifted bool) {
+	f.drift.Drifted = drifted
+}
+
+func TestThresholds(t *testing.T) {
+	now := time.Now().Unix()
+	thresholds := Thresholds{FailedRuns: 3, MaxAge: time.Hour}
+	tests := []struct {
+		name    string
+		drift   internalstate.Drift
+		crossed bool
+	}{
+		{name: "Passing", drift: internalstate.Drift{LastSuccess: now - 7200}},
+		{name: "Some failures", drift: internalstate.Drift{ConsecutiveFailures: 2, FailingSince: now - 60}},
+		{name: "Too many failures", drift: internalstate.Drift{ConsecutiveFailures: 3, FailingSince: now - 60}, crossed: true},
+		{name: "Failing too long", drift: internalstate.Drift{ConsecutiveFailures: 1, FailingSince: now - 3600}, crossed: true},
+	}
+	for _, test := range tests {
+		if err := thresholds.crossed(test.drift, now); (err != nil) != test.crossed {
+			t.Errorf("%s: crossed should be %t. Error: %v", test.name, test.crossed, err)
+		}
+	}
+	if err := (Thresholds{}).crossed(internalstate.Drift{ConsecutiveFailures: 100, FailingSince: 1}, now); err != nil {
+		t.Errorf("Thresholds of 0 should be off. Error: %s", err)
+	}
+}
+
+func TestMonitorAlerts(t *testing.T) {
+	alerts := make(chan Alert, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := Alert{}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to decode the alert. Error: %s", err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	state := &fakeState{}
+	monitor := New(state, Thresholds{FailedRuns: 2}, server.URL, logs.NewFakeLogger(false))
+
+	state.drift.ConsecutiveFailures = 2
+	if err := monitor.Check(); err == nil {
+		t.Fatal("Check should fail once the threshold is crossed")
+	}
+	if !state.drift.Drifted {
+		t.Error("Drifted should be recorded in the state")
+	}
+	// Only the first check sends an alert.
+	monitor.Check()
+	if alert := <-alerts; alert.Type != "drift_detected" || alert.ConsecutiveFailures != 2 {
+		t.Errorf("Wrong alert was sent. Got: %+v", alert)
+	}
+
+	state.drift.ConsecutiveFailures = 0
+	if err := monitor.Check(); err != nil {
+		t.Errorf("Check should pass once the runs pass. Error: %s", err)
+	}
+	if alert := <-alerts; alert.Type != "drift_cleared" || alert.Drifted {
+		t.Errorf("Wrong alert was sent. Got: %+v", alert)
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("Unexpected alert. Got: %+v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,118 @@
+// Package events is an in process bus for changes of state in the chef waiter.
+// Publishers never block. Each subscriber has a bounded buffer and events that don't
+// fit are dropped for that subscriber only and counted so that it can find out.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of the events that are published.
+const (
+	RunRegistered      = "run_registered"
+	RunStarted         = "run_started"
+	RunFinished        = "run_finished"
+	LockChanged        = "lock_changed"
+	MaintenanceChanged = "maintenance_changed"
+	IntervalChanged    = "interval_changed"
+	PeriodicChanged    = "periodic_changed"
+	ConfigReloaded     = "config_reloaded"
+	DriftDetected      = "drift_detected"
+	DriftCleared       = "drift_cleared"
+	ChefVersionChanged = "chef_version_changed"
+)
+
+// Event is a single change of state. ID goes up by one for each event published on a bus.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time int64       `json:"time"`
+	GUID string      `json:"guid,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Subscription receives the events published after it was created.
+type Subscription struct {
+	// C is closed when the subscription is removed from the bus.
+	C       <-chan Event
+	c       chan Event
+	dropped uint64
+}
+
+// Dropped returns the number of events that did not fit in the buffer since it was last called.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+// Bus hands published events to every subscriber.
+type Bus struct {
+	sync.Mutex
+	lastID      uint64
+	subscribers map[*Subscription]bool
+}
+
+// NewBus returns a bus without any subscribers.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]bool)}
+}
+
+// Publish sends the event to every subscriber that has room for it.
+func (b *Bus) Publish(eventType, guid string, data interface{}) {
+	b.Lock()
+	defer b.Unlock()
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Time: time.Now().Unix(), GUID: guid, Data: data}
+	for s := range b.subscribers {
+		select {
+		case s.c <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Subscribe returns a subscription that can hold buffer events that have not been read.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c}
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers[s] = true
+	return s
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+// Subscribers returns the number of subscriptions on the bus.
+func (b *Bus) Subscribers() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.subscribers)
+}
+
+var defaultBus = NewBus()
+
+// Publish sends an event on the default bus.
+func Publish(eventType, guid string, data interface{}) {
+	defaultBus.Publish(eventType, guid, data)
+}
+
+// Subscribe creates a subscription on the default bus.
+func Subscribe(buffer int) *Subscription {
+	return defaultBus.Subscribe(buffer)
+}
+
+// Unsubscribe removes a subscription from the default bus.
+func Unsubscribe(s *Subscription) {
+	defaultBus.Unsubscribe(s)
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	fast := bus.Subscribe(10)
+	slow := bus.Subscribe(2)
+
+	for i := 0; i < 5; i++ {
+		bus.Publish(RunRegistered, "guid", nil)
+	}
+
+	for want := uint64(1); want <= 5; want++ {
+		if event := <-fast.C; event.ID != want || event.Type != RunRegistered || event.GUID != "guid" {
+			t.Errorf("Events are out of order. Got: %+v, Want ID: %d", event, want)
+		}
+	}
+	if dropped := fast.Dropped(); dropped != 0 {
+		t.Errorf("Fast subscriber should not drop events. Dropped: %d", dropped)
+	}
+
+	// The slow subscriber keeps the oldest events and counts the rest.
+	if event := <-slow.C; event.ID != 1 {
+		t.Errorf("Slow subscriber should have the first event. Got: %+v", event)
+	}
+	if dropped := slow.Dropped(); dropped != 3 {
+		t.Errorf("Slow subscriber should have dropped 3 events. Got: %d", dropped)
+	}
+	if dropped := slow.Dropped(); dropped != 0 {
+		t.Errorf("Dropped should reset after it is read. Got: %d", dropped)
+	}
+
+	bus.Unsubscribe(slow)
+	bus.Unsubscribe(slow)
+	if bus.Subscribers() != 1 {
+		t.Errorf("Bus should have 1 subscriber. Got: %d", bus.Subscribers())
+	}
+	<-slow.C
+	if _, ok := <-slow.C; ok {
+		t.Error("Unsubscribe should close the channel")
+	}
+}
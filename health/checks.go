@@ -0,0 +1,77 @@
+package health
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+// JobReader is used by the checks that look at the runs.
+type JobReader interface {
+	ReadAllJobs() map[string]internalstate.JobDetails
+}
+
+// WritableDir fails if a file can't be written to the directory.
+func WritableDir(name, dir string) Check {
+	return Check{Name: name, Kind: Readiness, Run: func() error {
+		f, err := ioutil.TempFile(dir, ".chefwaiter-healthcheck")
+		if err != nil {
+			return fmt.Errorf("%s is not writable. Error: %s", dir, err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}}
+}
+
+// FreeSpace fails if the disk that holds the directory has less than minMB megabytes free.
+func FreeSpace(name, dir string, minMB uint64) Check {
+	return Check{Name: name, Kind: Readiness, Run: func() error {
+		free, err := freeBytes(dir)
+		if err != nil {
+			return fmt.Errorf("Failed to read the free space for %s. Error: %s", dir, err)
+		}
+		if free/1024/1024 < minMB {
+			return fmt.Errorf("%s has %dMB free. It needs at least %dMB", dir, free/1024/1024, minMB)
+		}
+		return nil
+	}}
+}
+
+// Binary fails if the binary can't be found. A name without a path is looked for in the PATH.
+func Binary(name, path string) Check {
+	return Check{Name: name, Kind: Readiness, Run: func() error {
+		if _, err := exec.LookPath(path); err != nil {
+			return fmt.Errorf("%s was not found. Error: %s", path, err)
+		}
+		return nil
+	}}
+}
+
+// StuckRun fails if a run has been running for longer than maxRunTime.
+// The supervisor runs one job at a time so a stuck run stops every other run.
+func StuckRun(name string, jobs JobReader, maxRunTime time.Duration) Check {
+	return Check{Name: name, Kind: Liveness, Run: func() error {
+		now := time.Now().Unix()
+		for guid, job := range jobs.ReadAllJobs() {
+			if job.Status == "running" && job.RunStartTime > 0 && now-job.RunStartTime > int64(maxRunTime/time.Second) {
+				return fmt.Errorf("Run %s has been running for %s", guid, time.Duration(now-job.RunStartTime)*time.Second)
+			}
+		}
+		return nil
+	}}
+}
+
+// Warning returns the check as one that is reported but never makes the chef waiter unhealthy.
+func Warning(check Check) Check {
+	check.Warn = true
+	return check
+}
+
+// Func makes a check from a function.
+func Func(name, kind string, f func() error) Check {
+	return Check{Name: name, Kind: kind, Run: f}
+}
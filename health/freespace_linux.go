@@ -0,0 +1,12 @@
+package health
+
+import "syscall"
+
+// freeBytes returns the bytes that are available to us on the disk that holds dir.
+func freeBytes(dir string) (uint64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
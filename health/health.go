@@ -0,0 +1,127 @@
+// Package health runs the checks that decide if the chef waiter is alive and ready.
+// Liveness checks fail when the chef waiter needs to be restarted. Readiness checks fail
+// when it can't do its job right now. Checks are run when they are asked for and the
+// results are kept for a short time so that busy load balancers don't repeat the work.
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of checks.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+)
+
+// Statuses of a check result.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// defaultCacheTime is how long a result is used before the check is run again.
+const defaultCacheTime = 5 * time.Second
+
+// Check is a single health check. A check that returns an error has failed.
+// Warn checks are reported but never make the chef waiter unhealthy.
+type Check struct {
+	Name string
+	Kind string
+	Warn bool
+	Run  func() error
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// Report is the outcome of a set of checks. Healthy is false if any of them failed.
+type Report struct {
+	Healthy bool
+	Results []Result
+}
+
+type cachedResult struct {
+	result Result
+	ran    time.Time
+}
+
+// Checker holds the checks and their last results.
+type Checker struct {
+	sync.Mutex
+	checks    []Check
+	cache     map[string]cachedResult
+	cacheTime time.Duration
+}
+
+// New returns a checker without any checks. It is always healthy until checks are added.
+func New() *Checker {
+	return &Checker{
+		cache:     make(map[string]cachedResult),
+		cacheTime: defaultCacheTime,
+	}
+}
+
+// Add adds checks to the checker.
+func (c *Checker) Add(checks ...Check) {
+	c.Lock()
+	defer c.Unlock()
+	c.checks = append(c.checks, checks...)
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live() Report {
+	return c.run(true)
+}
+
+// Ready runs every check. The chef waiter can't be ready if it is not alive.
+func (c *Checker) Ready() Report {
+	return c.run(false)
+}
+
+// Healthy returns true if none of the checks failed.
+func (c *Checker) Healthy() bool {
+	return c.Ready().Healthy
+}
+
+func (c *Checker) run(liveOnly bool) Report {
+	c.Lock()
+	defer c.Unlock()
+	report := Report{Healthy: true, Results: []Result{}}
+	now := time.Now()
+	for _, check := range c.checks {
+		if liveOnly && check.Kind != Liveness {
+			continue
+		}
+		cached, ok := c.cache[check.Name]
+		if !ok || now.Sub(cached.ran) >= c.cacheTime {
+			cached = cachedResult{result: runCheck(check, now), ran: now}
+			c.cache[check.Name] = cached
+		}
+		if cached.result.Status == StatusFail {
+			report.Healthy = false
+		}
+		report.Results = append(report.Results, cached.result)
+	}
+	return report
+}
+
+func runCheck(check Check, now time.Time) Result {
+	result := Result{Name: check.Name, Kind: check.Kind, Status: StatusOK, Time: now.Unix()}
+	if err := check.Run(); err != nil {
+		result.Status = StatusFail
+		if check.Warn {
+			result.Status = StatusWarn
+		}
+		result.Message = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+func TestChecker(t *testing.T) {
+	checker := New()
+	if !checker.Healthy() {
+		t.Error("A checker without checks should be healthy")
+	}
+
+	var failure error
+	runs := 0
+	checker.Add(
+		Func("live", Liveness, func() error { return nil }),
+		Func("ready", Readiness, func() error { runs++; return failure }),
+		Warning(Func("warn", Readiness, func() error { return errors.New("statsd is down") })),
+	)
+
+	if report := checker.Live(); !report.Healthy || len(report.Results) != 1 {
+		t.Errorf("Live should only run the liveness checks. Got: %+v", report)
+	}
+	report := checker.Ready()
+	if !report.Healthy || len(report.Results) != 3 {
+		t.Errorf("Ready should run every check and ignore warnings. Got: %+v", report)
+	}
+	if report.Results[2].Status != StatusWarn || report.Results[2].Message != "statsd is down" {
+		t.Errorf("Warning check has the wrong result. Got: %+v", report.Results[2])
+	}
+
+	// Results are cached.
+	failure = errors.New("broken")
+	if !checker.Healthy() || runs != 1 {
+		t.Errorf("The cached result should be used. Runs: %d", runs)
+	}
+
+	checker.cacheTime = 0
+	if checker.Healthy() {
+		t.Error("Checker should be unhealthy once the cache runs out")
+	}
+	failure = nil
+	if !checker.Healthy() {
+		t.Error("Checker should recover once the failure clears")
+	}
+}
+
+type fakeJobs map[string]internalstate.JobDetails
+
+func (f fakeJobs) ReadAllJobs() map[string]internalstate.JobDetails {
+	return f
+}
+
+func TestStuckRun(t *testing.T) {
+	now := time.Now().Unix()
+	jobs := fakeJobs{
+		"a": {Status: "failed", RunStartTime: now - 300},
+		"b": {Status: "running", RunStartTime: now - 100},
+	}
+
+	if err := StuckRun("stuck", jobs, 10*time.Minute).Run(); err != nil {
+		t.Errorf("The run is not stuck. Error: %s", err)
+	}
+	if err := StuckRun("stuck", jobs, time.Minute).Run(); err == nil {
+		t.Error("The run has been running for longer than a minute")
+	}
+}
+
+func TestDiskChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chefwaiter-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WritableDir("state", dir).Run(); err != nil {
+		t.Errorf("Temp dir should be writable. Error: %s", err)
+	}
+	if err := WritableDir("state", filepath.Join(dir, "missing")).Run(); err == nil {
+		t.Error("A missing dir should not be writable")
+	}
+	if err := FreeSpace("logs", dir, 0).Run(); err != nil {
+		t.Errorf("0MB should always be free. Error: %s", err)
+	}
+	if err := FreeSpace("logs", dir, 1<<40).Run(); err == nil {
+		t.Error("A disk should not have an exabyte free")
+	}
+	if err := Binary("chef", filepath.Join(dir, "chef-client")).Run(); err == nil {
+		t.Error("A missing binary should fail")
+	}
+}
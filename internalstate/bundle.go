@@ -0,0 +1,10 @@
+package internalstate
+
+// UpdateBundle - Records the sha256 checksum of the uploaded cookbook bundle that an ID runs.
+func (st *StateTable) UpdateBundle(guid string, checksum string) {
+	st.lock()
+	defer st.unlock()
+	if job, ok := st.Status[guid]; ok {
+		job.BundleSHA256 = checksum
+	}
+}
@@ -1,9 +1,78 @@
 package internalstate
 
-import "regexp"
+import (
+	"errors"
+	"regexp"
+
+	"github.com/morfien101/chef-waiter/cmd"
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// chefVersion asks the chef-client binary for its version.
+func chefVersion(binary string) (string, error) {
+	stdout, _, exitCode := cmd.RunCommand(binary, "-v")
+	if exitCode != 0 {
+		return "", errors.New("Could not determin chef version")
+	}
+	version := extractVersion(stdout)
+	if version == "" {
+		return "", errors.New("Could not find a version in the output of chef-client -v")
+	}
+	return version, nil
+}
 
 func extractVersion(in string) string {
 	re := regexp.MustCompile(`([0-9]+\.[0-9]+\.[0-9]+)`)
 	versionNumber := re.FindString(in)
 	return versionNumber
 }
+
+// maxChefVersionHistory is how many changes of the chef-client version are kept.
+const maxChefVersionHistory = 20
+
+// ChefVersions is the version of chef-client and the changes to it, oldest first.
+// The first version seen is recorded as a change with an empty From.
+type ChefVersions struct {
+	Current string              `json:"chef_version"`
+	History []ChefVersionChange `json:"chef_version_history"`
+}
+
+// ChefVersionChange is a change to the version of chef-client. Time is when it was seen.
+type ChefVersionChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Time int64  `json:"time"`
+}
+
+// RecordChefVersion stores the version of chef-client. A change is added to the history and
+// sent as an event. It returns true if the version changed.
+func (st *StateTable) RecordChefVersion(version string, now int64) bool {
+	st.lock()
+	defer st.unlock()
+	if version == st.ChefVersions.Current {
+		return false
+	}
+	change := ChefVersionChange{From: st.ChefVersions.Current, To: version, Time: now}
+	st.ChefVersions.Current = version
+	st.ChefVersions.History = append(st.ChefVersions.History, change)
+	if len(st.ChefVersions.History) > maxChefVersionHistory {
+		st.ChefVersions.History = st.ChefVersions.History[len(st.ChefVersions.History)-maxChefVersionHistory:]
+	}
+	metrics.Incr("chef_version_changed", 1, map[string]string{"from": change.From, "to": change.To})
+	events.Publish(events.ChefVersionChanged, "", change)
+	return true
+}
+
+// ReadChefVersions will return a copy of the chef-client version and its history.
+func (st *StateTable) ReadChefVersions() ChefVersions {
+	st.rLock()
+	defer st.rUnlock()
+	return st.ChefVersions.copy()
+}
+
+func (v ChefVersions) copy() ChefVersions {
+	history := make([]ChefVersionChange, len(v.History))
+	copy(history, v.History)
+	return ChefVersions{Current: v.Current, History: history}
+}
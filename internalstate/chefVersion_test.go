@@ -1,6 +1,13 @@
 package internalstate
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/logs"
+)
 
 func TestExtractVersion(t *testing.T) {
 	tests := []struct {
@@ -28,3 +35,74 @@ func TestExtractVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestRecordChefVersion(t *testing.T) {
+	st := &StateTable{Status: map[string]*JobDetails{"job": &JobDetails{Status: "registered"}}}
+	sub := events.Subscribe(10)
+	defer events.Unsubscribe(sub)
+
+	if !st.RecordChefVersion("15.9.100", 100) || st.RecordChefVersion("15.9.100", 200) {
+		t.Error("Only a change of version should be recorded")
+	}
+	st.UpdateStatus("job", "running")
+	if job, _ := st.ReadJob("job"); job.ChefVersion != "15.9.100" {
+		t.Errorf("The job should record the version of chef that ran it. Got: %q", job.ChefVersion)
+	}
+	if !st.RecordChefVersion("16.1.0", 300) {
+		t.Error("The upgrade should be recorded")
+	}
+	versions := st.ReadChefVersions()
+	expected := []ChefVersionChange{{From: "", To: "15.9.100", Time: 100}, {From: "15.9.100", To: "16.1.0", Time: 300}}
+	if versions.Current != "16.1.0" || fmt.Sprint(versions.History) != fmt.Sprint(expected) {
+		t.Errorf("The history is wrong. Got: %+v", versions)
+	}
+
+	changes := 0
+	for len(sub.C) > 0 {
+		if event := <-sub.C; event.Type == events.ChefVersionChanged {
+			changes++
+		}
+	}
+	if changes != 2 {
+		t.Errorf("An event should be sent for each change. Got: %d", changes)
+	}
+
+	for i := 0; i < maxChefVersionHistory+5; i++ {
+		st.RecordChefVersion(fmt.Sprintf("17.0.%d", i), int64(400+i))
+	}
+	if versions = st.ReadChefVersions(); len(versions.History) != maxChefVersionHistory || versions.History[maxChefVersionHistory-1].To != versions.Current {
+		t.Errorf("The history should keep the last %d changes. Got: %+v", maxChefVersionHistory, versions.History)
+	}
+}
+
+func TestUpdateChefVersionRecovers(t *testing.T) {
+	// probeResult is what the fake chef-client reports.
+	var probeResult struct {
+		version string
+		err     error
+	}
+	fakeChef := func(binary string) (string, error) {
+		if binary != "chef-client" {
+			t.Errorf("The probe should be given the chef-client binary. Got: %q", binary)
+		}
+		return probeResult.version, probeResult.err
+	}
+	st := &StateTable{Status: make(map[string]*JobDetails)}
+	appState := &AppStatusHandler{state: &AppStatus{Healthy: true}, currentState: st, logger: logs.NewFakeLogger(false), chefClientBinary: "chef-client", probe: fakeChef}
+
+	probeResult.err = errors.New("Could not determin chef version")
+	if wait := appState.updateChefVersion(); wait != chefVersionRetry || appState.state.Healthy {
+		t.Errorf("A failed probe should be unhealthy and try again soon. Got: %s, %v", wait, appState.state.Healthy)
+	}
+	probeResult.version, probeResult.err = "16.1.0", nil
+	if wait := appState.updateChefVersion(); wait != chefVersionInterval || !appState.state.Healthy {
+		t.Errorf("The status should be healthy again once the probe works. Got: %s, %v", wait, appState.state.Healthy)
+	}
+	if st.ReadChefVersions().Current != "16.1.0" {
+		t.Errorf("The version should be recorded. Got: %+v", st.ReadChefVersions())
+	}
+	probeResult.version, probeResult.err = "", errors.New("Could not find a version in the output of chef-client -v")
+	if appState.updateChefVersion(); appState.state.Healthy {
+		t.Error("The status should be unhealthy when the probe fails again")
+	}
+}
@@ -0,0 +1,59 @@
+package internalstate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Values accepted by ParseCoalesce.
+const (
+	CoalesceJoinQueued  = "join-queued"
+	CoalesceAlwaysNew   = "always-new"
+	coalesceAfterPrefix = "after-timestamp="
+)
+
+// Coalesce decides if a run request can join a matching job that is already registered.
+// The zero value joins any matching registered job.
+type Coalesce struct {
+	// AlwaysNew will never join a registered job.
+	AlwaysNew bool
+	// After only joins jobs that were registered at or after this epoch time.
+	After int64
+}
+
+// ParseCoalesce reads a coalescing policy. It can be join-queued, always-new or after-timestamp=<epoch>.
+// An empty string is join-queued.
+func ParseCoalesce(value string) (Coalesce, error) {
+	switch {
+	case value == "" || value == CoalesceJoinQueued:
+		return Coalesce{}, nil
+	case value == CoalesceAlwaysNew:
+		return Coalesce{AlwaysNew: true}, nil
+	case strings.HasPrefix(value, coalesceAfterPrefix):
+		epoch, err := strconv.ParseInt(strings.TrimPrefix(value, coalesceAfterPrefix), 10, 64)
+		if err != nil || epoch < 0 {
+			return Coalesce{}, fmt.Errorf("%s needs a positive epoch time, eg: %s1542124123", coalesceAfterPrefix, coalesceAfterPrefix)
+		}
+		return Coalesce{After: epoch}, nil
+	}
+	return Coalesce{}, fmt.Errorf("coalesce must be %s, %s or %s<epoch>", CoalesceJoinQueued, CoalesceAlwaysNew, coalesceAfterPrefix)
+}
+
+func (c Coalesce) String() string {
+	switch {
+	case c.AlwaysNew:
+		return CoalesceAlwaysNew
+	case c.After > 0:
+		return fmt.Sprintf("%s%d", coalesceAfterPrefix, c.After)
+	}
+	return CoalesceJoinQueued
+}
+
+// allows returns true if a request with this policy can join the job.
+func (c Coalesce) allows(job *JobDetails) bool {
+	if c.AlwaysNew {
+		return false
+	}
+	return job.RegisteredTime >= c.After
+}
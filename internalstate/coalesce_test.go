@@ -0,0 +1,88 @@
+package internalstate
+
+import (
+	"testing"
+)
+
+func TestParseCoalesce(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Coalesce
+		err   bool
+	}{
+		{value: "", want: Coalesce{}},
+		{value: "join-queued", want: Coalesce{}},
+		{value: "always-new", want: Coalesce{AlwaysNew: true}},
+		{value: "after-timestamp=1542124123", want: Coalesce{After: 1542124123}},
+		{value: "after-timestamp=yesterday", err: true},
+		{value: "after-timestamp=-1", err: true},
+		{value: "sometimes", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseCoalesce(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q should not parse. Got: %+v", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q parsed incorrectly. Got: %+v, Want: %+v, Error: %v", test.value, got, test.want, err)
+		}
+	}
+}
+
+func TestRegisterRunCoalesce(t *testing.T) {
+	st := &StateTable{
+		Status: map[string]*JobDetails{
+			"old":    &JobDetails{Status: "registered", RegisteredTime: 100, OnDemand: true},
+			"custom": &JobDetails{Status: "registered", RegisteredTime: 200, OnDemand: true, CustomRun: true, CustomRunString: "test::run"},
+		},
+	}
+
+	if created, guid := st.RegisterRun(true, false, "", Policy{}, Coalesce{}); created || guid != "old" {
+		t.Errorf("join-queued should join the registered on demand job. Got: %s, created: %t", guid, created)
+	}
+	if created, guid := st.RegisterRun(true, true, "other::run", Policy{}, Coalesce{}); !created || guid == "custom" {
+		t.Errorf("Custom runs should only join jobs with the same run list. Got: %s, created: %t", guid, created)
+	}
+	if created, guid := st.RegisterRun(true, true, "test::run", Policy{}, Coalesce{After: 150}); created || guid != "custom" {
+		t.Errorf("after-timestamp should join jobs registered after the time. Got: %s, created: %t", guid, created)
+	}
+	if created, guid := st.RegisterRun(true, false, "", Policy{}, Coalesce{After: 150}); !created || guid == "old" {
+		t.Errorf("after-timestamp should not join jobs registered before the time. Got: %s, created: %t", guid, created)
+	}
+	if created, guid := st.RegisterRun(true, true, "test::run", Policy{}, Coalesce{AlwaysNew: true}); !created || guid == "custom" {
+		t.Errorf("always-new should not join a registered job. Got: %s, created: %t", guid, created)
+	}
+}
+
+func TestRegisterRunDoesNotJoinBundles(t *testing.T) {
+	st := &StateTable{
+		Status: map[string]*JobDetails{
+			"bundle": &JobDetails{Status: "registered", OnDemand: true, CustomRun: true, CustomRunString: "base", BundleSHA256: "abc"},
+		},
+	}
+	if created, guid := st.RegisterRun(true, true, "base", Policy{}, Coalesce{}); !created || guid == "bundle" {
+		t.Errorf("Runs should never join a job that runs an uploaded bundle. Got: %s, created: %t", guid, created)
+	}
+}
+
+func TestRegisterRunMatchesPolicy(t *testing.T) {
+	canary := Policy{PolicyGroup: "canary", PolicyName: "web"}
+	st := &StateTable{
+		Status: map[string]*JobDetails{
+			"canary": &JobDetails{Status: "registered", OnDemand: true, CustomRun: true, Policy: canary},
+		},
+	}
+	if created, guid := st.RegisterRun(true, true, "", canary, Coalesce{}); created || guid != "canary" {
+		t.Errorf("A run with the same policy should join the queued job. Got: %s, created: %t", guid, created)
+	}
+	created, guid := st.RegisterRun(true, true, "", Policy{PolicyGroup: "prod", PolicyName: "web"}, Coalesce{})
+	if !created || guid == "canary" {
+		t.Errorf("A run with a different policy should not join the queued job. Got: %s, created: %t", guid, created)
+	}
+	if job, _ := st.ReadJob(guid); job.PolicyGroup != "prod" || job.PolicyName != "web" {
+		t.Errorf("The policy should be recorded on the job. Got: %+v", job.Policy)
+	}
+}
@@ -0,0 +1,22 @@
+package internalstate
+
+// The states of a graceful stop. The running job is given until the deadline to finish
+// while draining and is then terminated. Drained is when nothing is left running.
+const (
+	DrainDraining    = "draining"
+	DrainTerminating = "terminating"
+	DrainDrained     = "drained"
+)
+
+// Drain is the progress of a graceful stop. State is empty until the stop starts.
+// Started and Deadline are unix times.
+type Drain struct {
+	State    string `json:"state,omitempty"`
+	Started  int64  `json:"started,omitempty"`
+	Deadline int64  `json:"deadline,omitempty"`
+}
+
+// DrainReader tells the status how a graceful stop is going.
+type DrainReader interface {
+	DrainStatus() Drain
+}
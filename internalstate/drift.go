@@ -0,0 +1,48 @@
+package internalstate
+
+// Drift tracks the periodic runs that have failed in a row. A node that keeps failing
+// its periodic runs has drifted from what chef says it should be.
+// Drifted is set by whatever watches the thresholds so that it survives a restart.
+type Drift struct {
+	ConsecutiveFailures int   `json:"consecutive_periodic_failures"`
+	LastSuccess         int64 `json:"last_periodic_success"`
+	FailingSince        int64 `json:"periodic_failing_since"`
+	Drifted             bool  `json:"drifted"`
+}
+
+// recordPeriodicResult updates the drift when a periodic run finishes.
+// Only complete and failed runs count. A failed retry is part of the same failure so only
+// the first attempt is counted. The caller must hold the lock.
+func (st *StateTable) recordPeriodicResult(job *JobDetails, now int64) {
+	if job.OnDemand || job.CustomRun {
+		return
+	}
+	switch job.Status {
+	case "complete":
+		st.Drift.ConsecutiveFailures = 0
+		st.Drift.FailingSince = 0
+		st.Drift.LastSuccess = now
+	case "failed":
+		if job.Attempt > 1 {
+			return
+		}
+		if st.Drift.ConsecutiveFailures == 0 {
+			st.Drift.FailingSince = now
+		}
+		st.Drift.ConsecutiveFailures++
+	}
+}
+
+// ReadDrift will return a copy of the periodic run drift.
+func (st *StateTable) ReadDrift() Drift {
+	st.rLock()
+	defer st.rUnlock()
+	return st.Drift
+}
+
+// WriteDrifted will record if the drift thresholds have been crossed.
+func (st *StateTable) WriteDrifted(drifted bool) {
+	st.lock()
+	defer st.unlock()
+	st.Drift.Drifted = drifted
+}
@@ -0,0 +1,48 @@
+package internalstate
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestDrift(t *testing.T) {
+	st := &StateTable{
+		Status: map[string]*JobDetails{
+			"periodic-1": &JobDetails{Status: "running"},
+			"periodic-2": &JobDetails{Status: "running"},
+			"ondemand":   &JobDetails{Status: "running", OnDemand: true},
+			"periodic-3": &JobDetails{Status: "running"},
+			"retry":      &JobDetails{Status: "running", ParentGUID: "periodic-2", Attempt: 2},
+		},
+	}
+
+	st.UpdateStatus("periodic-1", "failed")
+	st.UpdateStatus("ondemand", "complete")
+	st.UpdateStatus("periodic-2", "failed")
+	st.UpdateStatus("retry", "failed")
+	drift := st.ReadDrift()
+	if drift.ConsecutiveFailures != 2 || drift.FailingSince == 0 {
+		t.Errorf("Two periodic runs failed in a row and the on demand run and retry should not count. Got: %+v", drift)
+	}
+
+	// The drift is saved with the state table.
+	st.WriteDrifted(true)
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(st); err != nil {
+		t.Fatal(err)
+	}
+	saved := &StateTable{}
+	if err := gob.NewDecoder(buf).Decode(saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Drift != st.ReadDrift() {
+		t.Errorf("Drift was not saved. Got: %+v, Want: %+v", saved.Drift, st.ReadDrift())
+	}
+
+	st.UpdateStatus("periodic-3", "complete")
+	drift = st.ReadDrift()
+	if drift.ConsecutiveFailures != 0 || drift.FailingSince != 0 || drift.LastSuccess == 0 {
+		t.Errorf("A complete periodic run should reset the failures. Got: %+v", drift)
+	}
+}
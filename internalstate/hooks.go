@@ -0,0 +1,36 @@
+package internalstate
+
+// Hook stages that a HookResult can be for.
+const (
+	PreRunHook  = "pre_run"
+	PostRunHook = "post_run"
+)
+
+// HookResult is the outcome of a script that was run before or after chef.
+// Duration is in milliseconds. Error is set if the hook could not be started or timed out.
+type HookResult struct {
+	Name     string `json:"name"`
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out"`
+	Duration int64  `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Failed returns true if the hook did not run or did not exit with 0.
+func (h HookResult) Failed() bool {
+	return h.ExitCode != 0 || h.TimedOut || h.Error != ""
+}
+
+// UpdateHookResult - Records the result of a hook that ran for an ID.
+func (st *StateTable) UpdateHookResult(guid string, result HookResult) {
+	st.lock()
+	defer st.unlock()
+	job, ok := st.Status[guid]
+	if !ok {
+		return
+	}
+	// Copies of the job handed out by ReadJob share the slice so never append in place.
+	hooks := make([]HookResult, len(job.Hooks), len(job.Hooks)+1)
+	copy(hooks, job.Hooks)
+	job.Hooks = append(hooks, result)
+}
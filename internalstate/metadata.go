@@ -0,0 +1,96 @@
+package internalstate
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Limits for the run metadata. They keep the state file and metric tags a sensible size.
+const (
+	maxRequesterLength   = 128
+	maxReasonLength      = 512
+	maxPipelineURLLength = 1024
+	maxLabels            = 16
+	maxLabelValueLength  = 128
+)
+
+var labelKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// RunMetadata describes who requested a run and why.
+type RunMetadata struct {
+	Requester   string            `json:"requester"`
+	Reason      string            `json:"reason"`
+	PipelineURL string            `json:"pipeline_url"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Validate checks the metadata is within the limits and that the pipeline url is a http url.
+func (m RunMetadata) Validate() error {
+	if len(m.Requester) > maxRequesterLength {
+		return fmt.Errorf("requester can not be longer than %d characters", maxRequesterLength)
+	}
+	if len(m.Reason) > maxReasonLength {
+		return fmt.Errorf("reason can not be longer than %d characters", maxReasonLength)
+	}
+	if m.PipelineURL != "" {
+		if len(m.PipelineURL) > maxPipelineURLLength {
+			return fmt.Errorf("pipeline_url can not be longer than %d characters", maxPipelineURLLength)
+		}
+		u, err := url.Parse(m.PipelineURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("pipeline_url must be a http or https url. Got: %s", m.PipelineURL)
+		}
+	}
+	if len(m.Labels) > maxLabels {
+		return fmt.Errorf("a run can not have more than %d labels", maxLabels)
+	}
+	for key, value := range m.Labels {
+		if !labelKeyRegex.MatchString(key) {
+			return fmt.Errorf("label %q must be 1 to 64 letters, numbers, '_', '.' or '-'", key)
+		}
+		if len(value) > maxLabelValueLength {
+			return fmt.Errorf("label %s can not be longer than %d characters", key, maxLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// ParseLabels reads labels in the form key=value.
+func ParseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("labels must be in the form key=value. Got: %s", value)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// HasLabels returns true if the metadata has every one of the labels.
+func (m RunMetadata) HasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if got, ok := m.Labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// copy returns the metadata with its own copy of the labels.
+func (m RunMetadata) copy() RunMetadata {
+	if m.Labels != nil {
+		labels := make(map[string]string, len(m.Labels))
+		for key, value := range m.Labels {
+			labels[key] = value
+		}
+		m.Labels = labels
+	}
+	return m
+}
@@ -0,0 +1,47 @@
+package internalstate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunMetadataValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata RunMetadata
+		valid    bool
+	}{
+		{name: "empty", metadata: RunMetadata{}, valid: true},
+		{name: "full", metadata: RunMetadata{Requester: "alice", Reason: "fix", PipelineURL: "https://ci.example.com/1", Labels: map[string]string{"team": "web"}}, valid: true},
+		{name: "long requester", metadata: RunMetadata{Requester: strings.Repeat("a", maxRequesterLength+1)}},
+		{name: "pipeline not a url", metadata: RunMetadata{PipelineURL: "ci.example.com/1"}},
+		{name: "pipeline not http", metadata: RunMetadata{PipelineURL: "ftp://ci.example.com/1"}},
+		{name: "bad label key", metadata: RunMetadata{Labels: map[string]string{"a b": "x"}}},
+		{name: "long label value", metadata: RunMetadata{Labels: map[string]string{"a": strings.Repeat("x", maxLabelValueLength+1)}}},
+	}
+	for _, test := range tests {
+		err := test.metadata.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s should be valid. Error: %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s should not be valid", test.name)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"team=web", "query=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels["team"] != "web" || labels["query"] != "a=b" || labels["empty"] != "" || len(labels) != 3 {
+		t.Errorf("Labels parsed incorrectly. Got: %v", labels)
+	}
+	if _, err := ParseLabels([]string{"team"}); err == nil {
+		t.Error("A label without a value should not parse")
+	}
+	if labels, _ := ParseLabels(nil); labels != nil {
+		t.Errorf("No labels should be nil. Got: %v", labels)
+	}
+}
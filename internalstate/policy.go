@@ -0,0 +1,38 @@
+package internalstate
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var policyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.:-]{1,255}$`)
+
+// Policy is the Policyfile group and name that a custom run uses instead of the ones in the
+// chef-client config. The zero value is a run without a policy.
+type Policy struct {
+	PolicyGroup string `json:"policy_group,omitempty"`
+	PolicyName  string `json:"policy_name,omitempty"`
+}
+
+// IsSet returns true if the run has a policy.
+func (p Policy) IsSet() bool {
+	return p.PolicyGroup != "" || p.PolicyName != ""
+}
+
+// Validate checks that both the group and name are set and that they are valid names.
+func (p Policy) Validate() error {
+	if p.PolicyGroup == "" || p.PolicyName == "" {
+		return fmt.Errorf("policy_group and policy_name must be set together")
+	}
+	for field, value := range map[string]string{"policy_group": p.PolicyGroup, "policy_name": p.PolicyName} {
+		if !policyRegex.MatchString(value) {
+			return fmt.Errorf("%s must be 1 to 255 letters, numbers, '_', '.', ':' or '-'", field)
+		}
+	}
+	return nil
+}
+
+// String returns the policy as group/name.
+func (p Policy) String() string {
+	return p.PolicyGroup + "/" + p.PolicyName
+}
@@ -0,0 +1,26 @@
+package internalstate
+
+import "testing"
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		valid  bool
+	}{
+		{policy: Policy{PolicyGroup: "canary", PolicyName: "webserver"}, valid: true},
+		{policy: Policy{PolicyGroup: "prod-eu:1", PolicyName: "base.v2"}, valid: true},
+		{policy: Policy{PolicyGroup: "canary"}},
+		{policy: Policy{PolicyName: "webserver"}},
+		{policy: Policy{PolicyGroup: "canary group", PolicyName: "webserver"}},
+		{policy: Policy{PolicyGroup: "canary", PolicyName: "web;rm"}},
+	}
+	for _, test := range tests {
+		err := test.policy.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s should be valid. Error: %s", test.policy, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s should not be valid", test.policy)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package internalstate
+
+// PreconditionResult is the outcome of a check that must pass before a job can start.
+// Time is the epoch time that the check ran.
+type PreconditionResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// PreconditionReader tells the status how the preconditions did when they were last checked.
+type PreconditionReader interface {
+	PreconditionResults() []PreconditionResult
+}
+
+// UpdateSkipReason - Records why an ID was skipped instead of run.
+func (st *StateTable) UpdateSkipReason(guid string, reason string) {
+	st.lock()
+	defer st.unlock()
+	if job, ok := st.Status[guid]; ok {
+		job.SkipReason = reason
+	}
+}
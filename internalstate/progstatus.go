@@ -6,14 +6,44 @@ import (
 	"sync"
 	"time"
 
+	"github.com/morfien101/chef-waiter/events"
 	"github.com/morfien101/chef-waiter/logs"
 )
 
+// chefVersionInterval is how often chef-client is asked for its version. After a failure
+// it is asked again every chefVersionRetry so that the status is healthy again soon after
+// chef-client is fixed.
+const (
+	chefVersionInterval = 15 * time.Minute
+	chefVersionRetry    = time.Minute
+)
+
 // AppStatusHandler - Hosts the AppStatus in a mutable struct.
+// The parts that come from the state table are read when the status is asked for
+// so that they are never out of date.
 type AppStatusHandler struct {
 	sync.RWMutex
-	state  *AppStatus
-	logger logs.SysLogger
+	state        *AppStatus
+	currentState *StateTable
+	queue        QueueReader
+	health       HealthReader
+	precondition PreconditionReader
+	drain        DrainReader
+	logger       logs.SysLogger
+	// chefClientBinary and probe never change so they are read without the lock.
+	chefClientBinary string
+	// probe asks the chef-client binary for its version.
+	probe func(binary string) (string, error)
+}
+
+// QueueReader tells the status how many jobs are waiting to run.
+type QueueReader interface {
+	QueueDepth() int
+}
+
+// HealthReader tells the status if the health checks are passing.
+type HealthReader interface {
+	Healthy() bool
 }
 
 // AppStatus - Holds status information about the chef waiter itself.
@@ -25,13 +55,24 @@ type AppStatus struct {
 	Uptime            int64    `json:"uptime"`
 	StartTimeHuman    string   `json:"start_time_human_readable"`
 	Version           string   `json:"version"`
-	ChefVersion       string   `json:"chef_version"`
 	Healthy           bool     `json:"healthy"`
 	InMaintenance     bool     `json:"in_maintenance_mode"`
 	LastRunGUID       string   `json:"last_run_id"`
+	RunningGUID       string   `json:"running_id"`
+	QueueDepth        int      `json:"queue_depth"`
+	NextRunTime       int64    `json:"next_run_time"`
 	Locked            bool     `json:"locked"`
 	WhiteListsEnabled bool     `json:"whitelisting_enabled"`
 	WhiteList         []string `json:"whitelisted_payloads"`
+	// AllowedPolicies are the policies that custom runs can use.
+	AllowedPolicies []Policy `json:"allowed_policies"`
+	// Preconditions are the results from the last time a job left the queue.
+	Preconditions []PreconditionResult `json:"preconditions"`
+	// Drain is the progress of a graceful stop.
+	Drain Drain `json:"drain"`
+	Drift
+	// ChefVersions is the version of chef-client and when it changed.
+	ChefVersions
 }
 
 // AppStatusReader will show how to use the AppStatusHandler
@@ -41,8 +82,9 @@ type AppStatusReader interface {
 
 // NewAppStatus - creates a new appStatusHandler struct. It requires a version
 // number to be passed in. This is because the version is held outside of
-// internalstate.
-func NewAppStatus(version string, currentState *StateTable, logger logs.SysLogger) *AppStatusHandler {
+// internalstate. chefClientBinary is asked for the version of chef. If it is empty the
+// version is not looked up.
+func NewAppStatus(version, chefClientBinary string, currentState *StateTable, logger logs.SysLogger) *AppStatusHandler {
 	logs.DebugMessage("NewAppStatus()")
 	hn, err := os.Hostname()
 	if err != nil {
@@ -51,6 +93,9 @@ func NewAppStatus(version string, currentState *StateTable, logger logs.SysLogge
 	}
 	appStatus := new(AppStatusHandler)
 	appStatus.logger = logger
+	appStatus.currentState = currentState
+	appStatus.chefClientBinary = chefClientBinary
+	appStatus.probe = chefVersion
 	appStatus.state = &AppStatus{
 		ServiceName: "ChefWaiter",
 		Version:     version,
@@ -58,21 +103,58 @@ func NewAppStatus(version string, currentState *StateTable, logger logs.SysLogge
 		HostName:    hn,
 	}
 	appStatus.setTime()
-	go appStatus.reconcileChefVersion()
-	go appStatus.maintenanceMode(currentState)
-	go appStatus.lastRun(currentState)
-	go appStatus.locked(currentState)
+	if chefClientBinary != "" {
+		go appStatus.reconcileChefVersion()
+	}
 	return appStatus
 }
 
+// SetHealth is used to include the health checks in the healthy value on the status page.
+func (as *AppStatusHandler) SetHealth(health HealthReader) {
+	as.Lock()
+	defer as.Unlock()
+	as.health = health
+}
+
+// SetQueue is used to show the depth of the run queue on the status page.
+func (as *AppStatusHandler) SetQueue(queue QueueReader) {
+	as.Lock()
+	defer as.Unlock()
+	as.queue = queue
+}
+
+// SetPreconditions is used to show the last precondition results on the status page.
+func (as *AppStatusHandler) SetPreconditions(preconditions PreconditionReader) {
+	as.Lock()
+	defer as.Unlock()
+	as.precondition = preconditions
+}
+
+// SetDrain is used to show the progress of a graceful stop on the status page.
+func (as *AppStatusHandler) SetDrain(drain DrainReader) {
+	as.Lock()
+	defer as.Unlock()
+	as.drain = drain
+}
+
 // SetWhiteListing is used to display the whitelist out to the status page.
 func (as *AppStatusHandler) SetWhiteListing(enabled bool, currentList []string) {
+	as.Lock()
+	defer as.Unlock()
 	as.state.WhiteListsEnabled = enabled
+	as.state.WhiteList = nil
 	if enabled {
 		as.state.WhiteList = currentList
 	}
 }
 
+// SetAllowedPolicies is used to display the policies that custom runs can use on the status page.
+func (as *AppStatusHandler) SetAllowedPolicies(policies []Policy) {
+	as.Lock()
+	defer as.Unlock()
+	as.state.AllowedPolicies = policies
+}
+
 // setTime - is used to set the time of the state in AppStatusHandler
 func (as *AppStatusHandler) setTime() {
 	as.Lock()
@@ -85,83 +167,71 @@ func (as *AppStatusHandler) setTime() {
 }
 
 // This is a looping function that will try to update chef waiter status with the version of chef.
+// Runs can upgrade chef so the version is also checked when a run finishes.
 func (as *AppStatusHandler) reconcileChefVersion() {
-	// do it now and then again every 15 mins.s
-	as.updateChefVersion()
-	ticker := time.NewTicker(time.Minute * 15)
+	sub := events.Subscribe(10)
+	defer events.Unsubscribe(sub)
+	timer := time.NewTimer(as.updateChefVersion())
 	for {
 		select {
-		case <-ticker.C:
-			as.updateChefVersion()
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if event.Type != events.RunFinished {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
 		}
+		timer.Reset(as.updateChefVersion())
 	}
 }
 
-func (as *AppStatusHandler) updateChefVersion() {
-	version, err := chefVersion()
+// updateChefVersion asks chef-client for its version and records it. The status is unhealthy
+// until chef-client answers. It returns how long to wait before asking again.
+func (as *AppStatusHandler) updateChefVersion() time.Duration {
+	version, err := as.probe(as.chefClientBinary)
 	as.Lock()
 	defer as.Unlock()
 	if err != nil {
-		as.logger.Error("Failed to determine chef version.")
+		as.logger.Errorf("Failed to determine chef version. Error: %s", err)
 		as.state.Healthy = false
-		return
+		return chefVersionRetry
 	}
-	as.state.ChefVersion = version
-}
-
-func (as *AppStatusHandler) maintenanceMode(cs *StateTable) {
-	as.Lock()
-	// Do it once then loop
-	as.state.InMaintenance = cs.InMaintenceMode()
-	as.Unlock()
-	ticker := time.NewTicker(time.Millisecond * 750)
-	for {
-		select {
-		case <-ticker.C:
-			as.Lock()
-			as.state.InMaintenance = cs.InMaintenceMode()
-			as.Unlock()
-		}
+	if !as.state.Healthy {
+		as.logger.Infof("chef-client reported version %s. The status is healthy again.", version)
 	}
-}
-
-func (as *AppStatusHandler) lastRun(cs *StateTable) {
-	// Do it once then loop
-	as.Lock()
-	as.state.LastRunGUID = cs.ReadLastRunGUID()
-	as.Unlock()
-	ticker := time.NewTicker(time.Second * 10)
-	for {
-		select {
-		case <-ticker.C:
-			as.Lock()
-			as.state.LastRunGUID = cs.ReadLastRunGUID()
-			as.Unlock()
-		}
-	}
-}
-
-func (as *AppStatusHandler) locked(cs *StateTable) {
-	// Do it once then loop
-	lockedFunc := func() {
-		as.Lock()
-		as.state.Locked = cs.ReadRunLock()
-		as.Unlock()
-	}
-
-	lockedFunc()
-	ticker := time.NewTicker(time.Second * 10)
-	for {
-		select {
-		case <-ticker.C:
-			lockedFunc()
-		}
+	as.state.Healthy = true
+	if as.currentState.RecordChefVersion(version, time.Now().Unix()) {
+		as.logger.Infof("chef-client version is now %s", version)
 	}
+	return chefVersionInterval
 }
 
 // JSONEncoded returns the JSON encoded state with an error if anything goes wrong.
 func (as *AppStatusHandler) JSONEncoded() ([]byte, error) {
 	as.RLock()
 	defer as.RUnlock()
-	return json.MarshalIndent(as.state, "", "  ")
+	status := *as.state
+	as.currentState.readAppStatus(&status, time.Now().Unix())
+	if as.queue != nil {
+		status.QueueDepth = as.queue.QueueDepth()
+	}
+	if as.health != nil {
+		status.Healthy = status.Healthy && as.health.Healthy()
+	}
+	if status.AllowedPolicies == nil {
+		status.AllowedPolicies = []Policy{}
+	}
+	status.Preconditions = []PreconditionResult{}
+	if as.precondition != nil {
+		status.Preconditions = as.precondition.PreconditionResults()
+	}
+	if as.drain != nil {
+		status.Drain = as.drain.DrainStatus()
+	}
+	return json.MarshalIndent(status, "", "  ")
 }
@@ -1,8 +1,10 @@
 package internalstate
 
 import (
+	"encoding/json"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/morfien101/chef-waiter/logs"
 )
@@ -21,7 +23,7 @@ func TestWhiteList(t *testing.T) {
 		Status: make(map[string]*JobDetails),
 	}
 	logger := logs.NewFakeLogger(false)
-	appState := NewAppStatus("0.0.1", stateTableMock, logger)
+	appState := NewAppStatus("0.0.1", "", stateTableMock, logger)
 	appState.SetWhiteListing(fc.whitelist, fc.whitelistItems)
 	b, err := appState.JSONEncoded()
 	if err != nil {
@@ -48,3 +50,64 @@ func TestWhiteList(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type fakeQueue int
+
+func (q fakeQueue) QueueDepth() int {
+	return int(q)
+}
+
+func TestAppStatusIsCurrent(t *testing.T) {
+	logger := logs.NewFakeLogger(false)
+	stateTable := &StateTable{
+		Status:           make(map[string]*JobDetails),
+		PeriodicRuns:     true,
+		ChefRunTimer:     30 * 60,
+		LastRunStartTime: time.Now().Unix(),
+		logger:           logger,
+	}
+	stateTable.Status["running-guid"] = &JobDetails{Status: "running"}
+	stateTable.Status["queued-guid"] = &JobDetails{Status: "registered"}
+	appState := NewAppStatus("0.0.1", "", stateTable, logger)
+	appState.SetQueue(fakeQueue(1))
+
+	read := func() AppStatus {
+		b, err := appState.JSONEncoded()
+		if err != nil {
+			t.Fatalf("Failed to JSON encode app state, Error: %s", err)
+		}
+		status := AppStatus{}
+		if err := json.Unmarshal(b, &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	status := read()
+	if status.RunningGUID != "running-guid" {
+		t.Errorf("Running guid is wrong. Got: %q", status.RunningGUID)
+	}
+	if status.QueueDepth != 1 {
+		t.Errorf("Queue depth is wrong. Got: %d", status.QueueDepth)
+	}
+	if want := stateTable.LastRunStartTime + stateTable.ChefRunTimer; status.NextRunTime != want {
+		t.Errorf("Next run time is wrong. Got: %d, Want: %d", status.NextRunTime, want)
+	}
+
+	// Changes must show straight away.
+	stateTable.LockRuns(true)
+	maintenanceEnd := time.Now().Unix() + 3600
+	stateTable.WriteMaintenanceTimeEnd(maintenanceEnd)
+	status = read()
+	if !status.Locked || !status.InMaintenance {
+		t.Errorf("Status should be locked and in maintenance. Got: %+v", status)
+	}
+	if status.NextRunTime != 0 {
+		t.Errorf("Next run time should be 0 when locked. Got: %d", status.NextRunTime)
+	}
+
+	stateTable.LockRuns(false)
+	if status = read(); status.NextRunTime != maintenanceEnd {
+		t.Errorf("Next run time should be the end of maintenance. Got: %d, Want: %d", status.NextRunTime, maintenanceEnd)
+	}
+}
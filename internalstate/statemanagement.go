@@ -4,9 +4,11 @@ import (
 	"encoding/gob"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/morfien101/chef-waiter/config"
 	"github.com/morfien101/chef-waiter/logs"
 	"github.com/morfien101/chef-waiter/metrics"
 )
@@ -92,7 +94,7 @@ func (st *StateTable) SaveStateToDisk() error {
 // readStateFromDisk - Will read the state from the disk if the file is there.
 // It will then pass it to the linter and then put the state in the StateTable.
 // It will be a copy of the current state from the reboot.
-func readStateFromDisk(stateFile string, logger logs.SysLogger) (*StateTable, error) {
+func readStateFromDisk(stateFile string, config config.Config, logger logs.SysLogger) (*StateTable, error) {
 	// Open the file and check if it exists.
 	f, err := os.Open(stateFile)
 	if err != nil {
@@ -107,7 +109,7 @@ func readStateFromDisk(stateFile string, logger logs.SysLogger) (*StateTable, er
 		return nil, err
 	}
 	// Pass the data to the linter to check for running jobs.
-	data.Status = lintState(data.Status)
+	data.Status = lintState(data.Status, config, time.Now().Unix())
 	// We need to inject a mutex into it as it is not exported when we encode it to disk
 	data.mutexLock = sync.RWMutex{}
 	// We need to tell the state where it is, it could have changed.
@@ -117,14 +119,60 @@ func readStateFromDisk(stateFile string, logger logs.SysLogger) (*StateTable, er
 	return data, nil
 }
 
-func lintState(statusList map[string]*JobDetails) map[string]*JobDetails {
+// lintState fixes the status of jobs that were cut short by a restart.
+// Running jobs become unknown. Registered jobs become abandoned unless resuming is turned on,
+// in which case jobs that are young enough and still allowed are kept and marked as resumed.
+func lintState(statusList map[string]*JobDetails, config config.Config, now int64) map[string]*JobDetails {
 	for k := range statusList {
 		if statusList[k].Status == "running" {
 			statusList[k].Status = "unknown"
 		}
 		if statusList[k].Status == "registered" {
-			statusList[k].Status = "abandoned"
+			if canResume(k, statusList[k], config, now) {
+				statusList[k].Resumed = true
+			} else {
+				statusList[k].Status = "abandoned"
+			}
 		}
 	}
 	return statusList
 }
+
+// canResume checks a registered job against the resume settings.
+func canResume(guid string, job *JobDetails, config config.Config, now int64) bool {
+	if !config.ResumeQueuedRuns() {
+		return false
+	}
+	if maxAge := config.ResumeMaxAge() * 60; maxAge > 0 && now-job.RegisteredTime > maxAge {
+		return false
+	}
+	// The bundle could have been cleaned up while we were down. Retries use the bundle of the first job.
+	if job.BundleSHA256 != "" {
+		root := guid
+		if job.ParentGUID != "" {
+			root = job.ParentGUID
+		}
+		if _, err := os.Stat(filepath.Join(config.BundleLocation(), root)); err != nil {
+			return false
+		}
+	}
+	// Policy jobs are checked against the allowed policies, which could also have changed.
+	if job.Policy.IsSet() {
+		for _, allowed := range config.AllowedPolicies() {
+			if job.PolicyGroup == allowed.PolicyGroup && job.PolicyName == allowed.PolicyName {
+				return true
+			}
+		}
+		return false
+	}
+	// The whitelist could have changed while we were down.
+	if job.CustomRun && config.WhiteListCustomRuns() && len(config.AllowedCustomRuns()) > 0 {
+		for _, allowed := range config.AllowedCustomRuns() {
+			if job.CustomRunString == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
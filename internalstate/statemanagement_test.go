@@ -1,8 +1,12 @@
 package internalstate
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/morfien101/chef-waiter/config"
 	"github.com/morfien101/chef-waiter/logs"
 	uuid "github.com/satori/go.uuid"
 )
@@ -75,3 +79,88 @@ func TestReadAllJobs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestLintState(t *testing.T) {
+	now := int64(100000)
+	newState := func() map[string]*JobDetails {
+		return map[string]*JobDetails{
+			"running": &JobDetails{Status: "running", RegisteredTime: now},
+			"fresh":   &JobDetails{Status: "registered", RegisteredTime: now - 60},
+			"old":     &JobDetails{Status: "registered", RegisteredTime: now - 7200},
+			"custom":  &JobDetails{Status: "registered", RegisteredTime: now, CustomRun: true, CustomRunString: "recipe[gone]"},
+			"done":    &JobDetails{Status: "complete", RegisteredTime: now},
+		}
+	}
+
+	status := lintState(newState(), &config.ValuesContainer{}, now)
+	for guid, want := range map[string]string{"running": "unknown", "fresh": "abandoned", "old": "abandoned", "custom": "abandoned", "done": "complete"} {
+		if status[guid].Status != want || status[guid].Resumed {
+			t.Errorf("Without resuming %s should be %s. Got: %s, resumed: %v", guid, want, status[guid].Status, status[guid].Resumed)
+		}
+	}
+
+	resumeConfig := &config.ValuesContainer{
+		InternalResumeQueuedRuns:    true,
+		InternalResumeMaxAge:        60,
+		InternalWhiteListCustomRuns: true,
+		InternalAllowedCustomRuns:   []string{"recipe[kept]"},
+	}
+	status = lintState(newState(), resumeConfig, now)
+	for guid, want := range map[string]string{"running": "unknown", "fresh": "registered", "old": "abandoned", "custom": "abandoned"} {
+		if status[guid].Status != want {
+			t.Errorf("With resuming %s should be %s. Got: %s", guid, want, status[guid].Status)
+		}
+	}
+	if !status["fresh"].Resumed {
+		t.Error("Resumed jobs should be marked as resumed")
+	}
+}
+
+func TestLintStatePolicyJobs(t *testing.T) {
+	now := int64(100000)
+	newState := func() map[string]*JobDetails {
+		policy := func(group, name string) Policy { return Policy{PolicyGroup: group, PolicyName: name} }
+		return map[string]*JobDetails{
+			"allowed": &JobDetails{Status: "registered", RegisteredTime: now, CustomRun: true, Policy: policy("canary", "webserver")},
+			"removed": &JobDetails{Status: "registered", RegisteredTime: now, CustomRun: true, Policy: policy("prod", "webserver")},
+		}
+	}
+	resumeConfig := &config.ValuesContainer{
+		InternalResumeQueuedRuns:    true,
+		InternalAllowedPolicies:     []config.PolicyConfig{{PolicyGroup: "canary", PolicyName: "webserver"}},
+		InternalWhiteListCustomRuns: true,
+		InternalAllowedCustomRuns:   []string{"recipe[kept]"},
+	}
+	// The run list whitelist does not apply to policy jobs, so it makes no difference.
+	for _, whitelist := range []bool{true, false} {
+		resumeConfig.InternalWhiteListCustomRuns = whitelist
+		status := lintState(newState(), resumeConfig, now)
+		for guid, want := range map[string]string{"allowed": "registered", "removed": "abandoned"} {
+			if status[guid].Status != want {
+				t.Errorf("With the whitelist set to %t %s should be %s. Got: %s", whitelist, guid, want, status[guid].Status)
+			}
+		}
+	}
+}
+
+func TestLintStateBundleJobs(t *testing.T) {
+	bundles, err := ioutil.TempDir("", "bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundles)
+	if err := os.Mkdir(filepath.Join(bundles, "kept"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := int64(100000)
+	status := lintState(map[string]*JobDetails{
+		"kept":    &JobDetails{Status: "registered", RegisteredTime: now, BundleSHA256: "abc"},
+		"retry":   &JobDetails{Status: "registered", RegisteredTime: now, BundleSHA256: "abc", ParentGUID: "kept", Attempt: 2},
+		"cleaned": &JobDetails{Status: "registered", RegisteredTime: now, BundleSHA256: "abc"},
+	}, &config.ValuesContainer{InternalResumeQueuedRuns: true, InternalBundleLocation: bundles}, now)
+	for guid, want := range map[string]string{"kept": "registered", "retry": "registered", "cleaned": "abandoned"} {
+		if status[guid].Status != want {
+			t.Errorf("%s should be %s. Got: %s", guid, want, status[guid].Status)
+		}
+	}
+}
@@ -11,22 +11,47 @@ import (
 
 	"github.com/morfien101/chef-waiter/cheflogs"
 	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/events"
 	"github.com/morfien101/chef-waiter/logs"
 )
 
 // JobDetails - Holds data about individual runs.
-// Status can be one of the following: registered, running, complete, unknown, abandoned
+// Status can be one of the following: registered, running, complete, failed, aborted, skipped, terminated, unknown, abandoned
+// aborted: is set if a pre run hook failed and stopped chef from running.
+// terminated: is set if the job was still running when the drain timeout ran out on a graceful stop.
+// skipped: is set if a precondition failed when the job left the queue. SkipReason says why.
 // unknown: is set if the data is read from a static state file on start up and the
 // job was previously set to running.
 // abandoned: is set if the data is read from a static state file on start up and the
-// job was previously set to registered.
+// job was previously set to registered, unless it was resumed.
+// Resumed is true for registered jobs that were put back in the queue after a restart.
+// RunStartTime and RunEndTime are the epoch times that chef started and finished.
+// Attempt starts at 1. Retries of a failed job have the next attempt and ParentGUID is the
+// guid of the first job.
+// RunMetadata is who requested the job and why. Requests that join the job don't change it.
+// Hooks are the results of the pre and post run hooks in the order they ran.
+// ChefVersion is the version of chef-client that was known when the job started running.
 type JobDetails struct {
-	Status          string `json:"status"`
-	ExitCode        int    `json:"exitcode"`
-	RegisteredTime  int64  `json:"starttime"`
-	OnDemand        bool   `json:"ondemand"`
-	CustomRun       bool   `json:"custom_run"`
-	CustomRunString string `json:"custom_run_string"`
+	Status          string       `json:"status"`
+	ExitCode        int          `json:"exitcode"`
+	RegisteredTime  int64        `json:"starttime"`
+	OnDemand        bool         `json:"ondemand"`
+	CustomRun       bool         `json:"custom_run"`
+	CustomRunString string       `json:"custom_run_string"`
+	Priority        string       `json:"priority"`
+	RunStartTime    int64        `json:"run_start_time"`
+	RunEndTime      int64        `json:"run_end_time"`
+	Resumed         bool         `json:"resumed"`
+	ParentGUID      string       `json:"parent_guid"`
+	Attempt         int          `json:"attempt"`
+	Hooks           []HookResult `json:"hooks,omitempty"`
+	SkipReason      string       `json:"skip_reason,omitempty"`
+	BundleSHA256    string       `json:"bundle_sha256,omitempty"`
+	ChefVersion     string       `json:"chef_version,omitempty"`
+	Policy
+	RunMetadata
+	// QueuedTime is when the job was registered in nanoseconds. It keeps the queue order across restarts.
+	QueuedTime int64 `json:"-"`
 }
 
 // TODO - Switch to using this for status of runs.
@@ -51,7 +76,10 @@ type StateTable struct {
 	StateTableSize     int
 	MaintenanceTimeEnd int64
 	Locked             bool
+	LockReason         string
 	StateFilePath      string
+	Drift              Drift
+	ChefVersions       ChefVersions
 
 	chefLogsWorker cheflogs.WorkerWriter
 	logger         logs.SysLogger
@@ -75,24 +103,41 @@ type StateTableReader interface {
 	ReadPeriodicRuns() bool
 	ReadLastRunGUID() string
 	ReadAllJobs() map[string]JobDetails
+	ReadJob(string) (JobDetails, bool)
 	ReadRunLock() bool
+	ReadLockReason() string
 	InMaintenceMode() bool
 	ReadMaintenanceTimeEnd() int64
+	ReadDrift() Drift
+	ReadChefVersions() ChefVersions
 }
 
 // StateTableWriter describes the functions to write data to the state table.
 type StateTableWriter interface {
 	Add(string, bool)
-	RegisterRun(bool, bool, string) (bool, string)
+	RegisterRun(bool, bool, string, Policy, Coalesce) (bool, string)
+	RegisterNewRun(string, bool, bool, string, Policy)
+	RegisterRetry(string) (string, bool)
 	UpdateStatus(string, string)
 	UpdateExitCode(string, int)
+	UpdatePriority(string, string)
+	UpdateMetadata(string, RunMetadata)
+	UpdateRunStartTime(string, int64)
+	UpdateRunEndTime(string, int64)
+	UpdateHookResult(string, HookResult)
+	UpdateSkipReason(string, string)
+	UpdateBundle(string, string)
 	RemoveState(string)
+	Delete(string)
 	UpdatelastRunStartTime(int64)
 	WriteChefRunTimer(int64)
 	WritePeriodicRuns(bool)
 	WriteLastRunGUID(string)
 	WriteMaintenanceTimeEnd(int64)
 	LockRuns(bool)
+	LockRunsWithReason(string)
+	WriteDrifted(bool)
+	RecordChefVersion(string, int64) bool
 }
 
 // New will initialize a new state table either empty or with the saved state if found.
@@ -101,7 +146,7 @@ func New(
 	chefLogsWorker cheflogs.WorkerWriter,
 	logger logs.SysLogger,
 ) *StateTable {
-	diskState, err := readStateFromDisk(getStatePath(config.StateFileLocation(), statefile), logger)
+	diskState, err := readStateFromDisk(getStatePath(config.StateFileLocation(), statefile), config, logger)
 	if err != nil {
 		logger.Warningf("There was an error reading the state from disk. Creating a new internal state. The error was: %s", err)
 		// initialize the globals that we need.
@@ -182,34 +227,88 @@ func (st *StateTable) flushToDisk(sf io.Writer) error {
 func (st *StateTable) Add(id string, ondemand bool) {
 	st.lock()
 	defer st.unlock()
+	now := time.Now()
 	st.Status[id] = &JobDetails{
 		Status:         "registered",
 		ExitCode:       99,
-		RegisteredTime: time.Now().Unix(),
+		RegisteredTime: now.Unix(),
 		OnDemand:       ondemand,
+		Attempt:        1,
+		QueuedTime:     now.UnixNano(),
 	}
+	st.publishJob(events.RunRegistered, id)
 }
 
 // AddCustom - Allows the caller to add a guid to the state table with details of a
 // custom job.
 func (st *StateTable) AddCustom(id string, customString string) {
+	st.addCustom(id, customString, Policy{})
+}
+
+// addCustom adds a custom job that can use a policy.
+func (st *StateTable) addCustom(id string, customString string, policy Policy) {
 	st.lock()
 	defer st.unlock()
+	now := time.Now()
 	st.Status[id] = &JobDetails{
 		Status:          "registered",
 		ExitCode:        99,
-		RegisteredTime:  time.Now().Unix(),
+		RegisteredTime:  now.Unix(),
 		OnDemand:        true,
 		CustomRun:       true,
 		CustomRunString: customString,
+		Policy:          policy,
+		Attempt:         1,
+		QueuedTime:      now.UnixNano(),
+	}
+	st.publishJob(events.RunRegistered, id)
+}
+
+// RegisterRetry - Adds a job that retries the failed job. It has the same type, priority and
+// metadata with the next attempt. Retries are never joined to queued jobs.
+// It returns false if the failed job is not in the state table.
+func (st *StateTable) RegisterRetry(failedGUID string) (guid string, ok bool) {
+	st.lock()
+	defer st.unlock()
+	failed, ok := st.Status[failedGUID]
+	if !ok {
+		return "", false
+	}
+	parent := failed.ParentGUID
+	if parent == "" {
+		parent = failedGUID
 	}
+	attempt := failed.Attempt
+	if attempt < 1 {
+		attempt = 1
+	}
+	guid = uuid.Must(uuid.NewV4()).String()
+	now := time.Now()
+	st.Status[guid] = &JobDetails{
+		Status:          "registered",
+		ExitCode:        99,
+		RegisteredTime:  now.Unix(),
+		OnDemand:        failed.OnDemand,
+		CustomRun:       failed.CustomRun,
+		CustomRunString: failed.CustomRunString,
+		Priority:        failed.Priority,
+		ParentGUID:      parent,
+		Attempt:         attempt + 1,
+		BundleSHA256:    failed.BundleSHA256,
+		Policy:          failed.Policy,
+		RunMetadata:     failed.RunMetadata.copy(),
+		QueuedTime:      now.UnixNano(),
+	}
+	st.publishJob(events.RunRegistered, guid)
+	return guid, true
 }
 
 // RegisterRun - Allows us to check if a on demand run is registered and to register one
 // if there is not. It will return a bool true to signal that a new run was created and also
 // return a string of the guid that this run is associated with. The run could be a copy
-// of a previos run that is still queuing to run.
-func (st *StateTable) RegisterRun(onDemand, customRun bool, customString string) (ok bool, guid string) {
+// of a previos run that is still queuing to run if the coalesce policy allows it.
+// Custom runs can use a policy and only join jobs with the same policy.
+func (st *StateTable) RegisterRun(onDemand, customRun bool, customString string, policy Policy, coalesce Coalesce) (ok bool, guid string) {
 	// check if there is a on demand chef run already waiting.
 	// if so collect the guid
 	// else create a run and make a guid
@@ -217,14 +316,17 @@ func (st *StateTable) RegisterRun(onDemand, customRun bool, customString string)
 	st.rLock()
 	for id := range st.Status {
 		i := st.Status[id]
-		if i.Status == "registered" {
-			// Determin if i is also a custom run if so match the strings.
-			if customRun && i.CustomRun && i.CustomRunString == customString {
-				guid = id
+		// Jobs that run an uploaded bundle are never joined.
+		if i.Status == "registered" && i.BundleSHA256 == "" && coalesce.allows(i) {
+			// Custom runs only match custom runs with the same run list and policy.
+			if customRun {
+				if i.CustomRun && i.CustomRunString == customString && i.Policy == policy {
+					guid = id
+				}
 			} else {
 				// If its not a custom run then it can either be onDemand or periodic.
 				// Either way if the values match then return a guid.
-				if i.OnDemand == onDemand {
+				if !i.CustomRun && i.OnDemand == onDemand {
 					guid = id
 				}
 			}
@@ -235,23 +337,43 @@ func (st *StateTable) RegisterRun(onDemand, customRun bool, customString string)
 	// If the guid has not been set then get one.
 	if len(guid) < 1 {
 		guid = uuid.Must(uuid.NewV4()).String()
-		if customRun {
-			st.AddCustom(guid, customString)
-		} else {
-			st.Add(guid, onDemand)
-		}
+		st.RegisterNewRun(guid, onDemand, customRun, customString, policy)
 		return true, guid
 	}
 	logs.DebugMessage(fmt.Sprintf("Return a queued guid: %s", guid))
 	return false, guid
 }
 
+// RegisterNewRun adds a run with a guid that the caller made. It is for runs that are
+// never joined to queued jobs and need their guid before they are registered.
+func (st *StateTable) RegisterNewRun(guid string, onDemand, customRun bool, customString string, policy Policy) {
+	if customRun {
+		st.addCustom(guid, customString, policy)
+		return
+	}
+	st.Add(guid, onDemand)
+}
+
 // UpdateStatus - Updates the states of an ID with the given status string
 func (st *StateTable) UpdateStatus(guid string, state string) {
 	logs.DebugMessage(fmt.Sprintf("UpdateStatus(%s,%s)", guid, state))
 	st.lock()
 	defer st.unlock()
 	st.Status[guid].Status = state
+	switch state {
+	case "registered":
+	case "running":
+		st.Status[guid].ChefVersion = st.ChefVersions.Current
+		st.publishJob(events.RunStarted, guid)
+	default:
+		st.recordPeriodicResult(st.Status[guid], time.Now().Unix())
+		st.publishJob(events.RunFinished, guid)
+	}
+}
+
+// publishJob sends an event with a copy of the job. The caller must hold the lock.
+func (st *StateTable) publishJob(eventType, guid string) {
+	events.Publish(eventType, guid, *st.Status[guid])
 }
 
 // UpdateExitCode - Updates the ExitCode of an ID with the given int.
@@ -262,6 +384,35 @@ func (st *StateTable) UpdateExitCode(guid string, code int) {
 	st.Status[guid].ExitCode = code
 }
 
+// UpdatePriority - Updates the run queue priority of an ID.
+func (st *StateTable) UpdatePriority(guid string, priority string) {
+	logs.DebugMessage(fmt.Sprintf("UpdatePriority(%s,%s)", guid, priority))
+	st.lock()
+	defer st.unlock()
+	st.Status[guid].Priority = priority
+}
+
+// UpdateMetadata - Records who requested an ID and why.
+func (st *StateTable) UpdateMetadata(guid string, metadata RunMetadata) {
+	st.lock()
+	defer st.unlock()
+	st.Status[guid].RunMetadata = metadata.copy()
+}
+
+// UpdateRunStartTime - Records the epoch time that chef started for an ID.
+func (st *StateTable) UpdateRunStartTime(guid string, t int64) {
+	st.lock()
+	defer st.unlock()
+	st.Status[guid].RunStartTime = t
+}
+
+// UpdateRunEndTime - Records the epoch time that chef finished for an ID.
+func (st *StateTable) UpdateRunEndTime(guid string, t int64) {
+	st.lock()
+	defer st.unlock()
+	st.Status[guid].RunEndTime = t
+}
+
 // IsDemandJob will return the value of a JobDetails OnDemand value. This
 // will let the caller know if it is a on demand job.
 func (st *StateTable) IsDemandJob(guid string) bool {
@@ -312,6 +463,14 @@ func (st *StateTable) RemoveState(guid string) {
 	}
 }
 
+// Delete - removes a guid from the Statetable regardless of its status.
+// Used for jobs that were registered but could never be queued.
+func (st *StateTable) Delete(guid string) {
+	st.lock()
+	defer st.unlock()
+	delete(st.Status, guid)
+}
+
 // GetAllStateTimes - Returns all the status guids and times
 func (st *StateTable) GetAllStateTimes() (statusMap map[string]int64) {
 	st.rLock()
@@ -358,6 +517,7 @@ func (st *StateTable) WriteChefRunTimer(i int64) {
 	defer st.unlock()
 	st.ChefRunTimer = i * 60
 	st.logger.Infof("Chef periodic interval changed to every %d minutes.", i)
+	events.Publish(events.IntervalChanged, "", map[string]int64{"minutes": i})
 }
 
 // ReadPeriodicRuns will return the value of PeriodicRuns.
@@ -377,6 +537,7 @@ func (st *StateTable) WritePeriodicRuns(enable bool) {
 		logs.DebugMessage("chef run disabled.")
 	}
 	st.PeriodicRuns = enable
+	events.Publish(events.PeriodicChanged, "", map[string]bool{"chef_runs_enabled": enable})
 }
 
 func (st *StateTable) readStateTableSize() int {
@@ -403,6 +564,17 @@ func (st *StateTable) ReadAllJobs() map[string]JobDetails {
 	return retVal
 }
 
+// ReadJob will return a copy of the job for the guid. It returns false if the guid is not known.
+func (st *StateTable) ReadJob(guid string) (JobDetails, bool) {
+	st.rLock()
+	defer st.rUnlock()
+	job, ok := st.Status[guid]
+	if !ok {
+		return JobDetails{}, false
+	}
+	return *job, true
+}
+
 // WriteLastRunGUID will write to the state table the guid passed in.
 func (st *StateTable) WriteLastRunGUID(guid string) {
 	st.lock()
@@ -415,6 +587,10 @@ func (st *StateTable) WriteMaintenanceTimeEnd(epoch int64) {
 	st.lock()
 	defer st.unlock()
 	st.MaintenanceTimeEnd = epoch
+	events.Publish(events.MaintenanceChanged, "", map[string]interface{}{
+		"end_time_epoch": epoch,
+		"in_maintenance": time.Now().Unix() < epoch,
+	})
 }
 
 // ReadMaintenanceTimeEnd will return the value of MaintenanceTimeEnd. It is an epoch represented as an int64
@@ -436,7 +612,8 @@ func (st *StateTable) readStateFilePath() string {
 	return st.StateFilePath
 }
 
-// LockRuns will lock the chef waiter to stop accepting runs
+// LockRuns will lock the chef waiter to stop accepting runs.
+// Unlocking will also clear the lock reason.
 func (st *StateTable) LockRuns(lock bool) {
 	st.lock()
 	defer st.unlock()
@@ -446,7 +623,24 @@ func (st *StateTable) LockRuns(lock bool) {
 	} else {
 		st.logger.Info("Chefwaiter has just been unlocked. New runs can now be scheduled.")
 		st.Locked = false
+		st.LockReason = ""
 	}
+	st.publishLock()
+}
+
+// LockRunsWithReason will lock the chef waiter and record why it was locked.
+func (st *StateTable) LockRunsWithReason(reason string) {
+	st.lock()
+	defer st.unlock()
+	st.logger.Infof("Chefwaiter has just been locked. No new runs can be scheduled. Reason: %s", reason)
+	st.Locked = true
+	st.LockReason = reason
+	st.publishLock()
+}
+
+// publishLock sends an event with the state of the lock. The caller must hold the lock.
+func (st *StateTable) publishLock() {
+	events.Publish(events.LockChanged, "", map[string]interface{}{"locked": st.Locked, "reason": st.LockReason})
 }
 
 // ReadRunLock will return the value of the state tables Lock value.
@@ -455,3 +649,47 @@ func (st *StateTable) ReadRunLock() bool {
 	defer st.rUnlock()
 	return st.Locked
 }
+
+// readAppStatus fills in the parts of the app status that come from the state table.
+// They are all read under one lock so that they agree with each other.
+func (st *StateTable) readAppStatus(status *AppStatus, now int64) {
+	st.rLock()
+	defer st.rUnlock()
+	status.InMaintenance = now < st.MaintenanceTimeEnd
+	status.LastRunGUID = st.LastRunGUID
+	status.Locked = st.Locked
+	status.RunningGUID = ""
+	for guid, job := range st.Status {
+		if job.Status == "running" {
+			status.RunningGUID = guid
+			break
+		}
+	}
+	status.NextRunTime = st.nextRunTime(now)
+	status.Drift = st.Drift
+	status.ChefVersions = st.ChefVersions.copy()
+}
+
+// nextRunTime returns the epoch time that the next periodic run is due, or 0 if periodic
+// runs are turned off or locked. Runs that are overdue start on the next check, which
+// happens every minute. The caller must hold the lock.
+func (st *StateTable) nextRunTime(now int64) int64 {
+	if !st.PeriodicRuns || st.Locked {
+		return 0
+	}
+	next := st.LastRunStartTime + st.ChefRunTimer
+	if next < st.MaintenanceTimeEnd {
+		next = st.MaintenanceTimeEnd
+	}
+	if next < now {
+		next = now
+	}
+	return next
+}
+
+// ReadLockReason will return the reason given when the lock was set.
+func (st *StateTable) ReadLockReason() string {
+	st.rLock()
+	defer st.rUnlock()
+	return st.LockReason
+}
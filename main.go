@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 
+	"github.com/morfien101/chef-waiter/aggregator"
+	"github.com/morfien101/chef-waiter/cli"
 	"github.com/morfien101/chef-waiter/logs"
 	"github.com/morfien101/service"
 )
@@ -23,6 +25,15 @@ var (
 )
 
 func main() {
+	// Client sub commands talk to a chef waiter and don't start the service.
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	// The aggregator manages a fleet of chef waiters and runs in the foreground.
+	if len(os.Args) > 1 && os.Args[1] == "aggregator" {
+		os.Exit(aggregator.Run(os.Args[2:], os.Stderr))
+	}
+
 	// Deal with flags
 	digestFlags()
 
@@ -82,6 +93,8 @@ func digestFlags() {
 
 	if *helpFlag {
 		flag.PrintDefaults()
+		cli.Usage(os.Stdout)
+		fmt.Println("Use 'chefwaiter aggregator -h' to see the flags for the fleet aggregator.")
 		os.Exit(0)
 	}
 }
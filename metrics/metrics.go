@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"net"
 	"time"
 
 	statsd "github.com/morfien101/go-statsd"
@@ -8,6 +9,7 @@ import (
 
 var (
 	on        = false
+	host      string
 	stdClient *statsd.Client
 )
 
@@ -23,9 +25,20 @@ func Setup(stastdHost string, tagsInput map[string]string) {
 			convertTags(tagsInput)...,
 		),
 	)
+	host = stastdHost
 	on = true
 }
 
+// Reachable returns an error if the address of the statsd server can't be resolved.
+// Statsd uses UDP so this is as far as we can check. It returns nil if metrics are off.
+func Reachable() error {
+	if !on {
+		return nil
+	}
+	_, err := net.ResolveUDPAddr("udp", host)
+	return err
+}
+
 // Shutdown will stop the metric client
 func Shutdown() {
 	stdClient.Close()
@@ -0,0 +1,234 @@
+// Package nodecache reads what chef leaves in its cache directory. The node object is
+// cut down to its run list, environment, policy and the attributes that have been allowed
+// so that secrets in the other attributes are never served. The failed run data is cut
+// down to the error and the resources that were updated before the run failed.
+package nodecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FailedRunFile is the file chef writes to its cache directory when a run fails.
+const FailedRunFile = "failed-run-data.json"
+
+// Node is the part of the node object that is safe to serve. Attributes holds the merged
+// value of each allowed attribute that is set on the node. Updated is the unix time the
+// file was written.
+type Node struct {
+	Name            string                 `json:"name"`
+	ChefEnvironment string                 `json:"chef_environment"`
+	RunList         []string               `json:"run_list"`
+	PolicyName      string                 `json:"policy_name,omitempty"`
+	PolicyGroup     string                 `json:"policy_group,omitempty"`
+	Attributes      map[string]interface{} `json:"attributes"`
+	Updated         int64                  `json:"updated"`
+}
+
+// FailedRun is the last failed run. Times are as chef wrote them and ElapsedTime is in seconds.
+type FailedRun struct {
+	RunID            string     `json:"run_id"`
+	StartTime        string     `json:"start_time"`
+	EndTime          string     `json:"end_time"`
+	ElapsedTime      float64    `json:"elapsed_time"`
+	Exception        string     `json:"exception"`
+	Backtrace        []string   `json:"backtrace"`
+	TotalResources   int        `json:"total_resources"`
+	UpdatedResources []Resource `json:"updated_resources"`
+	Updated          int64      `json:"updated"`
+}
+
+// Resource names a resource from a chef run. The other properties of the resource are
+// not kept as they can hold secrets.
+type Resource struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Cookbook string `json:"cookbook,omitempty"`
+	Recipe   string `json:"recipe,omitempty"`
+}
+
+// Reader reads the node and failed run data from CacheDir. NodeFile is the node object
+// relative to CacheDir. Attributes are dotted paths, eg: platform_version or nginx.port.
+type Reader struct {
+	CacheDir   string
+	NodeFile   string
+	Attributes []string
+}
+
+// Node reads the node object. The error satisfies os.IsNotExist if chef has not written it.
+func (r *Reader) Node() (*Node, error) {
+	raw := struct {
+		Name            string                 `json:"name"`
+		ChefEnvironment string                 `json:"chef_environment"`
+		RunList         []string               `json:"run_list"`
+		PolicyName      string                 `json:"policy_name"`
+		PolicyGroup     string                 `json:"policy_group"`
+		Default         map[string]interface{} `json:"default"`
+		Normal          map[string]interface{} `json:"normal"`
+		Override        map[string]interface{} `json:"override"`
+		Automatic       map[string]interface{} `json:"automatic"`
+	}{}
+	updated, err := readJSON(filepath.Join(r.CacheDir, r.NodeFile), &raw)
+	if err != nil {
+		return nil, err
+	}
+	// The attribute levels from lowest to highest precedence, the same as chef.
+	levels := []map[string]interface{}{raw.Default, raw.Normal, raw.Override, raw.Automatic}
+
+	node := &Node{
+		Name:            raw.Name,
+		ChefEnvironment: raw.ChefEnvironment,
+		RunList:         raw.RunList,
+		PolicyName:      raw.PolicyName,
+		PolicyGroup:     raw.PolicyGroup,
+		Attributes:      make(map[string]interface{}),
+		Updated:         updated,
+	}
+	if node.RunList == nil {
+		node.RunList = []string{}
+	}
+	for _, attribute := range r.Attributes {
+		if value, ok := mergedAttribute(levels, attribute); ok {
+			node.Attributes[attribute] = value
+		}
+	}
+	return node, nil
+}
+
+// FailedRun reads the data from the last failed run. The error satisfies os.IsNotExist
+// if no run has failed.
+func (r *Reader) FailedRun() (*FailedRun, error) {
+	raw := struct {
+		RunID            string        `json:"run_id"`
+		StartTime        interface{}   `json:"start_time"`
+		EndTime          interface{}   `json:"end_time"`
+		ElapsedTime      float64       `json:"elapsed_time"`
+		Exception        string        `json:"exception"`
+		Backtrace        []string      `json:"backtrace"`
+		AllResources     []interface{} `json:"all_resources"`
+		UpdatedResources []interface{} `json:"updated_resources"`
+	}{}
+	updated, err := readJSON(filepath.Join(r.CacheDir, FailedRunFile), &raw)
+	if err != nil {
+		return nil, err
+	}
+	run := &FailedRun{
+		RunID:            raw.RunID,
+		StartTime:        timeString(raw.StartTime),
+		EndTime:          timeString(raw.EndTime),
+		ElapsedTime:      raw.ElapsedTime,
+		Exception:        raw.Exception,
+		Backtrace:        raw.Backtrace,
+		TotalResources:   len(raw.AllResources),
+		UpdatedResources: make([]Resource, 0, len(raw.UpdatedResources)),
+		Updated:          updated,
+	}
+	if run.Backtrace == nil {
+		run.Backtrace = []string{}
+	}
+	for _, resource := range raw.UpdatedResources {
+		run.UpdatedResources = append(run.UpdatedResources, resourceName(resource))
+	}
+	return run, nil
+}
+
+// readJSON decodes the file in to v and returns the unix time it was written.
+func readJSON(path string, v interface{}) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return 0, fmt.Errorf("%s is not valid JSON. Error: %s", path, err)
+	}
+	return info.ModTime().Unix(), nil
+}
+
+// mergedAttribute looks up the dotted path at each level. The highest level wins and
+// objects that are set at more than one level are merged, the same as chef.
+func mergedAttribute(levels []map[string]interface{}, path string) (interface{}, bool) {
+	var merged interface{}
+	found := false
+	for _, attributes := range levels {
+		value, ok := lookup(attributes, strings.Split(path, "."))
+		if !ok {
+			continue
+		}
+		merged = deepMerge(merged, value)
+		found = true
+	}
+	return merged, found
+}
+
+func lookup(attributes map[string]interface{}, keys []string) (interface{}, bool) {
+	var value interface{} = attributes
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// deepMerge merges over in to base. Values in over win unless both are objects.
+func deepMerge(base, over interface{}) interface{} {
+	baseObject, baseOK := base.(map[string]interface{})
+	overObject, overOK := over.(map[string]interface{})
+	if !baseOK || !overOK {
+		return over
+	}
+	merged := make(map[string]interface{}, len(baseObject)+len(overObject))
+	for key, value := range baseObject {
+		merged[key] = value
+	}
+	for key, value := range overObject {
+		merged[key] = deepMerge(merged[key], value)
+	}
+	return merged
+}
+
+// timeString returns chef times as a string. Older versions of chef write them as numbers.
+func timeString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// resourceName reads the type and name of a resource. Chef writes resources with their
+// instance variables under instance_vars.
+func resourceName(value interface{}) Resource {
+	resource, _ := value.(map[string]interface{})
+	if vars, ok := resource["instance_vars"].(map[string]interface{}); ok {
+		resource = vars
+	}
+	field := func(names ...string) string {
+		for _, name := range names {
+			if s, ok := resource[name].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	return Resource{
+		Type:     field("declared_type", "resource_name"),
+		Name:     field("name"),
+		Cookbook: field("cookbook_name"),
+		Recipe:   field("recipe_name"),
+	}
+}
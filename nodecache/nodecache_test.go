@@ -0,0 +1,112 @@
+package nodecache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const nodeJSON = `{
+  "name": "web01.example.com",
+  "chef_environment": "production",
+  "json_class": "Chef::Node",
+  "chef_type": "node",
+  "run_list": ["role[base]", "recipe[nginx]"],
+  "policy_name": "webserver",
+  "policy_group": "canary",
+  "default": {"nginx": {"port": 80, "workers": 4}, "database": {"password": "hunter2"}},
+  "normal": {"tags": ["web"]},
+  "override": {"nginx": {"port": 8080}},
+  "automatic": {"platform": "ubuntu", "platform_version": "20.04"}
+}`
+
+const failedRunJSON = `{
+  "node": {"name": "web01.example.com", "default": {"database": {"password": "hunter2"}}},
+  "run_id": "c3a3c3e2-1111-2222-3333-444455556666",
+  "start_time": "2020-03-01 10:00:00 +0000",
+  "end_time": "2020-03-01 10:01:30 +0000",
+  "elapsed_time": 90.5,
+  "exception": "Net::HTTPServerException: 401 \"Unauthorized\"",
+  "backtrace": ["/opt/chef/lib/chef/http.rb:1"],
+  "all_resources": [{}, {}, {}],
+  "updated_resources": [
+    {"json_class": "Chef::Resource::Template", "instance_vars": {"name": "/etc/nginx/nginx.conf", "declared_type": "template", "cookbook_name": "nginx", "recipe_name": "default", "variables": {"password": "hunter2"}}}
+  ]
+}`
+
+func writeCache(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "nodecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNode(t *testing.T) {
+	dir := writeCache(t, map[string]string{"node.json": nodeJSON})
+	defer os.RemoveAll(dir)
+	reader := &Reader{CacheDir: dir, NodeFile: "node.json", Attributes: []string{"nginx", "nginx.port", "platform", "tags", "missing.value"}}
+
+	node, err := reader.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Name != "web01.example.com" || node.ChefEnvironment != "production" || len(node.RunList) != 2 || node.PolicyName != "webserver" || node.PolicyGroup != "canary" {
+		t.Errorf("The node details are wrong. Got: %+v", node)
+	}
+	expected := map[string]string{
+		"nginx":      "map[port:8080 workers:4]",
+		"nginx.port": "8080",
+		"platform":   "ubuntu",
+		"tags":       "[web]",
+	}
+	if len(node.Attributes) != len(expected) {
+		t.Errorf("Only the allowed attributes that are set should be returned. Got: %v", node.Attributes)
+	}
+	for attribute, value := range expected {
+		if fmt.Sprint(node.Attributes[attribute]) != value {
+			t.Errorf("%s should be %s. Got: %v", attribute, value, node.Attributes[attribute])
+		}
+	}
+	if node.Updated == 0 {
+		t.Error("The time the node was written should be set")
+	}
+
+	reader.Attributes = nil
+	if node, _ = reader.Node(); len(node.Attributes) != 0 {
+		t.Errorf("No attributes should be returned unless they are allowed. Got: %v", node.Attributes)
+	}
+}
+
+func TestFailedRun(t *testing.T) {
+	dir := writeCache(t, map[string]string{FailedRunFile: failedRunJSON})
+	defer os.RemoveAll(dir)
+	reader := &Reader{CacheDir: dir, NodeFile: "node.json"}
+
+	if _, err := reader.Node(); !os.IsNotExist(err) {
+		t.Errorf("A missing node should be a not exist error. Got: %v", err)
+	}
+	run, err := reader.FailedRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RunID != "c3a3c3e2-1111-2222-3333-444455556666" || run.ElapsedTime != 90.5 || run.StartTime != "2020-03-01 10:00:00 +0000" || run.TotalResources != 3 || len(run.Backtrace) != 1 {
+		t.Errorf("The failed run details are wrong. Got: %+v", run)
+	}
+	expected := Resource{Type: "template", Name: "/etc/nginx/nginx.conf", Cookbook: "nginx", Recipe: "default"}
+	if len(run.UpdatedResources) != 1 || run.UpdatedResources[0] != expected {
+		t.Errorf("Only the names of the updated resources should be returned. Got: %+v", run.UpdatedResources)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, FailedRunFile), []byte("{"), 0644)
+	if _, err := reader.FailedRun(); err == nil || os.IsNotExist(err) {
+		t.Errorf("Invalid JSON should be an error. Got: %v", err)
+	}
+}
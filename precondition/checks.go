@@ -0,0 +1,63 @@
+package precondition
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/morfien101/chef-waiter/cmd"
+	"github.com/morfien101/chef-waiter/health"
+)
+
+// maxOutput is how much of the output of a failed command is kept in the message.
+const maxOutput = 256
+
+// FileExists fails if the path does not exist.
+func FileExists(path string) Check {
+	return Check{Name: "file_exists:" + path, Run: func() error {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s does not exist", path)
+		}
+		return nil
+	}}
+}
+
+// FileAbsent fails if the path exists. It is used for flag files like "do not converge".
+func FileAbsent(path string) Check {
+	return Check{Name: "file_absent:" + path, Run: func() error {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s exists", path)
+		}
+		return nil
+	}}
+}
+
+// Command fails if the command does not exit with 0 before the timeout.
+// A timeout of 0 lets the command run until it finishes.
+func Command(command string, args []string, timeout time.Duration) Check {
+	return Check{Name: "command:" + command, Run: func() error {
+		output := &bytes.Buffer{}
+		exitCode, timedOut, err := cmd.RunCommandWithTimeout(timeout, cmd.Options{}, output, command, args...)
+		switch {
+		case timedOut:
+			return fmt.Errorf("%s timed out after %s", command, timeout)
+		case err != nil:
+			return fmt.Errorf("%s could not be run. Error: %s", command, err)
+		case exitCode != 0:
+			out := strings.TrimSpace(output.String())
+			if len(out) > maxOutput {
+				out = out[:maxOutput]
+			}
+			return fmt.Errorf("%s exited with %d. Output: %s", command, exitCode, out)
+		}
+		return nil
+	}}
+}
+
+// FreeSpace fails if the disk that holds the directory has less than minMB megabytes free.
+func FreeSpace(dir string, minMB uint64) Check {
+	name := "free_space:" + dir
+	return Check{Name: name, Run: health.FreeSpace(name, dir, minMB).Run}
+}
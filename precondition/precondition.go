@@ -0,0 +1,68 @@
+// Package precondition holds the checks that must pass before a chef run can start.
+// They stop runs when the node is not in a state to converge, for example when the disk
+// is full or another package manager holds its lock. Every check is run each time a job
+// leaves the queue and the results are kept so that they can be shown on the status page.
+package precondition
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+// Check is a single precondition. A check that returns an error has failed.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Gate holds the preconditions and the results from the last time they were evaluated.
+type Gate struct {
+	sync.Mutex
+	checks  []Check
+	results []internalstate.PreconditionResult
+}
+
+// New returns a gate with the checks. A gate without checks always passes.
+func New(checks ...Check) *Gate {
+	return &Gate{checks: checks}
+}
+
+// Set replaces the checks. The results of checks that are no longer used are dropped.
+func (g *Gate) Set(checks ...Check) {
+	g.Lock()
+	defer g.Unlock()
+	g.checks = checks
+	g.results = nil
+}
+
+// Evaluate runs every check and returns the messages of the ones that failed.
+// It returns an empty string if they all passed.
+func (g *Gate) Evaluate() string {
+	g.Lock()
+	defer g.Unlock()
+	results := make([]internalstate.PreconditionResult, 0, len(g.checks))
+	failed := make([]string, 0)
+	for _, check := range g.checks {
+		result := internalstate.PreconditionResult{Name: check.Name, Passed: true, Time: time.Now().Unix()}
+		if err := check.Run(); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+			failed = append(failed, check.Name+": "+result.Message)
+		}
+		results = append(results, result)
+	}
+	g.results = results
+	return strings.Join(failed, "; ")
+}
+
+// Results returns a copy of the results from the last time the checks were evaluated.
+func (g *Gate) Results() []internalstate.PreconditionResult {
+	g.Lock()
+	defer g.Unlock()
+	results := make([]internalstate.PreconditionResult, len(g.results))
+	copy(results, g.results)
+	return results
+}
@@ -0,0 +1,78 @@
+package precondition
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGate(t *testing.T) {
+	gate := New()
+	if reason := gate.Evaluate(); reason != "" {
+		t.Errorf("A gate without checks should pass. Got: %s", reason)
+	}
+
+	gate.Set(
+		Check{Name: "pass", Run: func() error { return nil }},
+		Check{Name: "fail", Run: func() error { return errors.New("apt is locked") }},
+	)
+	if reason := gate.Evaluate(); reason != "fail: apt is locked" {
+		t.Errorf("The reason should name the failed check. Got: %s", reason)
+	}
+	results := gate.Results()
+	if len(results) != 2 || !results[0].Passed || results[1].Passed || results[1].Message != "apt is locked" {
+		t.Errorf("Every check should have a result. Got: %+v", results)
+	}
+
+	gate.Set()
+	if len(gate.Results()) != 0 {
+		t.Error("Results should be dropped when the checks change")
+	}
+}
+
+func TestFileChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "precondition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flag := filepath.Join(dir, "do_not_converge")
+
+	if FileAbsent(flag).Run() != nil || FileExists(flag).Run() == nil {
+		t.Error("The flag file does not exist yet")
+	}
+	if err := ioutil.WriteFile(flag, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if FileAbsent(flag).Run() == nil || FileExists(flag).Run() != nil {
+		t.Error("The flag file exists")
+	}
+
+	if err := FreeSpace(dir, 0).Run(); err != nil {
+		t.Errorf("Any disk has 0MB free. Got: %s", err)
+	}
+	if err := FreeSpace(dir, 1<<40).Run(); err == nil {
+		t.Error("No disk has an exabyte free")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses /bin/sh")
+	}
+	if err := Command("/bin/sh", []string{"-c", "exit 0"}, time.Second).Run(); err != nil {
+		t.Errorf("Command should pass. Got: %s", err)
+	}
+	err := Command("/bin/sh", []string{"-c", "echo locked; exit 1"}, time.Second).Run()
+	if err == nil || !strings.Contains(err.Error(), "exited with 1") || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Command should fail with its exit code and output. Got: %v", err)
+	}
+	if err := Command("/bin/sleep", []string{"5"}, 50*time.Millisecond).Run(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Command should time out. Got: %v", err)
+	}
+}
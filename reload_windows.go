@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+)
+
+// reloadSignal returns nil as Windows has no SIGHUP. The service needs to be restarted
+// to pick up a change to the configuration.
+func reloadSignal() <-chan os.Signal {
+	return nil
+}
@@ -3,15 +3,21 @@ package main
 import (
 	"fmt"
 	"os"
+	"sort"
+	"time"
 
 	"github.com/morfien101/service"
 
 	"github.com/morfien101/chef-waiter/cheflogs"
 	"github.com/morfien101/chef-waiter/chefrunner"
 	"github.com/morfien101/chef-waiter/config"
+	"github.com/morfien101/chef-waiter/drift"
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/health"
 	"github.com/morfien101/chef-waiter/internalstate"
 	"github.com/morfien101/chef-waiter/logs"
 	"github.com/morfien101/chef-waiter/metrics"
+	"github.com/morfien101/chef-waiter/precondition"
 	"github.com/morfien101/chef-waiter/webengine"
 )
 
@@ -93,10 +99,22 @@ func (p *program) run() error {
 	go chefLogWorker.LogSweepEngine()
 	// Initialize a new state tables
 	state := internalstate.New(runningConfig, chefLogWorker, logger)
-	appState := internalstate.NewAppStatus(VERSION, state, logger)
-	appState.SetWhiteListing(runningConfig.InternalWhiteListCustomRuns, runningConfig.InternalAllowedCustomRuns)
+	executor, binary, err := newExecutor(runningConfig)
+	if err != nil {
+		logger.Error(err)
+		terminate(1)
+	}
+	// Only chef can tell us its version.
+	versionBinary := binary
+	if runningConfig.Executor() == chefrunner.ExecutorCommand {
+		versionBinary = ""
+	}
+	appState := internalstate.NewAppStatus(VERSION, versionBinary, state, logger)
 	// start the job engine that runs the commands.
-	workers := chefrunner.New(state, chefLogWorker, logger)
+	workers := chefrunner.New(state, chefLogWorker, logger, runningConfig.QueueSize(), executor)
+	appState.SetQueue(workers)
+	appState.SetPreconditions(workers)
+	appState.SetDrain(workers)
 
 	// Start the sweeper process to keep state tables clean.
 	go state.ClearOldRuns()
@@ -105,11 +123,15 @@ func (p *program) run() error {
 
 	// Start the HTTP Engine
 	httpEngine := webengine.New(state, appState, workers, chefLogWorker, logger)
-	if runningConfig.WhiteListCustomRuns() {
-		if len(runningConfig.AllowedCustomRuns()) > 0 {
-			httpEngine.SetWhitelist(runningConfig.AllowedCustomRuns())
-		}
-	}
+	driftMonitor := drift.New(state, drift.Thresholds{
+		FailedRuns: runningConfig.DriftFailedRuns(),
+		MaxAge:     time.Duration(runningConfig.DriftMaxAge()) * time.Hour,
+	}, runningConfig.DriftAlertURL(), logger)
+	go driftMonitor.Run()
+	checker := healthChecks(runningConfig, binary, state, driftMonitor)
+	httpEngine.SetHealth(checker)
+	appState.SetHealth(checker)
+	applySettings(runningConfig, appState, httpEngine, workers)
 	listenString := fmt.Sprintf("%s:%d", runningConfig.ListenAddress(), runningConfig.ListenPort())
 	if runningConfig.TLSEnabled() {
 		logs.DebugMessage("Starting Web Server with TLS Supported StartHTTPSEngine() function.")
@@ -128,31 +150,189 @@ func (p *program) run() error {
 	// return errors
 
 	// We hold the run function waiting for an exit signal.
-	select {
-	case err := <-errChan:
-		logger.Errorf("We got a critical error. Stopping application. Error: %s", err)
-		// This is a hack because the service wrapper doesn't stop the application
-		// When we return an error.
-		// Really rhe other application should run with context and we cancel them also.
-		terminate(1)
-		return nil
-	case <-p.exit:
-		// This case statement can be used to tear down the service and save
-		// any state the needs it.
-		logs.DebugMessage("Got exit message. Shutting down.")
-		err := httpEngine.StopHTTPEngine()
-		if err != nil {
-			logger.Errorf("Failed to shutdown HTTP service. Error: %s", err)
+	reload := reloadSignal()
+	for {
+		select {
+		case <-reload:
+			reloadConfig(appState, httpEngine, workers)
+		case err := <-errChan:
+			logger.Errorf("We got a critical error. Stopping application. Error: %s", err)
+			// This is a hack because the service wrapper doesn't stop the application
+			// When we return an error.
+			// Really rhe other application should run with context and we cancel them also.
+			terminate(1)
+			return nil
+		case <-p.exit:
+			// This case statement can be used to tear down the service and save
+			// any state the needs it.
+			logs.DebugMessage("Got exit message. Shutting down.")
+			// The HTTP server stays up while draining so that the progress can be seen on
+			// the status page and new runs are refused.
+			workers.Drain()
+			err := httpEngine.StopHTTPEngine()
+			if err != nil {
+				logger.Errorf("Failed to shutdown HTTP service. Error: %s", err)
+			}
+			err = state.SaveStateToDisk()
+			if err != nil {
+				logger.Error(err)
+			}
+			metrics.Incr("shutting_down", 1, map[string]string{"exitCode": fmt.Sprintf("%d", 0), "version": VERSION})
+			metrics.Shutdown()
+			p.finshed <- true
+			return nil
 		}
-		err = state.SaveStateToDisk()
-		if err != nil {
-			logger.Error(err)
+	}
+}
+
+// healthChecks creates the checks used by the health endpoints. Checks with a limit of 0 are left out.
+// binary is the program that the executor starts.
+func healthChecks(runningConfig *config.ValuesContainer, binary string, state *internalstate.StateTable, driftMonitor *drift.Monitor) *health.Checker {
+	checker := health.New()
+	checker.Add(
+		health.WritableDir("state_dir_writable", runningConfig.StateFileLocation()),
+		health.Binary("chef_client_binary", binary),
+	)
+	if minFree := runningConfig.HealthMinFreeSpace(); minFree > 0 {
+		checker.Add(health.FreeSpace("log_dir_free_space", runningConfig.LogLocation(), minFree))
+	}
+	if maxRunTime := runningConfig.HealthMaxRunTime(); maxRunTime > 0 {
+		checker.Add(health.StuckRun("supervisor_stuck_run", state, time.Duration(maxRunTime)*time.Minute))
+	}
+	checker.Add(health.Func("periodic_drift", health.Readiness, driftMonitor.Check))
+	if runningConfig.MetricsEnabled {
+		checker.Add(health.Warning(health.Func("statsd_reachable", health.Readiness, metrics.Reachable)))
+	}
+	return checker
+}
+
+// applySettings passes the settings that can change without a restart to the parts that use them.
+func applySettings(runningConfig config.Config, appState *internalstate.AppStatusHandler, httpEngine *webengine.HTTPEngine, workers *chefrunner.RunRequest) {
+	logs.TurnDebuggingOn(logger, runningConfig.Debug())
+	appState.SetWhiteListing(runningConfig.WhiteListCustomRuns(), runningConfig.AllowedCustomRuns())
+	if runningConfig.WhiteListCustomRuns() && len(runningConfig.AllowedCustomRuns()) > 0 {
+		httpEngine.SetWhitelist(runningConfig.AllowedCustomRuns())
+	} else {
+		httpEngine.DisableWhitelist()
+	}
+	policies := allowedPolicies(runningConfig.AllowedPolicies())
+	appState.SetAllowedPolicies(policies)
+	httpEngine.SetAllowedPolicies(policies)
+	httpEngine.SetLegacyGetMutators(runningConfig.LegacyGetMutators())
+	httpEngine.SetBundleRuns(runningConfig.BundleRunsEnabled(), runningConfig.BundleMaxSize())
+	httpEngine.SetDiagnostics(runningConfig.ChefClientConfig(), time.Duration(runningConfig.DiagnosticsTimeout())*time.Second)
+	httpEngine.SetNodeCache(runningConfig.ChefCacheLocation(), runningConfig.NodeCacheFile(), runningConfig.NodeAttributes())
+	workers.SetRetryPolicy(chefrunner.RetryPolicy{
+		MaxAttempts: runningConfig.RetryMaxAttempts(),
+		Backoff:     time.Duration(runningConfig.RetryBackoff()) * time.Second,
+		ExitCodes:   runningConfig.RetryExitCodes(),
+		OnDemand:    runningConfig.RetryOnDemand(),
+	})
+	workers.SetHooks(chefrunner.Hooks{
+		PreRun:  runHook(runningConfig.PreRunHook()),
+		PostRun: runHook(runningConfig.PostRunHook()),
+	})
+	workers.SetPreconditions(preconditionChecks(runningConfig.Preconditions())...)
+	workers.SetDrainTimeout(time.Duration(runningConfig.DrainTimeout()) * time.Second)
+}
+
+// allowedPolicies reads the policies that custom runs can use. Invalid policies are logged and left out.
+func allowedPolicies(conf []config.PolicyConfig) []internalstate.Policy {
+	policies := make([]internalstate.Policy, 0, len(conf))
+	for _, allowed := range conf {
+		policy := internalstate.Policy{PolicyGroup: allowed.PolicyGroup, PolicyName: allowed.PolicyName}
+		if err := policy.Validate(); err != nil {
+			logger.Errorf("Ignoring allowed policy %s. Error: %s", policy, err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// preconditionChecks builds the checks that must pass before a run can start.
+func preconditionChecks(conf config.PreconditionConfig) []precondition.Check {
+	checks := make([]precondition.Check, 0)
+	for _, path := range conf.FilesExist {
+		checks = append(checks, precondition.FileExists(path))
+	}
+	for _, path := range conf.FilesAbsent {
+		checks = append(checks, precondition.FileAbsent(path))
+	}
+	for _, command := range conf.Commands {
+		checks = append(checks, precondition.Command(command.Command, command.Args, time.Duration(command.Timeout)*time.Second))
+	}
+	dirs := make([]string, 0, len(conf.FreeSpace))
+	for dir := range conf.FreeSpace {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		checks = append(checks, precondition.FreeSpace(dir, conf.FreeSpace[dir]))
+	}
+	return checks
+}
+
+// newExecutor creates the executor chosen in the configuration file. It also returns the
+// program that the executor starts so that it can be checked.
+func newExecutor(runningConfig config.Config) (chefrunner.Executor, string, error) {
+	chefClient := chefrunner.ChefClient{
+		Binary:     runningConfig.ChefClientBinary(),
+		Sudo:       runningConfig.ChefClientSudo(),
+		Args:       runningConfig.ChefClientArgs(),
+		Env:        runningConfig.ChefClientEnv(),
+		WorkingDir: runningConfig.ChefClientWorkingDir(),
+	}
+	switch runningConfig.Executor() {
+	case chefrunner.ExecutorChefClient:
+		return chefClient, chefClient.Binary, nil
+	case chefrunner.ExecutorChefSolo, chefrunner.ExecutorLocalMode:
+		return chefrunner.ChefSolo{
+			ChefClient:     chefClient,
+			LocalMode:      runningConfig.Executor() == chefrunner.ExecutorLocalMode,
+			RecipeURL:      runningConfig.ExecutorRecipeURL(),
+			JSONAttributes: runningConfig.ExecutorJSONAttributes(),
+		}, chefClient.Binary, nil
+	case chefrunner.ExecutorCommand:
+		command := runningConfig.ExecutorCommand()
+		if command.Command == "" {
+			return nil, "", fmt.Errorf("executor_command must have a command when the executor is %s", chefrunner.ExecutorCommand)
 		}
-		metrics.Incr("shutting_down", 1, map[string]string{"exitCode": fmt.Sprintf("%d", 0), "version": VERSION})
-		metrics.Shutdown()
-		p.finshed <- true
-		return nil
+		return chefrunner.Command{
+			Command:    command.Command,
+			Args:       command.Args,
+			Env:        chefClient.Env,
+			WorkingDir: chefClient.WorkingDir,
+			Timeout:    time.Duration(command.Timeout) * time.Second,
+		}, command.Command, nil
+	}
+	return nil, "", fmt.Errorf("executor must be %s, %s, %s or %s. Got: %s", chefrunner.ExecutorChefClient, chefrunner.ExecutorChefSolo, chefrunner.ExecutorLocalMode, chefrunner.ExecutorCommand, runningConfig.Executor())
+}
+
+// runHook converts a hook from the configuration file into a hook for the run engine.
+func runHook(hook config.HookConfig) chefrunner.Hook {
+	return chefrunner.Hook{
+		Command:        hook.Command,
+		Args:           hook.Args,
+		Timeout:        time.Duration(hook.Timeout) * time.Second,
+		AbortOnFailure: hook.AbortOnFailure,
+	}
+}
+
+// reloadConfig reads the configuration file again and applies the settings that can change
+// without a restart. A config_reloaded event is published with the outcome.
+func reloadConfig(appState *internalstate.AppStatusHandler, httpEngine *webengine.HTTPEngine, workers *chefrunner.RunRequest) {
+	logger.Info("Reloading the configuration.")
+	newConfig, err := config.New(os.Getenv("CHEFWAITER_CONFIG"), logger)
+	if err != nil {
+		logger.Errorf("Failed to reload the configuration. The running configuration has been kept. Error: %s", err)
+		metrics.Incr("config_reloaded", 1, map[string]string{"success": "false"})
+		events.Publish(events.ConfigReloaded, "", map[string]interface{}{"success": false, "error": err.Error()})
+		return
 	}
+	applySettings(newConfig, appState, httpEngine, workers)
+	metrics.Incr("config_reloaded", 1, map[string]string{"success": "true"})
+	events.Publish(events.ConfigReloaded, "", map[string]interface{}{"success": true})
 }
 
 func terminate(exitcode int) {
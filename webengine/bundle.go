@@ -0,0 +1,101 @@
+package webengine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/morfien101/chef-waiter/bundle"
+	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// SetBundleRuns turns the bundle upload endpoint on or off. maxSize is the largest
+// upload accepted in megabytes.
+func (e *HTTPEngine) SetBundleRuns(enabled bool, maxSize int64) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.bundleRuns = enabled
+	e.bundleMaxSize = maxSize * 1024 * 1024
+}
+
+// v2CreateBundleRun queues a local mode run of the gzipped tar in the body. The sha256 query
+// parameter must match the body. run_list is optional and, like a custom run, must be in the
+// whitelist if it is in use. Bundle runs are never joined to queued jobs.
+func (e *HTTPEngine) v2CreateBundleRun(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	e.settings.RLock()
+	enabled, maxSize := e.bundleRuns, e.bundleMaxSize
+	e.settings.RUnlock()
+	if !enabled {
+		writeError(w, http.StatusForbidden, "disabled", "Bundle runs are turned off", nil)
+		return
+	}
+	if e.state.ReadRunLock() {
+		writeError(w, http.StatusForbidden, "locked", "Chefwaiter is locked", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	checksum := query.Get("sha256")
+	if checksum == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "sha256 is required", nil)
+		return
+	}
+	runList := query.Get("run_list")
+	if len(runList) > 512 {
+		writeError(w, http.StatusBadRequest, "bad_request", "run_list is too large. Max size 512 bytes", nil)
+		return
+	}
+	custom := runList != ""
+	if custom && !e.customRunAllowed(runList) {
+		writeError(w, http.StatusForbidden, "not_whitelisted", fmt.Sprintf("Whitelist does not contain '%s'", runList), map[string]string{"custom_run": runList})
+		return
+	}
+	emergency, opts, ok := legacyRunOptions(w, r)
+	if !ok {
+		return
+	}
+
+	if r.ContentLength > maxSize {
+		writeBundleTooLarge(w, maxSize)
+		return
+	}
+	upload, err := bundle.Receive(r.Body, checksum, maxSize)
+	switch {
+	case err == bundle.ErrTooLarge:
+		writeBundleTooLarge(w, maxSize)
+		return
+	case errors.Is(err, bundle.ErrChecksum):
+		metrics.Incr("bundle_rejected", 1, map[string]string{"reason": "checksum"})
+		writeError(w, http.StatusBadRequest, "bad_request", "The bundle does not match the sha256 checksum", map[string]string{"reason": err.Error()})
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to receive the bundle", map[string]string{"reason": err.Error()})
+		return
+	}
+	defer upload.Remove()
+
+	opts.Bundle = upload.SHA256
+	opts.Prepare = func(guid string) error {
+		return upload.Extract(e.chefLogsWorker.GetBundlePath(guid))
+	}
+	sub, err := e.submitRun(custom, runList, emergency, opts)
+	if errors.Is(err, bundle.ErrInvalid) {
+		metrics.Incr("bundle_rejected", 1, map[string]string{"reason": "invalid"})
+		writeError(w, http.StatusBadRequest, "bad_request", "The bundle could not be unpacked", map[string]string{"reason": err.Error()})
+		return
+	}
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+	logs.DebugMessage(fmt.Sprintf("v2CreateBundleRun() - %s, %d bytes, sha256 %s", sub.GUID, upload.Size, upload.SHA256))
+	e.writeCreatedRun(w, sub)
+}
+
+// writeBundleTooLarge is the error written when an upload is larger than the size limit.
+func writeBundleTooLarge(w http.ResponseWriter, maxSize int64) {
+	metrics.Incr("bundle_rejected", 1, map[string]string{"reason": "too_large"})
+	writeError(w, http.StatusRequestEntityTooLarge, "too_large", "The bundle is larger than the size limit", map[string]string{"max_bytes": fmt.Sprint(maxSize)})
+}
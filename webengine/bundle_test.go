@@ -0,0 +1,90 @@
+package webengine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/morfien101/chef-waiter/cheflogs"
+)
+
+// testBundle returns a gzipped tar with a single recipe and its sha256 checksum.
+func testBundle(t *testing.T, name string) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	recipe := []byte("log 'bundle'")
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(recipe)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(recipe)
+	tw.Close()
+	gz.Close()
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+func TestBundleRun(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	bundleDir, err := ioutil.TempDir("", "bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleDir)
+	webEngine.chefLogsWorker.(*cheflogs.ChefLogsTest).FakeBundleLocation = bundleDir
+
+	body, checksum := testBundle(t, "cookbooks/base/recipes/default.rb")
+	post := func(query string, body []byte) (*httptest.ResponseRecorder, *errorEnvelope) {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url("/v2/runs/bundle"+query), bytes.NewReader(body)))
+		envelope := &errorEnvelope{}
+		json.Unmarshal(w.Body.Bytes(), envelope)
+		return w, envelope
+	}
+
+	if w, envelope := post("?sha256="+checksum, body); w.Code != http.StatusForbidden || envelope.Error.Code != "disabled" {
+		t.Errorf("Bundle runs should be off by default. Got: %d %s", w.Code, envelope.Error.Code)
+	}
+
+	webEngine.SetBundleRuns(true, 1)
+	if w, envelope := post("", body); w.Code != http.StatusBadRequest || envelope.Error.Code != "bad_request" {
+		t.Errorf("A bundle without a checksum should be rejected. Got: %d %s", w.Code, envelope.Error.Code)
+	}
+	if w, envelope := post("?sha256="+checksum, []byte("other")); w.Code != http.StatusBadRequest || envelope.Error.Code != "bad_request" {
+		t.Errorf("A bundle that does not match the checksum should be rejected. Got: %d %s", w.Code, envelope.Error.Code)
+	}
+	large := make([]byte, 1024*1024+1)
+	largeSum := sha256.Sum256(large)
+	if w, envelope := post("?sha256="+hex.EncodeToString(largeSum[:]), large); w.Code != http.StatusRequestEntityTooLarge || envelope.Error.Code != "too_large" {
+		t.Errorf("A bundle over the size limit should be rejected. Got: %d %s", w.Code, envelope.Error.Code)
+	}
+	escape, escapeSum := testBundle(t, "../escape.rb")
+	if w, envelope := post("?sha256="+escapeSum, escape); w.Code != http.StatusBadRequest || envelope.Error.Code != "bad_request" {
+		t.Errorf("A bundle that writes outside of its directory should be rejected. Got: %d %s", w.Code, envelope.Error.Code)
+	}
+
+	w, _ := post("?sha256="+checksum+"&run_list=recipe[base]&requester=ci", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("A valid bundle should be accepted. Got: %d %s", w.Code, w.Body.String())
+	}
+	created := &createRunResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), created); err != nil {
+		t.Fatal(err)
+	}
+	if !created.Created || created.BundleSHA256 != checksum || created.Priority != "custom" || created.Requester != "ci" {
+		t.Errorf("The job should record the bundle and request. Got: %+v", created)
+	}
+	recipe, err := ioutil.ReadFile(filepath.Join(bundleDir, created.GUID, "cookbooks", "base", "recipes", "default.rb"))
+	if err != nil || string(recipe) != "log 'bundle'" {
+		t.Errorf("The bundle should be unpacked in the directory of the job. Got: %q, %v", recipe, err)
+	}
+}
@@ -0,0 +1,34 @@
+package webengine
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/morfien101/chef-waiter/diagnostics"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// SetDiagnostics sets the client.rb that the diagnostics endpoint reads and how long each
+// of its network checks can take. Without it the endpoint returns a 503.
+func (e *HTTPEngine) SetDiagnostics(configPath string, timeout time.Duration) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.diagnostics = &diagnostics.Diagnoser{ConfigPath: configPath, Timeout: timeout}
+}
+
+// getDiagnostics checks that chef-client can talk to its chef server. The report is
+// returned with a 200 even if a check failed so that every result can be seen.
+func (e *HTTPEngine) getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	e.settings.RLock()
+	diagnoser := e.diagnostics
+	e.settings.RUnlock()
+	if diagnoser == nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Diagnostics are not set up", nil)
+		return
+	}
+	report := diagnoser.Run()
+	if !report.Passed {
+		metrics.Incr("diagnostics_failed", 1, nil)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
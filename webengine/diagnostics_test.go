@@ -0,0 +1,52 @@
+package webengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morfien101/chef-waiter/diagnostics"
+)
+
+func TestDiagnostics(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	w := httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url("/chef/diagnostics"), nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Diagnostics that are not set up should return a 503. Got: %d", w.Code)
+	}
+
+	chefServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer chefServer.Close()
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clientRB := filepath.Join(dir, "client.rb")
+	if err := ioutil.WriteFile(clientRB, []byte(fmt.Sprintf("chef_server_url %q\nnode_name \"web01\"\n", chefServer.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	webEngine.SetDiagnostics(clientRB, time.Second)
+
+	w = httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url("/chef/diagnostics"), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Diagnostics should return a 200 even if a check failed. Got: %d, Body: %s", w.Code, w.Body)
+	}
+	report := diagnostics.Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed || report.ClientKey.Exists || report.Config.NodeName != "web01" || !report.Connectivity.Passed || !report.ClockSkew.Passed {
+		t.Errorf("Only the missing client key should fail. Got: %+v", report)
+	}
+}
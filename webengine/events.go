@@ -0,0 +1,83 @@
+package webengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// eventBufferSize is how many events a slow consumer can fall behind before events are dropped for it.
+const eventBufferSize = 100
+
+// eventKeepAlive is how often a comment is sent to keep idle streams open through proxies.
+const eventKeepAlive = 15 * time.Second
+
+// eventsDropped is sent to a consumer that was too slow to keep up. Data has the number of events it missed.
+const eventsDropped = "events_dropped"
+
+// streamEvents sends the events from the event bus as Server-Sent Events until the client goes away.
+// The types query parameter takes a comma separated list of the event types to send.
+func (e *HTTPEngine) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Streaming is not supported by this connection", nil)
+		return
+	}
+	types := splitList(r.URL.Query().Get("types"))
+
+	sub := events.Subscribe(eventBufferSize)
+	defer events.Unsubscribe(sub)
+	metrics.Incr("events_subscribed", 1, nil)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-e.closing:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-sub.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				metrics.Incr("events_dropped", int64(dropped), nil)
+				writeEvent(w, events.Event{Type: eventsDropped, Time: time.Now().Unix(), Data: map[string]uint64{"count": dropped}})
+			}
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				e.logger.Errorf("Failed to write event %d. Error: %s", event.ID, err)
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a single event in the Server-Sent Events format.
+// The id is left out for events that did not come from the bus.
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
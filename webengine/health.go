@@ -0,0 +1,51 @@
+package webengine
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/morfien101/chef-waiter/health"
+	"github.com/morfien101/chef-waiter/metrics"
+)
+
+// healthCheck - Writes a HealthCheck message that can be used to check the state
+// of the chef waiter. It runs every check, the same as /readyz.
+func (e *HTTPEngine) healthCheck(w http.ResponseWriter, r *http.Request) {
+	e.writeHealth(w, r, e.health.Ready())
+}
+
+// liveCheck only runs the liveness checks. It fails when the chef waiter needs a restart.
+func (e *HTTPEngine) liveCheck(w http.ResponseWriter, r *http.Request) {
+	e.writeHealth(w, r, e.health.Live())
+}
+
+// readyCheck runs every check. It fails when the chef waiter can't do its job right now.
+func (e *HTTPEngine) readyCheck(w http.ResponseWriter, r *http.Request) {
+	e.writeHealth(w, r, e.health.Ready())
+}
+
+// writeHealth writes a 200 when the report is healthy or a 503 with the failed checks in the details.
+// verbose=true adds the result of every check.
+func (e *HTTPEngine) writeHealth(w http.ResponseWriter, r *http.Request, report health.Report) {
+	verbose := r.URL.Query().Get("verbose") == "true"
+	if !report.Healthy {
+		details := map[string]string{}
+		failed := 0
+		for _, result := range report.Results {
+			if result.Status == health.StatusFail {
+				details[result.Name] = result.Message
+				failed++
+			} else if verbose {
+				details[result.Name] = result.Status
+			}
+		}
+		metrics.Incr("health_check_failed", 1, nil)
+		writeError(w, http.StatusServiceUnavailable, "unhealthy", fmt.Sprintf("%d health checks failed", failed), details)
+		return
+	}
+	response := &healthResponse{State: "OK"}
+	if verbose {
+		response.Checks = report.Results
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,69 @@
+package webengine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/morfien101/chef-waiter/health"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	checker := health.New()
+	checker.Add(
+		health.Func("supervisor", health.Liveness, func() error { return nil }),
+		health.Func("state_dir", health.Readiness, func() error { return errors.New("disk full") }),
+		health.Warning(health.Func("statsd", health.Readiness, func() error { return errors.New("no such host") })),
+	)
+	webEngine.SetHealth(checker)
+
+	tests := []struct {
+		url          string
+		expectedCode int
+		details      map[string]string
+		checks       int
+	}{
+		{url: "/livez", expectedCode: http.StatusOK},
+		{url: "/livez?verbose=true", expectedCode: http.StatusOK, checks: 1},
+		{url: "/readyz", expectedCode: http.StatusServiceUnavailable, details: map[string]string{"state_dir": "disk full"}},
+		{url: "/healthcheck?verbose=true", expectedCode: http.StatusServiceUnavailable, details: map[string]string{
+			"supervisor": "ok",
+			"state_dir":  "disk full",
+			"statsd":     "warn",
+		}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url(test.url), nil))
+		if w.Code != test.expectedCode {
+			t.Errorf("%s returned %d, want %d. Body: %s", test.url, w.Code, test.expectedCode, w.Body)
+			continue
+		}
+		if test.expectedCode == http.StatusOK {
+			response := healthResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.State != "OK" || len(response.Checks) != test.checks {
+				t.Errorf("%s returned the wrong health. Got: %+v", test.url, response)
+			}
+			continue
+		}
+		envelope := errorEnvelope{}
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Error.Code != "unhealthy" || len(envelope.Error.Details) != len(test.details) {
+			t.Errorf("%s returned the wrong error. Got: %+v", test.url, envelope.Error)
+		}
+		for name, want := range test.details {
+			if envelope.Error.Details[name] != want {
+				t.Errorf("%s has the wrong detail for %s. Got: %q, Want: %q", test.url, name, envelope.Error.Details[name], want)
+			}
+		}
+	}
+}
@@ -10,12 +10,16 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/morfien101/chef-waiter/cheflogs"
 	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/diagnostics"
+	"github.com/morfien101/chef-waiter/health"
 	"github.com/morfien101/chef-waiter/internalstate"
 	"github.com/morfien101/chef-waiter/logs"
+	"github.com/morfien101/chef-waiter/nodecache"
 
 	"github.com/gorilla/mux"
 )
@@ -33,8 +37,25 @@ type HTTPEngine struct {
 	appState       internalstate.AppStatusReader
 	worker         chefrunner.Worker
 	chefLogsWorker cheflogs.WorkerReader
+	health         *health.Checker
 	server         *http.Server
-	whitelists     *customRunWhitelist
+	// settings guards the values below that can be changed when the config is reloaded.
+	settings   sync.RWMutex
+	whitelists *customRunWhitelist
+	// allowedPolicies are the policies that custom runs can use. Empty allows none.
+	allowedPolicies []internalstate.Policy
+	// legacyGetMutators allows the old GET endpoints that change state to be used.
+	legacyGetMutators bool
+	// bundleRuns allows cookbook bundles to be uploaded. bundleMaxSize is in bytes.
+	bundleRuns    bool
+	bundleMaxSize int64
+	// diagnostics checks the connection to the chef server.
+	diagnostics *diagnostics.Diagnoser
+	// nodeCache reads the node and failed run data that chef leaves in its cache.
+	nodeCache *nodecache.Reader
+	// closing is closed when the server is stopping so that long lived streams end.
+	closing     chan struct{}
+	closingOnce sync.Once
 }
 
 // New returns a struct that holds the required details for the API engine.
@@ -47,46 +68,132 @@ func New(
 	logger logs.SysLogger,
 ) (e *HTTPEngine) {
 	httpEngine := &HTTPEngine{
-		logger:         logger,
-		state:          state,
-		appState:       appState,
-		worker:         worker,
-		chefLogsWorker: chefLogsWorker,
-		router:         mux.NewRouter(),
-		whitelists:     &customRunWhitelist{whitelist: []string{}},
+		logger:            logger,
+		state:             state,
+		appState:          appState,
+		worker:            worker,
+		chefLogsWorker:    chefLogsWorker,
+		health:            health.New(),
+		router:            mux.NewRouter(),
+		whitelists:        &customRunWhitelist{whitelist: []string{}},
+		legacyGetMutators: true,
+		closing:           make(chan struct{}),
 	}
+	httpEngine.router.NotFoundHandler = http.HandlerFunc(notFound)
+	httpEngine.router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
 
-	httpEngine.router.HandleFunc("/chefclient", httpEngine.registerChefRun).Methods("Get")
+	httpEngine.router.HandleFunc("/chefclient", httpEngine.legacyMutator(httpEngine.registerChefRun)).Methods("Get")
 	httpEngine.router.HandleFunc("/chefclient", httpEngine.registerChefCustomRun).Methods("Post")
 	httpEngine.router.HandleFunc("/chefclient/{guid}", httpEngine.getChefStatus).Methods("Get")
 	httpEngine.router.HandleFunc("/cheflogs/{guid}", httpEngine.getChefLogs).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/nextrun", httpEngine.getNextChefRun).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/interval", httpEngine.getChefRunInterval).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/interval/{i}", httpEngine.setChefRunInterval).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/on", httpEngine.setChefRunEnabled).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/off", httpEngine.setChefRunDisabled).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/interval/{i}", httpEngine.legacyMutator(httpEngine.setChefRunInterval)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/on", httpEngine.legacyMutator(httpEngine.setChefRunEnabled)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/off", httpEngine.legacyMutator(httpEngine.setChefRunDisabled)).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/lastrun", httpEngine.getLastRunGUID).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/allruns", httpEngine.getAllRuns).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/enabled", httpEngine.getChefPeridoicRunStatus).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/maintenance", httpEngine.getChefMaintenance).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/maintenance/start/{i}", httpEngine.setChefMaintenance).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/maintenance/end", httpEngine.removeChefMaintenance).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/maintenance/start/{i}", httpEngine.legacyMutator(httpEngine.setChefMaintenance)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/maintenance/end", httpEngine.legacyMutator(httpEngine.removeChefMaintenance)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/queue", httpEngine.getQueue).Methods("Get")
 	httpEngine.router.HandleFunc("/chef/lock", httpEngine.getChefLock).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/lock/set", httpEngine.setChefLock).Methods("Get")
-	httpEngine.router.HandleFunc("/chef/lock/remove", httpEngine.removeChefLock).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/lock/set", httpEngine.legacyMutator(httpEngine.setChefLock)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/lock/remove", httpEngine.legacyMutator(httpEngine.removeChefLock)).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/diagnostics", httpEngine.getDiagnostics).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/node", httpEngine.getNode).Methods("Get")
+	httpEngine.router.HandleFunc("/chef/failed-run", httpEngine.getFailedRun).Methods("Get")
 	httpEngine.router.HandleFunc("/status", httpEngine.getStatus).Methods("Get")
 	httpEngine.router.HandleFunc("/_status", httpEngine.getStatus).Methods("Get")
 	httpEngine.router.HandleFunc("/healthcheck", httpEngine.healthCheck).Methods("Get")
+	httpEngine.router.HandleFunc("/livez", httpEngine.liveCheck).Methods("Get")
+	httpEngine.router.HandleFunc("/readyz", httpEngine.readyCheck).Methods("Get")
+	httpEngine.router.HandleFunc("/openapi.json", httpEngine.getOpenAPI).Methods("Get")
+	httpEngine.router.HandleFunc("/events", httpEngine.streamEvents).Methods("Get")
+	httpEngine.registerV2Routes()
 
 	return httpEngine
 }
 
+// SetHealth sets the checks used by the health endpoints. Without it they always pass.
+func (e *HTTPEngine) SetHealth(checker *health.Checker) {
+	e.health = checker
+}
+
 // SetWhitelist is used to tell the server what custom runs are allowed.
 func (e *HTTPEngine) SetWhitelist(whitelist []string) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
 	e.whitelists.whitelist = whitelist
 	e.whitelists.use = true
 }
 
+// DisableWhitelist allows any custom run to be requested.
+func (e *HTTPEngine) DisableWhitelist() {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.whitelists.whitelist = []string{}
+	e.whitelists.use = false
+}
+
+// SetAllowedPolicies is used to tell the server what policies custom runs can use.
+func (e *HTTPEngine) SetAllowedPolicies(policies []internalstate.Policy) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.allowedPolicies = policies
+}
+
+// SetLegacyGetMutators is used to turn on or off the legacy GET endpoints that change state.
+// When turned off they will return a 405 and the /v2/ endpoints should be used instead.
+func (e *HTTPEngine) SetLegacyGetMutators(enabled bool) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.legacyGetMutators = enabled
+}
+
+// legacyMutator wraps the legacy GET handlers that change state so that they can be turned off.
+func (e *HTTPEngine) legacyMutator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e.settings.RLock()
+		enabled := e.legacyGetMutators
+		e.settings.RUnlock()
+		if !enabled {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET requests that change state are disabled. Use the /v2/ API.", map[string]string{"path": r.URL.Path})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// customRunAllowed checks the custom run text against the whitelist if it is in use.
+func (e *HTTPEngine) customRunAllowed(customRunText string) bool {
+	e.settings.RLock()
+	defer e.settings.RUnlock()
+	if !e.whitelists.use {
+		return true
+	}
+	for _, whitelistText := range e.whitelists.whitelist {
+		if customRunText == whitelistText {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAllowed checks the policy against the allowed policies. Unlike the custom run
+// whitelist it is always used.
+func (e *HTTPEngine) policyAllowed(policy internalstate.Policy) bool {
+	e.settings.RLock()
+	defer e.settings.RUnlock()
+	for _, allowed := range e.allowedPolicies {
+		if policy == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 // StartHTTPEngine will start the web server in a nonTLS mode.
 // It also requires that the listening address be passes in as a string.
 // Should be used in a go routine.
@@ -111,6 +218,8 @@ func (e *HTTPEngine) StopHTTPEngine() error {
 	// Stop the HTTP Engine
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFunc()
+	// Shutdown waits for requests to finish so the event streams must be told to end.
+	e.closingOnce.Do(func() { close(e.closing) })
 	return e.server.Shutdown(ctx)
 }
 
@@ -133,27 +242,25 @@ func printJSON(w http.ResponseWriter, jsonbytes []byte) (int, error) {
 
 // RegisterChefRun is called to run chef on the server.
 func (e *HTTPEngine) registerChefRun(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
 	if e.state.ReadRunLock() {
-		w.WriteHeader(http.StatusForbidden)
-		fmt.Fprint(w, "{\"Error\":\"Chefwaiter is locked\"}\n")
+		writeError(w, http.StatusForbidden, "locked", "Chefwaiter is locked", nil)
+		return
+	}
+	emergency, opts, ok := legacyRunOptions(w, r)
+	if !ok {
 		return
 	}
-	guid := e.worker.OnDemandRun()
-	logs.DebugMessage(fmt.Sprintf("registerChefRun() - %s", guid))
-	state := e.state.Read(guid)
-	jsonBytes, err := json.MarshalIndent(state, "", "  ")
+	sub, err := e.submitRun(false, "", emergency, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "{\"Error\":\"Failed to read guid status\"}\n")
+		writeRunError(w, err)
 		return
 	}
-	printJSON(w, jsonBytes)
+	logs.DebugMessage(fmt.Sprintf("registerChefRun() - %s", sub.GUID))
+	setRunResult(w, sub)
+	e.writeJobMap(w, sub.GUID)
 }
 
 func (e *HTTPEngine) registerChefCustomRun(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-
 	checklock := true
 
 	// Check if the server is locked unless we have an override URL parameter available.
@@ -167,8 +274,7 @@ func (e *HTTPEngine) registerChefCustomRun(w http.ResponseWriter, r *http.Reques
 
 	if checklock {
 		if e.state.ReadRunLock() {
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprint(w, "{\"Error\":\"Chefwaiter is locked\"}\n")
+			writeError(w, http.StatusForbidden, "locked", "Chefwaiter is locked", nil)
 			return
 		}
 	}
@@ -177,95 +283,142 @@ func (e *HTTPEngine) registerChefCustomRun(w http.ResponseWriter, r *http.Reques
 	bodySlurp := make([]byte, 513)
 	n, err := r.Body.Read(bodySlurp)
 	if err != nil && err != io.EOF {
-		w.WriteHeader(http.StatusBadRequest)
 		e.logger.Errorf("Request to custom job failed while reading the body. Error: %s", err)
+		writeBadBody(w, err)
 		return
 	}
 	if n > 512 {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "{\"Error\":\"Body sent is too large. Max size 512 bytes\"}\n")
+		writeError(w, http.StatusBadRequest, "bad_request", "Body sent is too large. Max size 512 bytes", nil)
 		return
 	}
 	customRunText := string(bytes.TrimRight(bodySlurp, "\x00"))
-	if e.whitelists.use {
-		matched := false
-		for _, whitelistText := range e.whitelists.whitelist {
-			if customRunText == whitelistText {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprintf(w, "{\"Error\":\"Whitelist does not contain '%s'\"}\n", customRunText)
-			return
-		}
+	if !e.customRunAllowed(customRunText) {
+		writeError(w, http.StatusForbidden, "not_whitelisted", fmt.Sprintf("Whitelist does not contain '%s'", customRunText), map[string]string{"custom_run": customRunText})
+		return
+	}
+	emergency, opts, ok := legacyRunOptions(w, r)
+	if !ok {
+		return
 	}
-	guid := e.worker.CustomRun(customRunText)
-	logs.DebugMessage(fmt.Sprintf("registerChefCustomRun() - %s", guid))
-	jsonbytes, err := jsonMarshal(e.state.Read(guid))
+	sub, err := e.submitRun(true, customRunText, emergency, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "{\"Error\":\"Failed to read guid status\"}\n")
+		writeRunError(w, err)
 		return
 	}
-	printJSON(w, jsonbytes)
+	logs.DebugMessage(fmt.Sprintf("registerChefCustomRun() - %s", sub.GUID))
+	setRunResult(w, sub)
+	e.writeJobMap(w, sub.GUID)
+}
+
+// submitRun hands the run to the worker using the priority requested.
+func (e *HTTPEngine) submitRun(custom bool, customRunText string, emergency bool, opts chefrunner.RunOptions) (chefrunner.Submission, error) {
+	switch {
+	case emergency:
+		return e.worker.EmergencyRun(customRunText, opts)
+	case custom:
+		return e.worker.CustomRun(customRunText, opts)
+	}
+	return e.worker.OnDemandRun(opts)
+}
+
+// legacyRunOptions reads the priority, coalesce and run metadata query parameters accepted by /chefclient.
+// label can be given many times as key=value. If they are not valid an error is written and ok is false.
+func legacyRunOptions(w http.ResponseWriter, r *http.Request) (emergency bool, opts chefrunner.RunOptions, ok bool) {
+	query := r.URL.Query()
+	emergency, ok = emergencyPriority(query.Get("priority"))
+	if !ok {
+		writeBadPriority(w)
+		return false, opts, false
+	}
+	coalesce, err := internalstate.ParseCoalesce(query.Get("coalesce"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return false, opts, false
+	}
+	labels, err := internalstate.ParseLabels(query["label"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return false, opts, false
+	}
+	metadata := internalstate.RunMetadata{
+		Requester:   query.Get("requester"),
+		Reason:      query.Get("reason"),
+		PipelineURL: query.Get("pipeline_url"),
+		Labels:      labels,
+	}
+	if err := metadata.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return false, opts, false
+	}
+	opts.Coalesce = coalesce
+	opts.Metadata = metadata
+	return emergency, opts, true
+}
+
+// getQueue writes the jobs that are waiting to run in the order they will run.
+func (e *HTTPEngine) getQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, queueResponse(e.worker.Queue()))
 }
 
 // GetChefStatus - writes the state of the requested guid.
 func (e *HTTPEngine) getChefStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	logs.DebugMessage(fmt.Sprintf("getChefStatus() - %s", vars["guid"]))
-	setContentJSON(w)
-	status := e.state.Read(vars["guid"])
-	jsonBytes, err := jsonMarshal(status)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "{\"Error\":\"Failed to read guid status\"}\n")
+	e.writeJobMap(w, vars["guid"])
+}
+
+// writeJobMap writes a job in the legacy form of a map keyed by the guid.
+func (e *HTTPEngine) writeJobMap(w http.ResponseWriter, guid string) {
+	job, ok := e.state.ReadJob(guid)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s not found", guid), map[string]string{"guid": guid})
 		return
 	}
-	printJSON(w, jsonBytes)
+	writeJSON(w, http.StatusOK, jobMapResponse{guid: job})
 }
 
 // GetStatus - Writes the applications internal status in json to the http writer.
 func (e *HTTPEngine) getStatus(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
 	state, err := e.appState.JSONEncoded()
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Failed to read the status", map[string]string{"reason": err.Error()})
+		return
 	}
+	setContentJSON(w)
 	w.Write(state)
 	fmt.Fprint(w, "\n")
 }
 
-// HealthCheck - Writes a HealthCheck message that can be used to check the state
-// of the chef waiter.
-func (e *HTTPEngine) healthCheck(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	fmt.Fprint(w, "{\"state\": \"OK\"}")
-}
-
 // getChefLogs - is responsible for displaying the chef logs that have been created
 // by a chef run.
 func (e *HTTPEngine) getChefLogs(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	// Set the content type
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	guid := mux.Vars(r)["guid"]
+	e.writeLogFile(w, guid, e.chefLogsWorker.IsLogAvailable(guid), e.chefLogsWorker.GetLogPath(guid))
+}
+
+// getHookLogs - displays the output of the pre and post run hooks of a run.
+func (e *HTTPEngine) getHookLogs(w http.ResponseWriter, r *http.Request) {
+	guid := mux.Vars(r)["guid"]
+	e.writeLogFile(w, guid, e.chefLogsWorker.IsHookLogAvailable(guid), e.chefLogsWorker.GetHookLogPath(guid))
+}
+
+// writeLogFile writes out a log file for a guid as plain text.
+// unavailable is the error from checking for the file and gives a 404 if it is set.
+func (e *HTTPEngine) writeLogFile(w http.ResponseWriter, guid string, unavailable error, path string) {
 	// We first need to look for the log file.
 	// Throw a 404 if the file is not there
-	if err := e.chefLogsWorker.IsLogAvailable(vars["guid"]); err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		logs.DebugMessage(fmt.Sprintf("Unavailable: %s, %s", e.chefLogsWorker.GetLogPath(vars["guid"]), err))
-		fmt.Fprintf(w, "404 - %s not found\n", vars["guid"])
+	if unavailable != nil {
+		logs.DebugMessage(fmt.Sprintf("Unavailable: %s, %s", path, unavailable))
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s not found", guid), map[string]string{"guid": guid})
 		return
 	}
-	logs.DebugMessage(fmt.Sprintf("Found: %s", e.chefLogsWorker.GetLogPath(vars["guid"])))
+	logs.DebugMessage(fmt.Sprintf("Found: %s", path))
 
 	// If it is there then we need to read it out.
-	file, err := os.Open(e.chefLogsWorker.GetLogPath(vars["guid"]))
+	file, err := os.Open(path)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		e.logger.Errorf("Failed to open %s: %v", e.chefLogsWorker.GetLogPath(vars["guid"]), err)
+		e.logger.Errorf("Failed to open %s: %v", path, err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to open the log file", map[string]string{"guid": guid})
 		return
 	}
 	// remember to close it at the end.
@@ -273,6 +426,7 @@ func (e *HTTPEngine) getChefLogs(w http.ResponseWriter, r *http.Request) {
 
 	// At this point we are about to read out the file so it is safe to
 	// write the headers for OK Status.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
 	scanner := bufio.NewScanner(file)
@@ -285,123 +439,128 @@ func (e *HTTPEngine) getChefLogs(w http.ResponseWriter, r *http.Request) {
 }
 
 func (e *HTTPEngine) getNextChefRun(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	w.WriteHeader(http.StatusOK)
 	// json string with epoch and string time
 	epoch := e.state.GetlastRunStartTime() + e.state.ReadChefRunTimer()
-	next := &struct {
-		Epoch int64  `json:"epoch"`
-		Str   string `json:"human"`
-	}{
+	writeJSON(w, http.StatusOK, &nextRunResponse{
 		Epoch: epoch,
-		Str:   time.Unix(epoch, 0).String(),
-	}
-	json.NewEncoder(w).Encode(next)
+		Human: time.Unix(epoch, 0).String(),
+	})
 }
 
 func (e *HTTPEngine) setChefRunInterval(w http.ResponseWriter, r *http.Request) {
 	// check if the string is a number and is positive
-	setContentJSON(w)
 	vars := mux.Vars(r)
 	i, err := strconv.Atoi(vars["i"])
-	if err != nil || i < 0 {
-		e.logger.Errorf("/chef/interval/%s is not a positive number", vars["i"])
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "{\"Error\":\"Only a positive number will be accepted\"}\n")
-		return
-	}
-	if i <= 0 {
+	if err != nil || i <= 0 {
 		e.logger.Errorf("/chef/interval/%s is not a positive number", vars["i"])
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "{\"Error\":\"Only a positive number will be accepted\"}\n")
+		writeError(w, http.StatusBadRequest, "bad_request", "Only a positive number will be accepted", map[string]string{"interval": vars["i"]})
 		return
 	}
 
 	e.state.WriteChefRunTimer(int64(i))
+	e.getChefRunInterval(w, r)
 }
 
 func (e *HTTPEngine) getChefRunInterval(w http.ResponseWriter, r *http.Request) {
-	i := e.state.ReadChefRunTimer()
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"current_interval\":\"%d minutes\"}\n", i/60)
+	minutes := e.state.ReadChefRunTimer() / 60
+	writeJSON(w, http.StatusOK, &intervalResponse{
+		CurrentInterval: fmt.Sprintf("%d minutes", minutes),
+		Minutes:         minutes,
+	})
 }
 
 // setChefRunEnabled - enables periodic runs
 func (e *HTTPEngine) setChefRunEnabled(w http.ResponseWriter, r *http.Request) {
 	e.state.WritePeriodicRuns(true)
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"chef_runs_enabled\":%v}\n", e.state.ReadPeriodicRuns())
+	e.getChefPeridoicRunStatus(w, r)
 }
 
 // setChefRunDisabled - disables periodic runs
 func (e *HTTPEngine) setChefRunDisabled(w http.ResponseWriter, r *http.Request) {
 	e.state.WritePeriodicRuns(false)
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"chef_runs_enabled\":%v}\n", e.state.ReadPeriodicRuns())
+	e.getChefPeridoicRunStatus(w, r)
 }
 
 // getChefPeridoicRunStatus - returns details about if periodic runs are enabled.
 func (e *HTTPEngine) getChefPeridoicRunStatus(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"chef_runs_enabled\":%v}\n", e.state.ReadPeriodicRuns())
+	writeJSON(w, http.StatusOK, &periodicResponse{ChefRunsEnabled: e.state.ReadPeriodicRuns()})
 }
 
 func (e *HTTPEngine) getLastRunGUID(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"last_run_guid\":\"%s\"}\n", e.state.ReadLastRunGUID())
+	writeJSON(w, http.StatusOK, &lastRunResponse{LastRunGUID: e.state.ReadLastRunGUID()})
 }
 
+// getAllRuns writes the jobs keyed by guid. With format=list they are written as an
+// ordered list instead. Both forms can be filtered, sorted and paged.
 func (e *HTTPEngine) getAllRuns(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	jobs := e.state.ReadAllJobs()
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "map" && format != "list" {
+		writeError(w, http.StatusBadRequest, "bad_request", "format must be map or list", map[string]string{"format": format})
+		return
+	}
+	jobs, ok := e.listRuns(w, r)
+	if !ok {
+		return
+	}
+	if format == "list" {
+		writeJSON(w, http.StatusOK, jobs)
+		return
+	}
+	jobMap := make(jobMapResponse, len(jobs))
+	for _, job := range jobs {
+		jobMap[job.GUID] = job.JobDetails
+	}
+	writeJSON(w, http.StatusOK, jobMap)
+}
 
-	jsonJobs, err := json.MarshalIndent(jobs, "", "  ")
+// listRuns returns the jobs selected by the query parameters and sets the total count header.
+// If the parameters are not valid an error is written and ok is false.
+func (e *HTTPEngine) listRuns(w http.ResponseWriter, r *http.Request) ([]jobResponse, bool) {
+	filter, err := parseRunFilter(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "{\"Error\":\"Failed to gather jobs\"}\n")
-		return
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return nil, false
 	}
-	fmt.Fprint(w, string(jsonJobs), "\n")
+	jobs, total := filter.list(e.state.ReadAllJobs())
+	w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	return jobs, true
 }
 
 func (e *HTTPEngine) getChefMaintenance(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"end_time\":\"%s\", \"in_maintenance\":%v}\n", time.Unix(e.state.ReadMaintenanceTimeEnd(), 0), e.state.InMaintenceMode())
+	endTime := e.state.ReadMaintenanceTimeEnd()
+	writeJSON(w, http.StatusOK, &maintenanceResponse{
+		EndTime:       time.Unix(endTime, 0).String(),
+		EndTimeEpoch:  endTime,
+		InMaintenance: e.state.InMaintenceMode(),
+	})
 }
-func (e *HTTPEngine) setChefMaintenance(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
 
+func (e *HTTPEngine) setChefMaintenance(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	minutes, err := strconv.Atoi(vars["i"])
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err != nil || minutes <= 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "Only a positive number will be accepted", map[string]string{"minutes": vars["i"]})
 		return
 	}
-	endTime := time.Now().Unix() + int64(minutes*60)
-	e.state.WriteMaintenanceTimeEnd(endTime)
-	fmt.Fprintf(w, "{\"end_time\":\"%s\"}\n", time.Unix(endTime, 0))
+	e.state.WriteMaintenanceTimeEnd(time.Now().Unix() + int64(minutes*60))
+	e.getChefMaintenance(w, r)
 }
 
 func (e *HTTPEngine) removeChefMaintenance(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-
 	e.state.WriteMaintenanceTimeEnd(0)
-	fmt.Fprintf(w, "{\"end_time\":\"%s\"}\n", time.Unix(e.state.ReadMaintenanceTimeEnd(), 0))
+	e.getChefMaintenance(w, r)
 }
 
 func (e *HTTPEngine) getChefLock(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	fmt.Fprintf(w, "{\"Locked\": %t}\n", e.state.ReadRunLock())
+	writeJSON(w, http.StatusOK, &lockResponse{Locked: e.state.ReadRunLock(), Reason: e.state.ReadLockReason()})
 }
 
 func (e *HTTPEngine) setChefLock(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
-	e.state.LockRuns(true)
-	fmt.Fprintf(w, "{\"Locked\": %t}\n", e.state.ReadRunLock())
+	e.state.LockRunsWithReason(r.URL.Query().Get("reason"))
+	e.getChefLock(w, r)
 }
 
 func (e *HTTPEngine) removeChefLock(w http.ResponseWriter, r *http.Request) {
-	setContentJSON(w)
 	e.state.LockRuns(false)
-	fmt.Fprintf(w, "{\"Locked\": %t}\n", e.state.ReadRunLock())
+	e.getChefLock(w, r)
 }
@@ -77,6 +77,7 @@ func genNewHTTPServer(t *testing.T, logOutput bool, debuglogs bool) *HTTPEngine
 	internalstate := internalstate.New(config, cheflogsworker, logger)
 	appstate := NewFakeAppStatus()
 	worker := chefrunner.NewFakeChefRunnerWorker(false)
+	worker.State = internalstate
 	return New(internalstate, appstate, worker, cheflogsworker, logger)
 }
 
@@ -98,7 +99,7 @@ func TestLock(t *testing.T) {
 	webEngine := genNewHTTPServer(t, true, false)
 
 	type returnJSON struct {
-		Locked bool `json:"Locked"`
+		Locked bool `json:"locked"`
 	}
 
 	testSquecence := []struct {
@@ -144,15 +145,15 @@ func TestLock(t *testing.T) {
 			)
 		}
 		if tc.registerChef {
-			JSONResponce := &struct{ Error string }{}
+			JSONResponce := &errorEnvelope{}
 			if err := json.Unmarshal(body, JSONResponce); err != nil {
 				t.Errorf("%s: failed to pull the body out the request. Error: %s", tc.name, err)
 			} else {
-				if errMsg := "Chefwaiter is locked"; JSONResponce.Error != errMsg {
+				if errMsg := "Chefwaiter is locked"; JSONResponce.Error.Message != errMsg {
 					t.Errorf("%s: Error message is not correct. Want: %s, Got: %s",
 						tc.name,
 						errMsg,
-						JSONResponce.Error,
+						JSONResponce.Error.Message,
 					)
 				}
 			}
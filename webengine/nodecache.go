@@ -0,0 +1,64 @@
+package webengine
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/morfien101/chef-waiter/nodecache"
+)
+
+// SetNodeCache sets where the chef cache is and the node attributes that can be served.
+// Without it the node cache endpoints return a 503.
+func (e *HTTPEngine) SetNodeCache(cacheDir, nodeFile string, attributes []string) {
+	e.settings.Lock()
+	defer e.settings.Unlock()
+	e.nodeCache = &nodecache.Reader{CacheDir: cacheDir, NodeFile: nodeFile, Attributes: attributes}
+}
+
+func (e *HTTPEngine) nodeCacheReader(w http.ResponseWriter) (*nodecache.Reader, bool) {
+	e.settings.RLock()
+	defer e.settings.RUnlock()
+	if e.nodeCache == nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "The chef cache is not set up", nil)
+		return nil, false
+	}
+	return e.nodeCache, true
+}
+
+// getNode writes the run list, environment, policy and allowed attributes of the node
+// from the chef cache.
+func (e *HTTPEngine) getNode(w http.ResponseWriter, r *http.Request) {
+	reader, ok := e.nodeCacheReader(w)
+	if !ok {
+		return
+	}
+	node, err := reader.Node()
+	if err != nil {
+		writeCacheError(w, "Chef has not written the node to its cache", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+// getFailedRun writes the error and updated resources from the last failed chef run.
+func (e *HTTPEngine) getFailedRun(w http.ResponseWriter, r *http.Request) {
+	reader, ok := e.nodeCacheReader(w)
+	if !ok {
+		return
+	}
+	run, err := reader.FailedRun()
+	if err != nil {
+		writeCacheError(w, "No failed run data in the chef cache", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// writeCacheError writes a 404 with notFound when the file is not in the chef cache.
+func writeCacheError(w http.ResponseWriter, notFound string, err error) {
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "not_found", notFound, nil)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "internal_error", "Failed to read the chef cache", map[string]string{"reason": err.Error()})
+}
@@ -0,0 +1,63 @@
+package webengine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/morfien101/chef-waiter/nodecache"
+)
+
+func TestNodeCache(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url(path), nil))
+		return w
+	}
+	if w := get("/chef/node"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("The node cache that is not set up should return a 503. Got: %d", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "nodecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	node := `{"name": "web01", "run_list": ["recipe[nginx]"], "default": {"nginx": {"port": 80}, "secret": "hunter2"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "node.json"), []byte(node), 0644); err != nil {
+		t.Fatal(err)
+	}
+	webEngine.SetNodeCache(dir, "node.json", []string{"nginx.port"})
+
+	w := get("/chef/node")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/chef/node returned %d. Body: %s", w.Code, w.Body)
+	}
+	response := nodecache.Node{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Name != "web01" || len(response.Attributes) != 1 || response.Attributes["nginx.port"] != float64(80) {
+		t.Errorf("Only the allowed attributes should be served. Got: %+v", response)
+	}
+
+	w = get("/chef/failed-run")
+	envelope := errorEnvelope{}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || envelope.Error.Code != "not_found" {
+		t.Errorf("A node without a failed run should return not_found. Got: %d %s", w.Code, w.Body)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, nodecache.FailedRunFile), []byte(`{"run_id": "1234", "exception": "boom"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w = get("/chef/failed-run"); w.Code != http.StatusOK {
+		t.Errorf("/chef/failed-run returned %d. Body: %s", w.Code, w.Body)
+	}
+}
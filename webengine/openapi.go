@@ -0,0 +1,288 @@
+package webengine
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/morfien101/chef-waiter/diagnostics"
+	"github.com/morfien101/chef-waiter/events"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/nodecache"
+)
+
+// openAPIVersion is the version of the API described by the document.
+// It should be bumped when the routes or schemas change.
+const openAPIVersion = "2.19.0"
+
+// spec is a short hand for the nested JSON objects that make up the OpenAPI document.
+type spec map[string]interface{}
+
+// openAPISchemas are the named schemas that are referenced by the operations.
+// The schemas are built from the same types that the handlers encode so they can't drift.
+var openAPISchemas = map[string]interface{}{
+	"Error":              errorEnvelope{},
+	"Job":                jobResponse{},
+	"CreatedJob":         createRunResponse{},
+	"JobMap":             jobMapResponse{},
+	"JobList":            []jobResponse{},
+	"Interval":           intervalResponse{},
+	"Periodic":           periodicResponse{},
+	"Maintenance":        maintenanceResponse{},
+	"Lock":               lockResponse{},
+	"LastRun":            lastRunResponse{},
+	"NextRun":            nextRunResponse{},
+	"Health":             healthResponse{},
+	"Queue":              queueResponse{},
+	"Diagnostics":        diagnostics.Report{},
+	"Node":               nodecache.Node{},
+	"FailedRun":          nodecache.FailedRun{},
+	"Event":              events.Event{},
+	"Status":             internalstate.AppStatus{},
+	"RunRequest":         v2RunRequest{},
+	"IntervalRequest":    v2IntervalRequest{},
+	"PeriodicRequest":    v2PeriodicRequest{},
+	"MaintenanceRequest": v2MaintenanceRequest{},
+	"LockRequest":        v2LockRequest{},
+}
+
+// openAPIOperation describes a single route in the OpenAPI document.
+type openAPIOperation struct {
+	path        string
+	method      string
+	id          string
+	summary     string
+	request     string
+	optional    bool
+	response    string
+	status      int
+	contentType string
+	params      []spec
+}
+
+var (
+	guidParam     = spec{"name": "guid", "in": "path", "required": true, "schema": spec{"type": "string"}}
+	intervalParam = spec{"name": "i", "in": "path", "required": true, "schema": spec{"type": "integer"}}
+	forceParam    = spec{"name": "force", "in": "query", "required": false, "schema": spec{"type": "string", "enum": []string{"true"}}}
+	reasonParam   = spec{"name": "reason", "in": "query", "required": false, "schema": spec{"type": "string"}}
+	coalesceParam = spec{"name": "coalesce", "in": "query", "required": false, "schema": spec{"type": "string", "pattern": "^(join-queued|always-new|after-timestamp=[0-9]+)$"}}
+	priorityParam = spec{"name": "priority", "in": "query", "required": false, "schema": spec{"type": "string", "enum": []string{"emergency"}}}
+	// The run metadata and filters use the same query parameters.
+	requesterParam   = spec{"name": "requester", "in": "query", "required": false, "schema": spec{"type": "string"}}
+	pipelineURLParam = spec{"name": "pipeline_url", "in": "query", "required": false, "schema": spec{"type": "string"}}
+	labelParam       = spec{"name": "label", "in": "query", "required": false, "description": "key=value, can be repeated.", "schema": spec{"type": "array", "items": spec{"type": "string"}}, "explode": true}
+	// listRunsParams filter, sort and page the run lists.
+	listRunsParams = []spec{
+		requesterParam,
+		pipelineURLParam,
+		labelParam,
+		{"name": "status", "in": "query", "required": false, "description": "Comma separated statuses.", "schema": spec{"type": "string"}},
+		{"name": "type", "in": "query", "required": false, "description": "Comma separated list of periodic, ondemand and custom.", "schema": spec{"type": "string"}},
+		{"name": "since", "in": "query", "required": false, "description": "Only runs registered at or after this epoch time.", "schema": spec{"type": "integer", "minimum": 0}},
+		{"name": "until", "in": "query", "required": false, "description": "Only runs registered at or before this epoch time.", "schema": spec{"type": "integer", "minimum": 0}},
+		{"name": "sort", "in": "query", "required": false, "schema": spec{"type": "string", "enum": []string{sortNewest, sortOldest}}},
+		{"name": "limit", "in": "query", "required": false, "description": "Most runs to return. 0 returns every run.", "schema": spec{"type": "integer", "minimum": 0}},
+		{"name": "offset", "in": "query", "required": false, "schema": spec{"type": "integer", "minimum": 0}},
+	}
+	eventTypesParam = spec{"name": "types", "in": "query", "required": false, "description": "Comma separated event types to send.", "schema": spec{"type": "string"}}
+	verboseParam    = spec{"name": "verbose", "in": "query", "required": false, "description": "true adds the result of every check.", "schema": spec{"type": "string", "enum": []string{"true"}}}
+	sha256Param     = spec{"name": "sha256", "in": "query", "required": true, "description": "Hex sha256 checksum of the body.", "schema": spec{"type": "string", "pattern": "^[0-9a-fA-F]{64}$"}}
+	runListParam    = spec{"name": "run_list", "in": "query", "required": false, "description": "Run list to use instead of the one in the bundle.", "schema": spec{"type": "string"}}
+	formatParam     = spec{"name": "format", "in": "query", "required": false, "description": "list returns an ordered JobList rather than a JobMap.", "schema": spec{"type": "string", "enum": []string{"map", "list"}}}
+)
+
+// openAPIOperations lists every route that the HTTPEngine serves.
+var openAPIOperations = []openAPIOperation{
+	{path: "/chefclient", method: http.MethodGet, id: "legacyRegisterRun", summary: "Register an on demand run.", response: "JobMap", params: []spec{priorityParam, coalesceParam, requesterParam, reasonParam, pipelineURLParam, labelParam}},
+	{path: "/chefclient", method: http.MethodPost, id: "legacyRegisterCustomRun", summary: "Register a custom run. The body is the plain text run list.", response: "JobMap", contentType: "text/plain", params: []spec{forceParam, priorityParam, coalesceParam, requesterParam, reasonParam, pipelineURLParam, labelParam}},
+	{path: "/chefclient/{guid}", method: http.MethodGet, id: "legacyGetRun", summary: "Get a run by guid.", response: "JobMap", params: []spec{guidParam}},
+	{path: "/cheflogs/{guid}", method: http.MethodGet, id: "legacyGetLogs", summary: "Get the chef logs for a run.", response: "text", params: []spec{guidParam}},
+	{path: "/chef/nextrun", method: http.MethodGet, id: "getNextRun", summary: "Get the time of the next periodic run.", response: "NextRun"},
+	{path: "/chef/interval", method: http.MethodGet, id: "legacyGetInterval", summary: "Get the periodic run interval.", response: "Interval"},
+	{path: "/chef/interval/{i}", method: http.MethodGet, id: "legacySetInterval", summary: "Set the periodic run interval in minutes.", response: "Interval", params: []spec{intervalParam}},
+	{path: "/chef/on", method: http.MethodGet, id: "legacyEnablePeriodic", summary: "Turn on periodic runs.", response: "Periodic"},
+	{path: "/chef/off", method: http.MethodGet, id: "legacyDisablePeriodic", summary: "Turn off periodic runs.", response: "Periodic"},
+	{path: "/chef/lastrun", method: http.MethodGet, id: "getLastRun", summary: "Get the guid of the last run.", response: "LastRun"},
+	{path: "/chef/allruns", method: http.MethodGet, id: "legacyListRuns", summary: "Get all runs keyed by guid, or as an ordered list with format=list.", response: "JobMap|JobList", params: append([]spec{formatParam}, listRunsParams...)},
+	{path: "/chef/enabled", method: http.MethodGet, id: "legacyGetPeriodic", summary: "Get if periodic runs are enabled.", response: "Periodic"},
+	{path: "/chef/maintenance", method: http.MethodGet, id: "legacyGetMaintenance", summary: "Get the maintenance window.", response: "Maintenance"},
+	{path: "/chef/maintenance/start/{i}", method: http.MethodGet, id: "legacyStartMaintenance", summary: "Start maintenance for i minutes.", response: "Maintenance", params: []spec{intervalParam}},
+	{path: "/chef/maintenance/end", method: http.MethodGet, id: "legacyEndMaintenance", summary: "End maintenance.", response: "Maintenance"},
+	{path: "/chef/queue", method: http.MethodGet, id: "getQueue", summary: "Get the jobs waiting to run in the order they will run.", response: "Queue"},
+	{path: "/chef/lock", method: http.MethodGet, id: "legacyGetLock", summary: "Get the run lock.", response: "Lock"},
+	{path: "/chef/lock/set", method: http.MethodGet, id: "legacySetLock", summary: "Set the run lock.", response: "Lock", params: []spec{reasonParam}},
+	{path: "/chef/lock/remove", method: http.MethodGet, id: "legacyRemoveLock", summary: "Remove the run lock.", response: "Lock"},
+	{path: "/chef/diagnostics", method: http.MethodGet, id: "getDiagnostics", summary: "Check the client.rb, client key, connection to the chef server and clock skew.", response: "Diagnostics"},
+	{path: "/chef/node", method: http.MethodGet, id: "getNode", summary: "Get the run list, environment, policy and allowed attributes of the node from the chef cache.", response: "Node"},
+	{path: "/chef/failed-run", method: http.MethodGet, id: "getFailedRun", summary: "Get the error and updated resources from the last failed chef run.", response: "FailedRun"},
+	{path: "/status", method: http.MethodGet, id: "legacyGetStatus", summary: "Get the chef waiter status.", response: "Status"},
+	{path: "/_status", method: http.MethodGet, id: "legacyGetInternalStatus", summary: "Get the chef waiter status.", response: "Status"},
+	{path: "/healthcheck", method: http.MethodGet, id: "healthCheck", summary: "Get the health of the chef waiter. Returns 503 if a check failed.", response: "Health", params: []spec{verboseParam}},
+	{path: "/livez", method: http.MethodGet, id: "liveCheck", summary: "Run the liveness checks. Returns 503 if the chef waiter needs a restart.", response: "Health", params: []spec{verboseParam}},
+	{path: "/readyz", method: http.MethodGet, id: "readyCheck", summary: "Run every check. Returns 503 if the chef waiter can't do its job right now.", response: "Health", params: []spec{verboseParam}},
+	{path: "/openapi.json", method: http.MethodGet, id: "getOpenAPI", summary: "Get this document.", response: "object"},
+	{path: "/events", method: http.MethodGet, id: "streamEvents", summary: "Stream changes of state as Server-Sent Events.", response: "events", params: []spec{eventTypesParam}},
+	{path: "/v2/runs", method: http.MethodPost, id: "createRun", summary: "Create an on demand or custom run.", request: "RunRequest", optional: true, response: "CreatedJob", status: http.StatusAccepted},
+	{path: "/v2/runs/bundle", method: http.MethodPost, id: "createBundleRun", summary: "Run an uploaded gzipped tar of cookbooks or a policy archive in local mode.", response: "CreatedJob", status: http.StatusAccepted, contentType: "application/gzip", params: []spec{sha256Param, runListParam, priorityParam, requesterParam, reasonParam, pipelineURLParam, labelParam}},
+	{path: "/v2/runs", method: http.MethodGet, id: "listRuns", summary: "List all runs, newest first.", response: "JobList", params: listRunsParams},
+	{path: "/v2/runs/{guid}", method: http.MethodGet, id: "getRun", summary: "Get a run by guid.", response: "Job", params: []spec{guidParam}},
+	{path: "/v2/runs/{guid}/logs", method: http.MethodGet, id: "getRunLogs", summary: "Get the chef logs for a run.", response: "text", params: []spec{guidParam}},
+	{path: "/v2/runs/{guid}/hooks", method: http.MethodGet, id: "getRunHookLogs", summary: "Get the output of the pre and post run hooks for a run.", response: "text", params: []spec{guidParam}},
+	{path: "/v2/interval", method: http.MethodGet, id: "getInterval", summary: "Get the periodic run interval.", response: "Interval"},
+	{path: "/v2/interval", method: http.MethodPut, id: "setInterval", summary: "Set the periodic run interval.", request: "IntervalRequest", response: "Interval"},
+	{path: "/v2/periodic", method: http.MethodGet, id: "getPeriodic", summary: "Get if periodic runs are enabled.", response: "Periodic"},
+	{path: "/v2/periodic", method: http.MethodPut, id: "setPeriodic", summary: "Turn periodic runs on or off.", request: "PeriodicRequest", response: "Periodic"},
+	{path: "/v2/maintenance", method: http.MethodGet, id: "getMaintenance", summary: "Get the maintenance window.", response: "Maintenance"},
+	{path: "/v2/maintenance", method: http.MethodPut, id: "startMaintenance", summary: "Start a maintenance window.", request: "MaintenanceRequest", response: "Maintenance"},
+	{path: "/v2/maintenance", method: http.MethodDelete, id: "endMaintenance", summary: "End the maintenance window.", response: "Maintenance"},
+	{path: "/v2/lock", method: http.MethodGet, id: "getLock", summary: "Get the run lock.", response: "Lock"},
+	{path: "/v2/lock", method: http.MethodPut, id: "setLock", summary: "Set the run lock with an optional reason.", request: "LockRequest", optional: true, response: "Lock"},
+	{path: "/v2/lock", method: http.MethodDelete, id: "removeLock", summary: "Remove the run lock.", response: "Lock"},
+	{path: "/v2/status", method: http.MethodGet, id: "getStatus", summary: "Get the chef waiter status.", response: "Status"},
+}
+
+// openAPIDocument builds the OpenAPI 3 document that describes the API.
+func openAPIDocument() spec {
+	schemas := spec{}
+	for name, value := range openAPISchemas {
+		schemas[name] = schemaFor(reflect.TypeOf(value))
+	}
+
+	paths := spec{}
+	for _, op := range openAPIOperations {
+		if _, ok := paths[op.path]; !ok {
+			paths[op.path] = spec{}
+		}
+		paths[op.path].(spec)[strings.ToLower(op.method)] = op.toSpec()
+	}
+
+	return spec{
+		"openapi": "3.0.3",
+		"info": spec{
+			"title":       "Chef Waiter",
+			"description": "API that allows you to control the chef client remotely.",
+			"version":     openAPIVersion,
+		},
+		"paths":      paths,
+		"components": spec{"schemas": schemas},
+	}
+}
+
+func (op openAPIOperation) toSpec() spec {
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responses := spec{
+		strconv.Itoa(status): spec{
+			"description": http.StatusText(status),
+			"content":     contentFor(op.response),
+		},
+		"default": spec{
+			"description": "Error",
+			"content":     contentFor("Error"),
+		},
+	}
+	operation := spec{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"responses":   responses,
+	}
+	if len(op.params) > 0 {
+		operation["parameters"] = op.params
+	}
+	if op.request != "" {
+		operation["requestBody"] = spec{"required": !op.optional, "content": contentFor(op.request)}
+	}
+	if op.contentType != "" {
+		operation["requestBody"] = spec{"required": true, "content": spec{op.contentType: spec{"schema": spec{"type": "string"}}}}
+	}
+	return operation
+}
+
+// contentFor returns the content block for a named schema.
+// "text" is used for plain text responses and "object" for free form JSON.
+// "events" is a stream of Event schemas. Names joined with | are a response that can
+// be any one of the schemas.
+func contentFor(name string) spec {
+	switch name {
+	case "text":
+		return spec{"text/plain": spec{"schema": spec{"type": "string"}}}
+	case "events":
+		return spec{"text/event-stream": spec{"schema": spec{"$ref": "#/components/schemas/Event"}}}
+	case "object":
+		return spec{"application/json": spec{"schema": spec{"type": "object"}}}
+	}
+	if strings.Contains(name, "|") {
+		refs := []spec{}
+		for _, one := range strings.Split(name, "|") {
+			refs = append(refs, spec{"$ref": "#/components/schemas/" + one})
+		}
+		return spec{"application/json": spec{"schema": spec{"oneOf": refs}}}
+	}
+	return spec{"application/json": spec{"schema": spec{"$ref": "#/components/schemas/" + name}}}
+}
+
+// schemaFor will describe a Go type as a JSON schema using the json struct tags.
+func schemaFor(t reflect.Type) spec {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return spec{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return spec{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return spec{"type": "number"}
+	case reflect.String:
+		return spec{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return spec{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return spec{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := spec{}
+		addStructProperties(t, properties)
+		return spec{"type": "object", "properties": properties}
+	}
+	return spec{}
+}
+
+// addStructProperties adds the fields of a struct to the properties.
+// Embedded structs are flattened in the same way that encoding/json does.
+func addStructProperties(t reflect.Type, properties spec) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructProperties(ft, properties)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type)
+	}
+}
+
+// getOpenAPI writes the OpenAPI document for this API.
+func (e *HTTPEngine) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
@@ -0,0 +1,136 @@
+package webengine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+
+	documented := make(map[string]bool)
+	for _, op := range openAPIOperations {
+		documented[op.method+" "+op.path] = true
+	}
+
+	served := make(map[string]bool)
+	err := webEngine.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Path prefixes don't have methods.
+			return nil
+		}
+		for _, method := range methods {
+			served[strings.ToUpper(method)+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk the router. Error: %s", err)
+	}
+
+	for route := range served {
+		if !documented[route] {
+			t.Errorf("%s is served but not in the OpenAPI document", route)
+		}
+	}
+	for route := range documented {
+		if !served[route] {
+			t.Errorf("%s is in the OpenAPI document but not served", route)
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, url("/openapi.json"), nil)
+	webEngine.ServeHTTP(w, r)
+	result := w.Result()
+	body, err := ioutil.ReadAll(result.Body)
+	result.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read the body. Error: %s", err)
+	}
+	if result.StatusCode != http.StatusOK {
+		t.Fatalf("/openapi.json returned %d", result.StatusCode)
+	}
+
+	doc := struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("/openapi.json is not valid JSON. Error: %s", err)
+	}
+	if doc.OpenAPI == "" {
+		t.Error("openapi version is missing")
+	}
+	// Embedded structs must be flattened like encoding/json does.
+	for _, property := range []string{"guid", "status", "exitcode"} {
+		if _, ok := doc.Components.Schemas["Job"].Properties[property]; !ok {
+			t.Errorf("Job schema is missing %s", property)
+		}
+	}
+	for _, property := range []string{"code", "message", "details"} {
+		errorSchema := doc.Components.Schemas["Error"].Properties["error"].(map[string]interface{})
+		if _, ok := errorSchema["properties"].(map[string]interface{})[property]; !ok {
+			t.Errorf("Error schema is missing %s", property)
+		}
+	}
+}
+
+func TestErrorEnvelopes(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+
+	tests := []struct {
+		name         string
+		url          string
+		expectedCode int
+		errorCode    string
+	}{
+		{name: "Missing job", url: "/chefclient/nope", expectedCode: http.StatusNotFound, errorCode: "not_found"},
+		{name: "Bad maintenance", url: "/chef/maintenance/start/abc", expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Bad interval", url: "/chef/interval/abc", expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Unknown route", url: "/nothing/here", expectedCode: http.StatusNotFound, errorCode: "not_found"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url(test.url), nil)
+		webEngine.ServeHTTP(w, r)
+		result := w.Result()
+		body, _ := ioutil.ReadAll(result.Body)
+		result.Body.Close()
+
+		if result.StatusCode != test.expectedCode {
+			t.Errorf("%s: Got status %d, want %d", test.name, result.StatusCode, test.expectedCode)
+		}
+		if ct := result.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: Content-Type is %s, want JSON", test.name, ct)
+		}
+		envelope := &errorEnvelope{}
+		if err := json.Unmarshal(body, envelope); err != nil {
+			t.Errorf("%s: Body is not an error envelope. Error: %s, Body: %s", test.name, err, body)
+			continue
+		}
+		if envelope.Error.Code != test.errorCode {
+			t.Errorf("%s: Got code %s, want %s", test.name, envelope.Error.Code, test.errorCode)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package webengine
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/health"
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+// maxJSONBodySize is the largest JSON request body that the API will read.
+const maxJSONBodySize = 4096
+
+// apiError is the body of every error returned by the API.
+// Code is a short machine readable string, Message is for humans and Details
+// can carry extra values that relate to the error.
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// errorEnvelope wraps an apiError so that clients can always look for the "error" key.
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+// jobResponse is a single job with its guid.
+type jobResponse struct {
+	GUID string `json:"guid"`
+	internalstate.JobDetails
+}
+
+// createRunResponse is a job that was just requested. Created is false if the
+// request joined a job that was already queued.
+type createRunResponse struct {
+	jobResponse
+	Created bool `json:"created"`
+}
+
+// totalCountHeader is the number of jobs that matched a list request before it was paged.
+const totalCountHeader = "X-Total-Count"
+
+// runResultHeader tells legacy clients if their request created a job or joined one.
+const runResultHeader = "X-Chefwaiter-Run"
+
+// setRunResult sets the runResultHeader to created or joined.
+func setRunResult(w http.ResponseWriter, sub chefrunner.Submission) {
+	if sub.Created {
+		w.Header().Set(runResultHeader, "created")
+		return
+	}
+	w.Header().Set(runResultHeader, "joined")
+}
+
+// jobMapResponse is the legacy form of jobs keyed by guid.
+type jobMapResponse map[string]internalstate.JobDetails
+
+// queueResponse is the run queue in the order that the jobs will run.
+type queueResponse []chefrunner.QueuedJob
+
+type intervalResponse struct {
+	CurrentInterval string `json:"current_interval"`
+	Minutes         int64  `json:"minutes"`
+}
+
+type periodicResponse struct {
+	ChefRunsEnabled bool `json:"chef_runs_enabled"`
+}
+
+type maintenanceResponse struct {
+	EndTime       string `json:"end_time"`
+	EndTimeEpoch  int64  `json:"end_time_epoch"`
+	InMaintenance bool   `json:"in_maintenance"`
+}
+
+type lockResponse struct {
+	Locked bool   `json:"locked"`
+	Reason string `json:"reason"`
+}
+
+type lastRunResponse struct {
+	LastRunGUID string `json:"last_run_guid"`
+}
+
+type nextRunResponse struct {
+	Epoch int64  `json:"epoch"`
+	Human string `json:"human"`
+}
+
+// healthResponse has the results of every check when verbose is asked for.
+type healthResponse struct {
+	State  string          `json:"state"`
+	Checks []health.Result `json:"checks,omitempty"`
+}
+
+// writeJSON will write the status code and then the JSON encoded value to the client.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonBytes, err := jsonMarshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to encode response", nil)
+		return
+	}
+	setContentJSON(w)
+	w.WriteHeader(status)
+	printJSON(w, jsonBytes)
+}
+
+// writeError will write a JSON error envelope to the client with the supplied status code.
+func writeError(w http.ResponseWriter, status int, code, message string, details map[string]string) {
+	setContentJSON(w)
+	w.WriteHeader(status)
+	jsonBytes, _ := jsonMarshal(&errorEnvelope{Error: apiError{Code: code, Message: message, Details: details}})
+	printJSON(w, jsonBytes)
+}
+
+// errEmptyBody is returned from decodeJSONBody when the client did not send a body.
+var errEmptyBody = errors.New("request body is empty")
+
+// decodeJSONBody reads a JSON body into v. Unknown fields are rejected so that
+// typos in requests are not silently ignored.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return errEmptyBody
+		}
+		return err
+	}
+	return nil
+}
+
+// writeBadBody is the error written when a request body can not be decoded.
+func writeBadBody(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, "bad_request", "Invalid request body", map[string]string{"reason": err.Error()})
+}
+
+// emergencyPriority reads the priority requested for a run. Only emergency can be
+// requested, every other run gets the priority of its type. ok is false for unknown values.
+func emergencyPriority(value string) (emergency bool, ok bool) {
+	switch value {
+	case "":
+		return false, true
+	case chefrunner.PriorityEmergency.String():
+		return true, true
+	}
+	return false, false
+}
+
+func writeBadPriority(w http.ResponseWriter) {
+	writeError(w, http.StatusBadRequest, "bad_request", "priority can only be emergency", nil)
+}
+
+// writeRunError is the error written when a run could not be queued.
+func writeRunError(w http.ResponseWriter, err error) {
+	if err == chefrunner.ErrQueueFull {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusTooManyRequests, "queue_full", "The run queue is full, try again later", nil)
+		return
+	}
+	if err == chefrunner.ErrDraining {
+		writeError(w, http.StatusServiceUnavailable, "draining", "Chefwaiter is stopping and not accepting new runs", nil)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "internal_error", "Failed to queue the run", map[string]string{"reason": err.Error()})
+}
+
+// notFound is used by the router when no route matches the request.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "not_found", "No route matches the request", map[string]string{"path": r.URL.Path})
+}
+
+// methodNotAllowed is used by the router when the route exists but not for the method used.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method is not allowed on this route", map[string]string{"method": r.Method, "path": r.URL.Path})
+}
@@ -0,0 +1,176 @@
+package webengine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+// Sort orders accepted by the list endpoints. Jobs are sorted by the time they were registered.
+const (
+	sortNewest = "newest"
+	sortOldest = "oldest"
+)
+
+// Job types accepted by the type filter.
+var jobTypes = map[string]bool{"periodic": true, "ondemand": true, "custom": true}
+
+// runFilter selects, orders and pages the jobs returned by the list endpoints.
+// Empty values match every job and a limit of 0 returns every job.
+type runFilter struct {
+	requester   string
+	pipelineURL string
+	labels      map[string]string
+	statuses    map[string]bool
+	types       map[string]bool
+	since       int64
+	until       int64
+	oldestFirst bool
+	limit       int
+	offset      int
+}
+
+// parseRunFilter reads the query parameters of the list endpoints.
+// status and type take comma separated lists. since and until are epoch times that
+// the jobs were registered between. label can be given many times as key=value and
+// a job must have all of them.
+func parseRunFilter(r *http.Request) (runFilter, error) {
+	query := r.URL.Query()
+	labels, err := internalstate.ParseLabels(query["label"])
+	if err != nil {
+		return runFilter{}, err
+	}
+	f := runFilter{
+		requester:   query.Get("requester"),
+		pipelineURL: query.Get("pipeline_url"),
+		labels:      labels,
+		statuses:    splitList(query.Get("status")),
+		types:       splitList(query.Get("type")),
+	}
+	for jobType := range f.types {
+		if !jobTypes[jobType] {
+			return runFilter{}, fmt.Errorf("type must be periodic, ondemand or custom. Got: %s", jobType)
+		}
+	}
+
+	numbers := []struct {
+		name  string
+		value *int64
+	}{
+		{name: "since", value: &f.since},
+		{name: "until", value: &f.until},
+	}
+	for _, number := range numbers {
+		if err := parsePositive(query.Get(number.name), number.name, number.value); err != nil {
+			return runFilter{}, err
+		}
+	}
+	if f.until > 0 && f.since > f.until {
+		return runFilter{}, errors.New("since can not be after until")
+	}
+
+	var limit, offset int64
+	if err := parsePositive(query.Get("limit"), "limit", &limit); err != nil {
+		return runFilter{}, err
+	}
+	if err := parsePositive(query.Get("offset"), "offset", &offset); err != nil {
+		return runFilter{}, err
+	}
+	f.limit, f.offset = int(limit), int(offset)
+
+	switch query.Get("sort") {
+	case "", sortNewest:
+	case sortOldest:
+		f.oldestFirst = true
+	default:
+		return runFilter{}, fmt.Errorf("sort must be %s or %s", sortNewest, sortOldest)
+	}
+	return f, nil
+}
+
+// splitList reads a comma separated list in to a set. An empty string is an empty set.
+func splitList(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// parsePositive reads a whole number that is 0 or more. An empty string leaves value alone.
+func parsePositive(raw, name string, value *int64) error {
+	if raw == "" {
+		return nil
+	}
+	number, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("%s must be a positive whole number. Got: %s", name, raw)
+	}
+	*value = number
+	return nil
+}
+
+// jobType returns periodic, ondemand or custom.
+func jobType(job internalstate.JobDetails) string {
+	switch {
+	case job.CustomRun:
+		return "custom"
+	case job.OnDemand:
+		return "ondemand"
+	}
+	return "periodic"
+}
+
+func (f runFilter) matches(job internalstate.JobDetails) bool {
+	if f.requester != "" && job.Requester != f.requester {
+		return false
+	}
+	if f.pipelineURL != "" && job.PipelineURL != f.pipelineURL {
+		return false
+	}
+	if len(f.statuses) > 0 && !f.statuses[job.Status] {
+		return false
+	}
+	if len(f.types) > 0 && !f.types[jobType(job)] {
+		return false
+	}
+	if job.RegisteredTime < f.since || (f.until > 0 && job.RegisteredTime > f.until) {
+		return false
+	}
+	return job.HasLabels(f.labels)
+}
+
+// list returns the page of matching jobs in order and the number of jobs that matched.
+// Jobs registered at the same time are ordered by guid so that pages are stable.
+func (f runFilter) list(jobs map[string]internalstate.JobDetails) ([]jobResponse, int) {
+	matched := make([]jobResponse, 0, len(jobs))
+	for guid, job := range jobs {
+		if f.matches(job) {
+			matched = append(matched, jobResponse{GUID: guid, JobDetails: job})
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.RegisteredTime != b.RegisteredTime {
+			return (a.RegisteredTime < b.RegisteredTime) == f.oldestFirst
+		}
+		return a.GUID < b.GUID
+	})
+
+	total := len(matched)
+	if f.offset >= total {
+		return []jobResponse{}, total
+	}
+	matched = matched[f.offset:]
+	if f.limit > 0 && f.limit < len(matched) {
+		matched = matched[:f.limit]
+	}
+	return matched, total
+}
@@ -0,0 +1,221 @@
+package webengine
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/internalstate"
+	"github.com/morfien101/chef-waiter/logs"
+)
+
+// v2RunRequest is the body accepted by POST /v2/runs.
+// An empty body or empty custom_run will request a normal on demand run.
+// Priority can be set to emergency to put the run at the front of the queue.
+// Coalesce decides if the request can join a job that is already queued, see internalstate.ParseCoalesce.
+// A policy_group and policy_name make a custom run that uses that Policyfile policy instead
+// of a run list. They must be in the allowed policies.
+// The run metadata records who requested the run and why.
+type v2RunRequest struct {
+	CustomRun string `json:"custom_run"`
+	Force     bool   `json:"force"`
+	Priority  string `json:"priority"`
+	Coalesce  string `json:"coalesce"`
+	internalstate.Policy
+	internalstate.RunMetadata
+}
+
+// v2IntervalRequest is the body accepted by PUT /v2/interval.
+type v2IntervalRequest struct {
+	Minutes int64 `json:"minutes"`
+}
+
+// v2PeriodicRequest is the body accepted by PUT /v2/periodic.
+type v2PeriodicRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// v2MaintenanceRequest is the body accepted by PUT /v2/maintenance.
+type v2MaintenanceRequest struct {
+	Minutes int64 `json:"minutes"`
+}
+
+// v2LockRequest is the optional body accepted by PUT /v2/lock.
+type v2LockRequest struct {
+	Reason string `json:"reason"`
+}
+
+// registerV2Routes adds the /v2/ routes to the router. These routes use the
+// HTTP verbs to describe the action and JSON bodies to carry values.
+func (e *HTTPEngine) registerV2Routes() {
+	v2 := e.router.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/runs", e.v2CreateRun).Methods(http.MethodPost)
+	v2.HandleFunc("/runs", e.v2ListRuns).Methods(http.MethodGet)
+	v2.HandleFunc("/runs/bundle", e.v2CreateBundleRun).Methods(http.MethodPost)
+	v2.HandleFunc("/runs/{guid}", e.v2GetRun).Methods(http.MethodGet)
+	v2.HandleFunc("/runs/{guid}/logs", e.getChefLogs).Methods(http.MethodGet)
+	v2.HandleFunc("/runs/{guid}/hooks", e.getHookLogs).Methods(http.MethodGet)
+	v2.HandleFunc("/interval", e.getChefRunInterval).Methods(http.MethodGet)
+	v2.HandleFunc("/interval", e.v2SetInterval).Methods(http.MethodPut)
+	v2.HandleFunc("/periodic", e.getChefPeridoicRunStatus).Methods(http.MethodGet)
+	v2.HandleFunc("/periodic", e.v2SetPeriodic).Methods(http.MethodPut)
+	v2.HandleFunc("/maintenance", e.getChefMaintenance).Methods(http.MethodGet)
+	v2.HandleFunc("/maintenance", e.v2SetMaintenance).Methods(http.MethodPut)
+	v2.HandleFunc("/maintenance", e.removeChefMaintenance).Methods(http.MethodDelete)
+	v2.HandleFunc("/lock", e.getChefLock).Methods(http.MethodGet)
+	v2.HandleFunc("/lock", e.v2SetLock).Methods(http.MethodPut)
+	v2.HandleFunc("/lock", e.removeChefLock).Methods(http.MethodDelete)
+	v2.HandleFunc("/status", e.getStatus).Methods(http.MethodGet)
+}
+
+func (e *HTTPEngine) v2CreateRun(w http.ResponseWriter, r *http.Request) {
+	req := &v2RunRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil && err != errEmptyBody {
+		writeBadBody(w, err)
+		return
+	}
+	if len(req.CustomRun) > 512 {
+		writeError(w, http.StatusBadRequest, "bad_request", "custom_run is too large. Max size 512 bytes", nil)
+		return
+	}
+
+	if req.Policy.IsSet() {
+		if err := req.Policy.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+			return
+		}
+		if req.CustomRun != "" {
+			writeError(w, http.StatusBadRequest, "bad_request", "custom_run can not be used with a policy", nil)
+			return
+		}
+	}
+
+	// The lock can only be forced for custom runs, same as the legacy API.
+	custom := req.CustomRun != "" || req.Policy.IsSet()
+	if e.state.ReadRunLock() && !(custom && req.Force) {
+		writeError(w, http.StatusForbidden, "locked", "Chefwaiter is locked", nil)
+		return
+	}
+
+	emergency, ok := emergencyPriority(req.Priority)
+	if !ok {
+		writeBadPriority(w)
+		return
+	}
+
+	coalesce, err := internalstate.ParseCoalesce(req.Coalesce)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return
+	}
+
+	if err := req.RunMetadata.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+		return
+	}
+
+	if custom {
+		if req.Policy.IsSet() && !e.policyAllowed(req.Policy) {
+			writeError(w, http.StatusForbidden, "not_whitelisted", fmt.Sprintf("Policy '%s' is not allowed", req.Policy), map[string]string{"policy_group": req.PolicyGroup, "policy_name": req.PolicyName})
+			return
+		}
+		if req.CustomRun != "" && !e.customRunAllowed(req.CustomRun) {
+			writeError(w, http.StatusForbidden, "not_whitelisted", fmt.Sprintf("Whitelist does not contain '%s'", req.CustomRun), map[string]string{"custom_run": req.CustomRun})
+			return
+		}
+		if req.Force {
+			e.logger.Infof("Running a custom job regardless of lock from %s\n", r.RemoteAddr)
+		}
+	}
+	sub, err := e.submitRun(custom, req.CustomRun, emergency, chefrunner.RunOptions{Coalesce: coalesce, Metadata: req.RunMetadata, Policy: req.Policy})
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+	logs.DebugMessage(fmt.Sprintf("v2CreateRun() - %s", sub.GUID))
+	e.writeCreatedRun(w, sub)
+}
+
+// writeCreatedRun writes the job that a run request created or joined.
+func (e *HTTPEngine) writeCreatedRun(w http.ResponseWriter, sub chefrunner.Submission) {
+	job, ok := e.state.ReadJob(sub.GUID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s not found", sub.GUID), map[string]string{"guid": sub.GUID})
+		return
+	}
+	setRunResult(w, sub)
+	writeJSON(w, http.StatusAccepted, &createRunResponse{jobResponse: jobResponse{GUID: sub.GUID, JobDetails: job}, Created: sub.Created})
+}
+
+func (e *HTTPEngine) v2ListRuns(w http.ResponseWriter, r *http.Request) {
+	jobs, ok := e.listRuns(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (e *HTTPEngine) v2GetRun(w http.ResponseWriter, r *http.Request) {
+	e.writeJob(w, http.StatusOK, mux.Vars(r)["guid"])
+}
+
+// writeJob looks up the guid and writes it out, or a 404 if we don't know about it.
+func (e *HTTPEngine) writeJob(w http.ResponseWriter, status int, guid string) {
+	job, ok := e.state.ReadJob(guid)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s not found", guid), map[string]string{"guid": guid})
+		return
+	}
+	writeJSON(w, status, &jobResponse{GUID: guid, JobDetails: job})
+}
+
+func (e *HTTPEngine) v2SetInterval(w http.ResponseWriter, r *http.Request) {
+	req := &v2IntervalRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeBadBody(w, err)
+		return
+	}
+	if req.Minutes <= 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "Only a positive number will be accepted", nil)
+		return
+	}
+	e.state.WriteChefRunTimer(req.Minutes)
+	e.getChefRunInterval(w, r)
+}
+
+func (e *HTTPEngine) v2SetPeriodic(w http.ResponseWriter, r *http.Request) {
+	req := &v2PeriodicRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeBadBody(w, err)
+		return
+	}
+	e.state.WritePeriodicRuns(req.Enabled)
+	e.getChefPeridoicRunStatus(w, r)
+}
+
+func (e *HTTPEngine) v2SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	req := &v2MaintenanceRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil {
+		writeBadBody(w, err)
+		return
+	}
+	if req.Minutes <= 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "Only a positive number will be accepted", nil)
+		return
+	}
+	e.state.WriteMaintenanceTimeEnd(time.Now().Unix() + req.Minutes*60)
+	e.getChefMaintenance(w, r)
+}
+
+func (e *HTTPEngine) v2SetLock(w http.ResponseWriter, r *http.Request) {
+	req := &v2LockRequest{}
+	if err := decodeJSONBody(w, r, req); err != nil && err != errEmptyBody {
+		writeBadBody(w, err)
+		return
+	}
+	e.state.LockRunsWithReason(req.Reason)
+	e.getChefLock(w, r)
+}
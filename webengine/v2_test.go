@@ -0,0 +1,323 @@
+package webengine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/morfien101/chef-waiter/chefrunner"
+	"github.com/morfien101/chef-waiter/internalstate"
+)
+
+func TestV2Routes(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+		errorCode    string
+	}{
+		{name: "Get lock", method: http.MethodGet, url: "/v2/lock", expectedCode: http.StatusOK},
+		{name: "Set lock", method: http.MethodPut, url: "/v2/lock", expectedCode: http.StatusOK},
+		{name: "Run while locked", method: http.MethodPost, url: "/v2/runs", expectedCode: http.StatusForbidden, errorCode: "locked"},
+		{name: "Forced custom run while locked", method: http.MethodPost, url: "/v2/runs", body: `{"custom_run":"recipe[chefwaiter::test]","force":true}`, expectedCode: http.StatusAccepted},
+		{name: "Remove lock", method: http.MethodDelete, url: "/v2/lock", expectedCode: http.StatusOK},
+		{name: "On demand run", method: http.MethodPost, url: "/v2/runs", expectedCode: http.StatusAccepted},
+		{name: "Read run", method: http.MethodGet, url: "/v2/runs/onde-1234-1234-1234-1234", expectedCode: http.StatusOK},
+		{name: "Read missing run", method: http.MethodGet, url: "/v2/runs/nope", expectedCode: http.StatusNotFound, errorCode: "not_found"},
+		{name: "Emergency run", method: http.MethodPost, url: "/v2/runs", body: `{"priority":"emergency"}`, expectedCode: http.StatusAccepted},
+		{name: "Unknown priority", method: http.MethodPost, url: "/v2/runs", body: `{"priority":"urgent"}`, expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Read queue", method: http.MethodGet, url: "/chef/queue", expectedCode: http.StatusOK},
+		{name: "Unknown field", method: http.MethodPost, url: "/v2/runs", body: `{"recipe":"x"}`, expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Set interval", method: http.MethodPut, url: "/v2/interval", body: `{"minutes":15}`, expectedCode: http.StatusOK},
+		{name: "Set bad interval", method: http.MethodPut, url: "/v2/interval", body: `{"minutes":-1}`, expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Set interval no body", method: http.MethodPut, url: "/v2/interval", expectedCode: http.StatusBadRequest, errorCode: "bad_request"},
+		{name: "Disable periodic", method: http.MethodPut, url: "/v2/periodic", body: `{"enabled":false}`, expectedCode: http.StatusOK},
+		{name: "Start maintenance", method: http.MethodPut, url: "/v2/maintenance", body: `{"minutes":30}`, expectedCode: http.StatusOK},
+		{name: "End maintenance", method: http.MethodDelete, url: "/v2/maintenance", expectedCode: http.StatusOK},
+		{name: "Wrong method", method: http.MethodPost, url: "/v2/lock", expectedCode: http.StatusMethodNotAllowed, errorCode: "method_not_allowed"},
+		{name: "Unknown route", method: http.MethodGet, url: "/v2/nothing", expectedCode: http.StatusNotFound, errorCode: "not_found"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, url(test.url), strings.NewReader(test.body))
+		webEngine.ServeHTTP(w, r)
+		result := w.Result()
+		body, err := ioutil.ReadAll(result.Body)
+		result.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: Failed to read the body. Error: %s", test.name, err)
+		}
+
+		if result.StatusCode != test.expectedCode {
+			t.Errorf("%s: %s %s returned %d, want %d. Body: %s", test.name, test.method, test.url, result.StatusCode, test.expectedCode, body)
+		}
+		if test.errorCode != "" {
+			envelope := &errorEnvelope{}
+			if err := json.Unmarshal(body, envelope); err != nil {
+				t.Errorf("%s: Error body is not a JSON envelope. Error: %s", test.name, err)
+				continue
+			}
+			if envelope.Error.Code != test.errorCode {
+				t.Errorf("%s: Error code incorrect. Got: %s, Want: %s", test.name, envelope.Error.Code, test.errorCode)
+			}
+		}
+	}
+
+	if webEngine.state.ReadPeriodicRuns() {
+		t.Error("Periodic runs should have been disabled by PUT /v2/periodic")
+	}
+	if webEngine.state.ReadChefRunTimer() != 15*60 {
+		t.Errorf("Interval was not set by PUT /v2/interval. Got: %d", webEngine.state.ReadChefRunTimer())
+	}
+}
+
+func TestLegacyGetMutatorsDisabled(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	webEngine.SetLegacyGetMutators(false)
+
+	tests := []struct {
+		url          string
+		expectedCode int
+	}{
+		{url: "/chefclient", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/interval/10", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/on", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/off", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/maintenance/start/10", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/maintenance/end", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/lock/set", expectedCode: http.StatusMethodNotAllowed},
+		{url: "/chef/lock/remove", expectedCode: http.StatusMethodNotAllowed},
+		// Readers are not affected
+		{url: "/chef/lock", expectedCode: http.StatusOK},
+		{url: "/chef/interval", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url(test.url), nil)
+		webEngine.ServeHTTP(w, r)
+		if w.Result().StatusCode != test.expectedCode {
+			t.Errorf("GET %s returned %d, want %d", test.url, w.Result().StatusCode, test.expectedCode)
+		}
+		// The body must match the 405 that the router writes.
+		if test.expectedCode == http.StatusMethodNotAllowed {
+			envelope := &errorEnvelope{}
+			json.NewDecoder(w.Result().Body).Decode(envelope)
+			if envelope.Error.Code != "method_not_allowed" {
+				t.Errorf("GET %s should return the method_not_allowed error envelope. Got: %s", test.url, w.Body)
+			}
+		}
+	}
+
+	if webEngine.state.ReadRunLock() {
+		t.Error("Disabled /chef/lock/set still locked the chef waiter")
+	}
+}
+
+func TestRunQueueFull(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	webEngine.worker.(*chefrunner.FakeChefRunnerWorker).QueueFull = true
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{method: http.MethodPost, url: "/v2/runs"},
+		{method: http.MethodPost, url: "/v2/runs", body: `{"custom_run":"recipe[chefwaiter::test]"}`},
+		{method: http.MethodGet, url: "/chefclient"},
+		{method: http.MethodPost, url: "/chefclient?priority=emergency", body: "recipe[chefwaiter::test]"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(test.method, url(test.url), strings.NewReader(test.body)))
+		envelope := &errorEnvelope{}
+		json.NewDecoder(w.Result().Body).Decode(envelope)
+		if w.Code != http.StatusTooManyRequests || envelope.Error.Code != "queue_full" {
+			t.Errorf("%s %s with a full queue should be 429 queue_full. Got: %d %s", test.method, test.url, w.Code, envelope.Error.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s with a full queue should set Retry-After", test.method, test.url)
+		}
+	}
+}
+
+func TestRunDraining(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	webEngine.worker.(*chefrunner.FakeChefRunnerWorker).Draining = true
+
+	for _, path := range []string{"/v2/runs", "/chefclient"} {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url(path), nil))
+		envelope := &errorEnvelope{}
+		json.NewDecoder(w.Result().Body).Decode(envelope)
+		if w.Code != http.StatusServiceUnavailable || envelope.Error.Code != "draining" {
+			t.Errorf("POST %s while stopping should be 503 draining. Got: %d %s", path, w.Code, envelope.Error.Code)
+		}
+	}
+}
+
+func TestRunMetadata(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+
+	body := `{"requester":"deploy-bot","reason":"release 1.2","pipeline_url":"https://ci.example.com/builds/42","labels":{"team":"web"}}`
+	w := httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url("/v2/runs"), strings.NewReader(body)))
+	created := &createRunResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(created); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("Run with metadata failed. Code: %d, Error: %v", w.Code, err)
+	}
+	if created.Requester != "deploy-bot" || created.Reason != "release 1.2" || created.Labels["team"] != "web" {
+		t.Errorf("Metadata was not recorded. Got: %+v", created.RunMetadata)
+	}
+
+	w = httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url("/chefclient?requester=alice&label=team=db&label=ticket=OPS-1"), strings.NewReader("recipe[chefwaiter::test]")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Legacy run with metadata failed. Code: %d", w.Code)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "/chef/allruns", want: []string{"onde-1234-1234-1234-1234", "cust-1234-1234-1234-1234"}},
+		{url: "/chef/allruns?requester=alice", want: []string{"cust-1234-1234-1234-1234"}},
+		{url: "/chef/allruns?label=team=web", want: []string{"onde-1234-1234-1234-1234"}},
+		{url: "/chef/allruns?label=team=db&label=ticket=OPS-1", want: []string{"cust-1234-1234-1234-1234"}},
+		{url: "/chef/allruns?label=team=db&label=ticket=OPS-2", want: []string{}},
+		{url: "/chef/allruns?pipeline_url=https://ci.example.com/builds/42", want: []string{"onde-1234-1234-1234-1234"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url(test.url), nil))
+		jobs := jobMapResponse{}
+		if err := json.NewDecoder(w.Result().Body).Decode(&jobs); err != nil {
+			t.Fatalf("%s returned bad JSON. Error: %s", test.url, err)
+		}
+		if len(jobs) != len(test.want) {
+			t.Errorf("%s returned %d jobs, want %d", test.url, len(jobs), len(test.want))
+		}
+		for _, guid := range test.want {
+			if _, ok := jobs[guid]; !ok {
+				t.Errorf("%s is missing %s", test.url, guid)
+			}
+		}
+	}
+
+	bad := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{method: http.MethodPost, url: "/v2/runs", body: `{"pipeline_url":"ci.example.com"}`},
+		{method: http.MethodPost, url: "/v2/runs", body: `{"labels":{"bad key":"x"}}`},
+		{method: http.MethodGet, url: "/chefclient?label=team"},
+		{method: http.MethodGet, url: "/v2/runs?label=team"},
+	}
+	for _, test := range bad {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(test.method, url(test.url), strings.NewReader(test.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s %s should be a 400. Got: %d", test.method, test.url, test.body, w.Code)
+		}
+	}
+}
+
+func TestPolicyRuns(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	webEngine.SetAllowedPolicies([]internalstate.Policy{{PolicyGroup: "canary", PolicyName: "webserver"}})
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{body: `{"policy_group":"canary","policy_name":"webserver"}`, code: http.StatusAccepted},
+		{body: `{"policy_group":"prod","policy_name":"webserver"}`, code: http.StatusForbidden},
+		{body: `{"policy_group":"canary"}`, code: http.StatusBadRequest},
+		{body: `{"policy_group":"canary","policy_name":"web server"}`, code: http.StatusBadRequest},
+		{body: `{"policy_group":"canary","policy_name":"webserver","custom_run":"recipe[base]"}`, code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url("/v2/runs"), strings.NewReader(test.body)))
+		if w.Code != test.code {
+			t.Errorf("%s should return %d. Got: %d %s", test.body, test.code, w.Code, w.Body.String())
+		}
+	}
+
+	webEngine.SetAllowedPolicies(nil)
+	w := httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url("/v2/runs"), strings.NewReader(tests[0].body)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Policies should not be allowed when none are configured. Got: %d", w.Code)
+	}
+}
+
+func TestListRunsQuery(t *testing.T) {
+	webEngine := genNewHTTPServer(t, false, false)
+	webEngine.state.(*internalstate.StateTable).Status = map[string]*internalstate.JobDetails{
+		"periodic-1": &internalstate.JobDetails{Status: "complete", RegisteredTime: 100},
+		"demand-1":   &internalstate.JobDetails{Status: "failed", RegisteredTime: 200, OnDemand: true},
+		"custom-1":   &internalstate.JobDetails{Status: "failed", RegisteredTime: 300, OnDemand: true, CustomRun: true},
+		"custom-2":   &internalstate.JobDetails{Status: "complete", RegisteredTime: 400, OnDemand: true, CustomRun: true},
+		"custom-3":   &internalstate.JobDetails{Status: "failed", RegisteredTime: 400, OnDemand: true, CustomRun: true},
+	}
+
+	tests := []struct {
+		url   string
+		want  []string
+		total string
+	}{
+		{url: "/v2/runs", want: []string{"custom-2", "custom-3", "custom-1", "demand-1", "periodic-1"}, total: "5"},
+		{url: "/v2/runs?sort=oldest", want: []string{"periodic-1", "demand-1", "custom-1", "custom-2", "custom-3"}, total: "5"},
+		{url: "/v2/runs?status=failed&type=custom&limit=1", want: []string{"custom-3"}, total: "2"},
+		{url: "/v2/runs?type=periodic,ondemand", want: []string{"demand-1", "periodic-1"}, total: "2"},
+		{url: "/v2/runs?since=200&until=300", want: []string{"custom-1", "demand-1"}, total: "2"},
+		{url: "/v2/runs?limit=2&offset=2", want: []string{"custom-1", "demand-1"}, total: "5"},
+		{url: "/v2/runs?offset=10", want: []string{}, total: "5"},
+		{url: "/chef/allruns?format=list&status=complete", want: []string{"custom-2", "periodic-1"}, total: "2"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url(test.url), nil))
+		jobs := []jobResponse{}
+		if err := json.NewDecoder(w.Result().Body).Decode(&jobs); err != nil {
+			t.Fatalf("%s returned bad JSON. Error: %s", test.url, err)
+		}
+		got := make([]string, len(jobs))
+		for i, job := range jobs {
+			got[i] = job.GUID
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s returned the wrong jobs. Got: %v, Want: %v", test.url, got, test.want)
+		}
+		if total := w.Header().Get(totalCountHeader); total != test.total {
+			t.Errorf("%s has the wrong total. Got: %s, Want: %s", test.url, total, test.total)
+		}
+	}
+
+	// The map form is still the default and is paged in the same way.
+	w := httptest.NewRecorder()
+	webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url("/chef/allruns?type=custom&limit=2"), nil))
+	jobMap := jobMapResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&jobMap); err != nil || len(jobMap) != 2 {
+		t.Errorf("/chef/allruns should return a map of 2 jobs. Got: %v, Error: %v", jobMap, err)
+	}
+
+	for _, bad := range []string{"/v2/runs?type=manual", "/v2/runs?limit=-1", "/v2/runs?since=yesterday", "/v2/runs?since=300&until=200", "/v2/runs?sort=up", "/chef/allruns?format=xml"} {
+		w := httptest.NewRecorder()
+		webEngine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url(bad), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s should be a 400. Got: %d", bad, w.Code)
+		}
+	}
+}